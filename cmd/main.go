@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	echoSwagger "github.com/swaggo/echo-swagger"
 
 	_ "github.com/sarthak0714/backend-task-sc/docs"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/auth"
 	"github.com/sarthak0714/backend-task-sc/internal/adapters/handlers"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/prices"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/ratelimit"
 	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
 	"github.com/sarthak0714/backend-task-sc/internal/config"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
 	"github.com/sarthak0714/backend-task-sc/internal/core/services"
 	"github.com/sarthak0714/backend-task-sc/pkg/utils"
 )
@@ -19,45 +32,282 @@ import (
 // @title smallcase Backend Task
 // @version 1.0
 // @description portfolio tracking API.
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT as "Bearer <token>", its subject is the user the request acts for
+// @securityDefinitions.apikey APIKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key of a service client, created by an admin
 func main() {
-	// Initialize SQL repository
 	cfg := config.LoadConfig()
 
-	tradeRepo, portfolioRepo, err := repositories.NewpgRepository(cfg.DatabaseURL)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// Initialize repositories
+
+	repos, err := newRepositories(cfg)
 	if err != nil {
 		log.Fatalf("Error initializing repository: %v", err)
 	}
 
+	priceProvider, err := newPriceProvider(cfg)
+	if err != nil {
+		log.Fatalf("Error initializing price provider: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// With the refresher on, reads are served from the quote cache it fills
+	var refresher ports.PriceRefresher
+	if cfg.PriceRefreshEnabled {
+		refresher = services.NewPriceRefresher(repos.Portfolios, repos.Quotes, priceProvider, cfg.PriceRefreshInterval)
+		refresher.Start(ctx)
+		priceProvider = prices.NewCachedProvider(repos.Quotes)
+	}
+
 	// Initialize services
-	tradeService := services.NewTradeService(tradeRepo, portfolioRepo)
-	portfolioService := services.NewPortfolioService(portfolioRepo)
+	tradeService := services.NewTradeService(repos.Trades, repos.Portfolios)
+	portfolioService := services.NewPortfolioService(repos.Portfolios, priceProvider, cfg.PriceMaxAge)
+	idempotencyService := services.NewIdempotencyService(repos.Idempotency, cfg.IdempotencyRetention)
+	idempotencyService.Start(ctx)
+	apiKeyService := services.NewAPIKeyService(repos.APIKeys)
+	accessService := services.NewAccessService(repos.Advisors)
+	accountService := services.NewAccountService(repos.Accounts)
+
+	authMiddleware, err := newAuthMiddleware(cfg, apiKeyService)
+	if err != nil {
+		log.Fatalf("Error initializing authentication: %v", err)
+	}
+
+	addressLimitMiddleware, rateLimitMiddleware, err := newRateLimitMiddleware(cfg)
+	if err != nil {
+		log.Fatalf("Error initializing rate limiting: %v", err)
+	}
 
 	e := echo.New()
 	e.HideBanner = true
+	// Forwarded headers are set by clients as they like unless a proxy in front overwrites them
+	e.IPExtractor = echo.ExtractIPDirect()
+	if cfg.TrustProxyHeaders {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
+	// Domain errors returned by handlers become problem+json responses
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
 	// Middleware
 	e.Use(utils.CustomLogger())
 	e.Use(middleware.Recover())
+	e.Use(addressLimitMiddleware)
+	e.Use(authMiddleware)
+	e.Use(rateLimitMiddleware)
+	e.Use(utils.RequestTimeout(cfg.RequestTimeout, func(c echo.Context) bool {
+		// downloads stream for as long as they need, a client disconnect still cancels them
+		return strings.HasSuffix(c.Path(), "/export")
+	}))
 
 	// Initialize handlers
-	h := handlers.NewAPIHandler(tradeService, portfolioService)
+	h := handlers.NewAPIHandler(tradeService, portfolioService, idempotencyService, apiKeyService, accessService, accountService)
 
 	e.GET("/", func(c echo.Context) error {
 		return c.Redirect(http.StatusMovedPermanently, "/swagger/index.html")
 	})
 	e.GET("/status", h.Root)
 
+	// Every route names the permission it needs and the user it acts on, see domain.Role for who holds which.
+	// POST /trades and the import check the users in their body themselves.
+	user, caller := handlers.PathUser("userId"), handlers.QueryUser("userId")
+	trade, anyTrade := h.TradeOwner("id"), h.AnyTradeOwner("id")
+	account := h.AccountOwner("accountId")
+
 	// Trade Routes
-	e.POST("/trades", h.AddTrade)
-	e.PUT("/trades/:id", h.UpdateTrade)
-	e.DELETE("/trades/:id", h.RemoveTrade)
-	e.GET("/trades/:userId", h.FetchTrades)
+	e.POST("/trades", h.AddTrade, h.Require(domain.WriteTrades, nil))
+	e.POST("/trades/import", h.ImportTrades, h.Require(domain.ImportTrades, nil))
+	e.PUT("/trades/:id", h.UpdateTrade, h.Require(domain.WriteTrades, trade))
+	e.DELETE("/trades/:id", h.RemoveTrade, h.Require(domain.WriteTrades, trade))
+	e.POST("/trades/:id/restore", h.RestoreTrade, h.Require(domain.WriteTrades, anyTrade))
+	e.GET("/trades/:id/history", h.FetchTradeHistory, h.Require(domain.ReadTrades, anyTrade))
+	e.GET("/trades/:userId", h.FetchTrades, h.Require(domain.ReadTrades, user))
+	e.GET("/trades/:userId/export", h.ExportTrades, h.Require(domain.ReadTrades, user))
 
 	//Portfolio Routes
-	e.GET("/portfolio/:userId", h.FetchPortfolio)
-	e.GET("/returns", h.FetchReturns)
+	e.GET("/portfolio/:userId", h.FetchPortfolio, h.Require(domain.ReadPortfolio, user))
+	e.GET("/portfolio/:userId/lots", h.FetchLots, h.Require(domain.ReadPortfolio, user))
+	e.GET("/portfolio/:userId/export", h.ExportPortfolio, h.Require(domain.ReadPortfolio, user))
+	e.GET("/returns", h.FetchReturns, h.Require(domain.ReadPortfolio, caller))
+	e.GET("/returns/export", h.ExportReturns, h.Require(domain.ReadPortfolio, caller))
+
+	// User Routes
+	e.GET("/users/:userId/cost-basis", h.FetchCostBasis, h.Require(domain.ReadPortfolio, user))
+	e.PUT("/users/:userId/cost-basis", h.SetCostBasis, h.Require(domain.WriteSettings, user))
+
+	// Account Routes
+	e.GET("/users/:userId/accounts", h.FetchAccounts, h.Require(domain.ReadPortfolio, user))
+	e.POST("/users/:userId/accounts", h.CreateAccount, h.Require(domain.ManageAccounts, user))
+	e.GET("/accounts/:accountId", h.FetchAccount, h.Require(domain.ReadPortfolio, account))
+	e.PUT("/accounts/:accountId", h.UpdateAccount, h.Require(domain.ManageAccounts, account))
+	e.DELETE("/accounts/:accountId", h.RemoveAccount, h.Require(domain.ManageAccounts, account))
+	e.GET("/accounts/:accountId/portfolio", h.FetchAccountPortfolio, h.Require(domain.ReadPortfolio, account))
+
+	// Admin Routes
+	e.POST("/admin/rebuild", h.RebuildPortfolios, h.Require(domain.RebuildLedger, nil))
+	e.POST("/admin/api-keys", h.CreateAPIKey, h.Require(domain.ManageAPIKeys, nil))
+	e.GET("/admin/api-keys", h.ListAPIKeys, h.Require(domain.ManageAPIKeys, nil))
+	e.DELETE("/admin/api-keys/:id", h.RevokeAPIKey, h.Require(domain.ManageAPIKeys, nil))
+	e.GET("/admin/advisors/:advisorId/clients", h.FetchClients, h.Require(domain.ManageAdvisors, nil))
+	e.PUT("/admin/advisors/:advisorId/clients/:clientId", h.LinkClient, h.Require(domain.ManageAdvisors, nil))
+	e.DELETE("/admin/advisors/:advisorId/clients/:clientId", h.UnlinkClient, h.Require(domain.ManageAdvisors, nil))
 
 	// Swagger route
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
-	e.Logger.Fatal(e.Start(cfg.Port))
+	go func() {
+		if err := e.Start(cfg.Port); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// Graceful shutdown on SIGINT / SIGTERM
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Error(err)
+	}
+	if refresher != nil {
+		refresher.Stop()
+	}
+}
+
+// Handles `migrate up`, `migrate down [steps]` and `migrate status`
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [steps] | status")
+	}
+
+	migrator, err := repositories.OpenMigrator(cfg.StorageDriver, cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		rolledBack, err := migrator.Down(steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// Picks the storage backend from config
+func newRepositories(cfg *config.Config) (*repositories.Repositories, error) {
+	switch cfg.StorageDriver {
+	case "postgres":
+		return repositories.NewpgRepository(cfg.DatabaseURL, cfg.DBAutoMigrate)
+	case "sqlite":
+		return repositories.NewSQLiteRepository(cfg.DatabaseURL, cfg.DBAutoMigrate)
+	case "memory":
+		return repositories.NewMemoryRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
+
+// Bearer token and API key authentication from config, every route but the status and docs pages requires one
+func newAuthMiddleware(cfg *config.Config, apiKeys ports.Authenticator) (echo.MiddlewareFunc, error) {
+	if cfg.AuthDisabled {
+		log.Println("Authentication is disabled, every request acts as an admin")
+		return handlers.AllowAnonymous(), nil
+	}
+	authenticator, err := auth.NewJWTAuthenticator(auth.JWTOptions{
+		Secret:        cfg.JWTSecret,
+		JWKSFile:      cfg.JWTJWKSFile,
+		Issuer:        cfg.JWTIssuer,
+		Audience:      cfg.JWTAudience,
+		AdminSubjects: cfg.AdminSubjects,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return handlers.Authenticate(authenticator, apiKeys, publicRoute), nil
+}
+
+// Status and docs pages, open to everyone without limits
+func publicRoute(c echo.Context) bool {
+	path := c.Path()
+	return path == "/" || path == "/status" || strings.HasPrefix(path, "/swagger/")
+}
+
+// Per address and per client quotas from config, kept in Redis when a URL is set and in memory otherwise.
+// The address limit goes before authentication and the client limit after it.
+func newRateLimitMiddleware(cfg *config.Config) (address, client echo.MiddlewareFunc, err error) {
+	if !cfg.RateLimitEnabled {
+		log.Println("Rate limiting is disabled")
+		noop := func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+		return noop, noop, nil
+	}
+	store := ratelimit.NewMemoryStore()
+	if cfg.RateLimitRedisURL != "" {
+		if store, err = ratelimit.NewRedisStore(cfg.RateLimitRedisURL); err != nil {
+			return nil, nil, err
+		}
+	}
+	limiter := services.NewRateLimiter(store,
+		domain.Quota{Limit: cfg.RateLimitRead, Period: cfg.RateLimitPeriod},
+		domain.Quota{Limit: cfg.RateLimitWrite, Period: cfg.RateLimitPeriod},
+		domain.Quota{Limit: cfg.RateLimitAddress, Period: cfg.RateLimitPeriod})
+	return handlers.RateLimitAddress(limiter, publicRoute), handlers.RateLimit(limiter, publicRoute), nil
+}
+
+// Picks the market price source from config
+func newPriceProvider(cfg *config.Config) (ports.PriceProvider, error) {
+	switch cfg.PriceProvider {
+	case "file":
+		return prices.NewFileProvider(cfg.PriceFile)
+	case "http":
+		return prices.NewHTTPProvider(cfg.PriceAPIURL, cfg.PriceTimeout), nil
+	default:
+		return nil, fmt.Errorf("unknown price provider %q", cfg.PriceProvider)
+	}
 }
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// Clock skew tolerated on exp, nbf and iat
+const clockLeeway = 30 * time.Second
+
+// Settings of the JWT authenticator, at least one of Secret and JWKSFile is required
+type JWTOptions struct {
+	// Shared key of HS256 tokens
+	Secret string
+	// JSON Web Key Set with the public keys of RS256 tokens
+	JWKSFile string
+	// Expected iss and aud claims, not checked when empty
+	Issuer   string
+	Audience string
+	// Subjects given the admin role whatever their role claim says
+	AdminSubjects []string
+}
+
+// Registered claims plus the role of the caller, admin, advisor or investor
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Role domain.Role `json:"role,omitempty"`
+}
+
+// Verifies HS256 tokens against a shared secret and RS256 tokens against keys from a local JWKS file
+type jwtAuthenticator struct {
+	secret []byte
+	keys   *keySet
+	parser *jwt.Parser
+	admins map[string]bool
+}
+
+// Creates a new JWT backed Authenticator
+func NewJWTAuthenticator(opts JWTOptions) (ports.Authenticator, error) {
+	a := &jwtAuthenticator{admins: make(map[string]bool, len(opts.AdminSubjects))}
+	var methods []string
+	if opts.Secret != "" {
+		a.secret = []byte(opts.Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if opts.JWKSFile != "" {
+		keys, err := loadKeySet(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no JWT signing key configured, set JWT_SECRET or JWT_JWKS_FILE")
+	}
+
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired(), jwt.WithLeeway(clockLeeway)}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	a.parser = jwt.NewParser(parserOpts...)
+
+	for _, subject := range opts.AdminSubjects {
+		a.admins[subject] = true
+	}
+	return a, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(_ context.Context, token string) (*domain.Principal, error) {
+	claims := &tokenClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.key); err != nil {
+		return nil, &domain.UnauthorizedError{Message: fmt.Sprintf("invalid token: %v", err)}
+	}
+	if claims.Subject == "" {
+		return nil, &domain.UnauthorizedError{Message: "invalid token: missing subject"}
+	}
+
+	role := claims.Role
+	switch {
+	case a.admins[claims.Subject]:
+		role = domain.Admin
+	case role == "":
+		role = domain.DefaultRole
+	case role == domain.Service || !role.Valid():
+		return nil, &domain.UnauthorizedError{Message: fmt.Sprintf("invalid token: unknown role %q", role)}
+	}
+	return &domain.Principal{Subject: claims.Subject, UserID: claims.Subject, Role: role}, nil
+}
+
+// Picks the verification key by the token's algorithm, and by its kid for RS256
+func (a *jwtAuthenticator) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return a.secret, nil
+	case *jwt.SigningMethodRSA:
+		kid, _ := token.Header["kid"].(string)
+		return a.keys.lookup(kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+const testSecret = "s3cret"
+
+// RSA key published in a JWKS file under kid "k1"
+type testKey struct {
+	private *rsa.PrivateKey
+	jwks    string
+}
+
+func newTestKey(t *testing.T) *testKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	document := map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(private.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes()),
+	}}}
+	data, err := json.Marshal(document)
+	if err != nil {
+		t.Fatalf("encoding JWKS: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing JWKS: %v", err)
+	}
+	return &testKey{private: private, jwks: path}
+}
+
+// Claims valid for the next hour for subject, changed by the test cases
+func validClaims(subject string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+func signHS256(t *testing.T, claims jwt.MapClaims, secret []byte) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return token
+}
+
+func signRS256(t *testing.T, claims jwt.MapClaims, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return signed
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key := newTestKey(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: mustMarshalPublicKey(t, &key.private.PublicKey)})
+
+	with := func(claims jwt.MapClaims, change func(jwt.MapClaims)) jwt.MapClaims {
+		change(claims)
+		return claims
+	}
+	both := JWTOptions{Secret: testSecret, JWKSFile: key.jwks, AdminSubjects: []string{"root"}}
+	rsaOnly := JWTOptions{JWKSFile: key.jwks}
+	checked := JWTOptions{Secret: testSecret, Issuer: "https://issuer.example", Audience: "portfolio"}
+
+	tests := []struct {
+		name  string
+		opts  JWTOptions
+		token string
+		// nil when the token must be rejected
+		want *domain.Principal
+	}{
+		{
+			name:  "HS256",
+			opts:  both,
+			token: signHS256(t, validClaims("u1"), []byte(testSecret)),
+			want:  &domain.Principal{Subject: "u1", UserID: "u1", Role: domain.Investor},
+		},
+		{
+			name:  "RS256",
+			opts:  both,
+			token: signRS256(t, validClaims("u1"), key.private, "k1"),
+			want:  &domain.Principal{Subject: "u1", UserID: "u1", Role: domain.Investor},
+		},
+		{
+			// a set holding one key is used for tokens without a kid
+			name:  "RS256WithoutKid",
+			opts:  rsaOnly,
+			token: signRS256(t, validClaims("u1"), key.private, ""),
+			want:  &domain.Principal{Subject: "u1", UserID: "u1", Role: domain.Investor},
+		},
+		{
+			name:  "RS256UnknownKid",
+			opts:  both,
+			token: signRS256(t, validClaims("u1"), key.private, "k2"),
+		},
+		{
+			name:  "RS256SignedByOtherKey",
+			opts:  both,
+			token: signRS256(t, validClaims("u1"), otherKey, "k1"),
+		},
+		{
+			name:  "HS256WrongSecret",
+			opts:  both,
+			token: signHS256(t, validClaims("u1"), []byte("guessed")),
+		},
+		{
+			// the public key is no secret, an HMAC made with it must not pass for an RS256 signature
+			name:  "AlgorithmConfusion",
+			opts:  rsaOnly,
+			token: signHS256(t, validClaims("u1"), publicPEM),
+		},
+		{
+			name:  "AlgorithmNone",
+			opts:  both,
+			token: signNone(t, validClaims("u1")),
+		},
+		{
+			name:  "Expired",
+			opts:  both,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), []byte(testSecret)),
+		},
+		{
+			name:  "ExpiredWithinLeeway",
+			opts:  both,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-10 * time.Second).Unix() }), []byte(testSecret)),
+			want:  &domain.Principal{Subject: "u1", UserID: "u1", Role: domain.Investor},
+		},
+		{
+			name:  "WithoutExpiry",
+			opts:  both,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { delete(c, "exp") }), []byte(testSecret)),
+		},
+		{
+			name:  "NotYetValid",
+			opts:  both,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Minute).Unix() }), []byte(testSecret)),
+		},
+		{
+			name:  "WithoutSubject",
+			opts:  both,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { delete(c, "sub") }), []byte(testSecret)),
+		},
+		{
+			name: "IssuerAndAudience",
+			opts: checked,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) {
+				c["iss"], c["aud"] = "https://issuer.example", []string{"other", "portfolio"}
+			}), []byte(testSecret)),
+			want: &domain.Principal{Subject: "u1", UserID: "u1", Role: domain.Investor},
+		},
+		{
+			name: "WrongIssuer",
+			opts: checked,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) {
+				c["iss"], c["aud"] = "https://evil.example", "portfolio"
+			}), []byte(testSecret)),
+		},
+		{
+			name: "WrongAudience",
+			opts: checked,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) {
+				c["iss"], c["aud"] = "https://issuer.example", "billing"
+			}), []byte(testSecret)),
+		},
+		{
+			name:  "MissingAudience",
+			opts:  checked,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { c["iss"] = "https://issuer.example" }), []byte(testSecret)),
+		},
+		{
+			name:  "AdvisorRole",
+			opts:  both,
+			token: signHS256(t, with(validClaims("a1"), func(c jwt.MapClaims) { c["role"] = "advisor" }), []byte(testSecret)),
+			want:  &domain.Principal{Subject: "a1", UserID: "a1", Role: domain.Advisor},
+		},
+		{
+			name:  "AdminRole",
+			opts:  both,
+			token: signHS256(t, with(validClaims("ops"), func(c jwt.MapClaims) { c["role"] = "admin" }), []byte(testSecret)),
+			want:  &domain.Principal{Subject: "ops", UserID: "ops", Role: domain.Admin},
+		},
+		{
+			// admin subjects are admins whatever their token says
+			name:  "AdminSubject",
+			opts:  both,
+			token: signHS256(t, with(validClaims("root"), func(c jwt.MapClaims) { c["role"] = "investor" }), []byte(testSecret)),
+			want:  &domain.Principal{Subject: "root", UserID: "root", Role: domain.Admin},
+		},
+		{
+			// the service role belongs to API keys, a token can't claim it
+			name:  "ServiceRole",
+			opts:  both,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { c["role"] = "service" }), []byte(testSecret)),
+		},
+		{
+			name:  "UnknownRole",
+			opts:  both,
+			token: signHS256(t, with(validClaims("u1"), func(c jwt.MapClaims) { c["role"] = "superuser" }), []byte(testSecret)),
+		},
+		{
+			name:  "Garbage",
+			opts:  both,
+			token: "not.a.token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, err := NewJWTAuthenticator(tt.opts)
+			if err != nil {
+				t.Fatalf("NewJWTAuthenticator: %v", err)
+			}
+			principal, err := authenticator.Authenticate(context.Background(), tt.token)
+			if tt.want == nil {
+				var unauthErr *domain.UnauthorizedError
+				if !errors.As(err, &unauthErr) {
+					t.Fatalf("got %+v, %v, want an UnauthorizedError", principal, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if principal.Subject != tt.want.Subject || principal.UserID != tt.want.UserID || principal.Role != tt.want.Role {
+				t.Errorf("got %+v, want %+v", principal, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewJWTAuthenticatorNeedsAKey(t *testing.T) {
+	if _, err := NewJWTAuthenticator(JWTOptions{}); err == nil {
+		t.Error("authenticator without a secret or JWKS file was created")
+	}
+	if _, err := NewJWTAuthenticator(JWTOptions{JWKSFile: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("authenticator with a missing JWKS file was created")
+	}
+}
+
+func signNone(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("signing: %v", err)
+	}
+	return token
+}
+
+func mustMarshalPublicKey(t *testing.T, key *rsa.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("encoding public key: %v", err)
+	}
+	return der
+}
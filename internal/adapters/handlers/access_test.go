@@ -0,0 +1,193 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/handlers"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+// Callers of the access tests by token
+var testPrincipals = map[string]*domain.Principal{
+	"admin":    {Subject: "root", UserID: "root", Role: domain.Admin},
+	"advisor":  {Subject: "a1", UserID: "a1", Role: domain.Advisor},
+	"unlinked": {Subject: "a2", UserID: "a2", Role: domain.Advisor},
+	"owner":    {Subject: "u1", UserID: "u1", Role: domain.Investor},
+	"other":    {Subject: "u2", UserID: "u2", Role: domain.Investor},
+	"readKey":  {Subject: "key:1", Role: domain.Service, AllUsers: true, ReadOnly: true},
+}
+
+// Price provider without a quote for anything
+type noQuotes struct{}
+
+func (noQuotes) LatestQuote(ctx context.Context, key domain.QuoteKey) (*domain.Quote, error) {
+	return nil, domain.ErrNoQuote
+}
+
+func (noQuotes) LatestQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error) {
+	return map[domain.QuoteKey]*domain.Quote{}, nil
+}
+
+// Handler backed by the services on repos, holdings are left unpriced
+func newHandler(repos *repositories.Repositories, accessService ports.AccessService) *handlers.APIHandler {
+	return handlers.NewAPIHandler(
+		services.NewTradeService(repos.Trades, repos.Portfolios),
+		services.NewPortfolioService(repos.Portfolios, noQuotes{}, time.Minute),
+		services.NewIdempotencyService(repos.Idempotency, time.Hour),
+		services.NewAPIKeyService(repos.APIKeys),
+		accessService,
+		services.NewAccountService(repos.Accounts),
+	)
+}
+
+// Server guarding the routes like cmd/main.go, backed by memory repositories holding a live and a
+// removed trade of u1, whose advisor is a1
+func newAccessServer(t *testing.T) (e *echo.Echo, live, removed int64) {
+	t.Helper()
+	ctx := context.Background()
+	repos := repositories.NewMemoryRepository()
+	accessService := services.NewAccessService(repos.Advisors)
+	if _, err := accessService.LinkClient(ctx, "a1", "u1"); err != nil {
+		t.Fatalf("LinkClient: %v", err)
+	}
+	var ids []int64
+	for i := 0; i < 2; i++ {
+		trade := &domain.Trade{
+			UserID:    "u1",
+			Ticker:    "TCS",
+			Type:      domain.Buy,
+			Quantity:  decimal.NewFromInt(10),
+			Price:     decimal.NewFromInt(100),
+			Currency:  domain.DefaultCurrency,
+			Timestamp: time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC),
+		}
+		if err := repos.Trades.AddTrade(ctx, trade); err != nil {
+			t.Fatalf("AddTrade: %v", err)
+		}
+		ids = append(ids, trade.Id)
+	}
+	if err := repos.Trades.RemoveTrade(ctx, ids[1]); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+
+	h := newHandler(repos, accessService)
+	principals := authenticatorFunc(func(ctx context.Context, token string) (*domain.Principal, error) {
+		if principal, ok := testPrincipals[token]; ok {
+			return principal, nil
+		}
+		return nil, &domain.UnauthorizedError{Message: "Invalid token"}
+	})
+
+	e = echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(handlers.Authenticate(principals, principals, nil))
+	// the guards are under test, the handlers behind them only answer
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	user, trade, anyTrade := handlers.PathUser("userId"), h.TradeOwner("id"), h.AnyTradeOwner("id")
+	e.PUT("/trades/:id", ok, h.Require(domain.WriteTrades, trade))
+	e.DELETE("/trades/:id", ok, h.Require(domain.WriteTrades, trade))
+	e.POST("/trades/:id/restore", ok, h.Require(domain.WriteTrades, anyTrade))
+	e.GET("/trades/:id/history", ok, h.Require(domain.ReadTrades, anyTrade))
+	e.GET("/trades/:userId", ok, h.Require(domain.ReadTrades, user))
+	e.GET("/portfolio/:userId", ok, h.Require(domain.ReadPortfolio, user))
+	e.PUT("/users/:userId/cost-basis", ok, h.Require(domain.WriteSettings, user))
+	e.POST("/users/:userId/accounts", ok, h.Require(domain.ManageAccounts, user))
+	e.POST("/admin/rebuild", ok, h.Require(domain.RebuildLedger, nil))
+	return e, ids[0], ids[1]
+}
+
+func TestRequire(t *testing.T) {
+	e, live, removed := newAccessServer(t)
+	liveTrade, removedTrade := "/trades/"+strconv.FormatInt(live, 10), "/trades/"+strconv.FormatInt(removed, 10)
+
+	type route struct{ method, path string }
+	var (
+		readTrades    = route{http.MethodGet, "/trades/u1"}
+		readPortfolio = route{http.MethodGet, "/portfolio/u1"}
+		setCostBasis  = route{http.MethodPut, "/users/u1/cost-basis"}
+		addAccount    = route{http.MethodPost, "/users/u1/accounts"}
+		updateTrade   = route{http.MethodPut, liveTrade}
+		removeTrade   = route{http.MethodDelete, liveTrade}
+		history       = route{http.MethodGet, removedTrade + "/history"}
+		restore       = route{http.MethodPost, removedTrade + "/restore"}
+		rebuild       = route{http.MethodPost, "/admin/rebuild"}
+		ownRoutes     = []route{readTrades, readPortfolio, setCostBasis, addAccount, updateTrade, removeTrade, history, restore}
+	)
+
+	tests := []struct {
+		name  string
+		token string
+		// routes on u1 and its trades
+		routes []route
+		status int
+		// status of the same reads on u3, a user nobody advises, 0 to skip them
+		ofOthers int
+	}{
+		{"Admin", "admin", append(ownRoutes, rebuild), http.StatusNoContent, http.StatusNoContent},
+		{"Owner", "owner", ownRoutes, http.StatusNoContent, http.StatusForbidden},
+		{"LinkedAdvisor", "advisor", ownRoutes, http.StatusNoContent, http.StatusForbidden},
+		{"UnlinkedAdvisor", "unlinked", ownRoutes, http.StatusForbidden, http.StatusForbidden},
+		{"OtherInvestor", "other", ownRoutes, http.StatusForbidden, http.StatusForbidden},
+		{"NonAdminRebuild", "owner", []route{rebuild}, http.StatusForbidden, 0},
+		{"ReadOnlyKeyReads", "readKey", []route{readTrades, readPortfolio, history}, http.StatusNoContent, http.StatusNoContent},
+		{"ReadOnlyKeyWrites", "readKey", []route{setCostBasis, addAccount, updateTrade, removeTrade, restore, rebuild}, http.StatusForbidden, 0},
+		{"Anonymous", "", ownRoutes, http.StatusUnauthorized, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range tt.routes {
+				if rec := send(e, r.method, r.path, tt.token, "10.0.0.1"); rec.Code != tt.status {
+					t.Errorf("%s %s: status %d, want %d: %s", r.method, r.path, rec.Code, tt.status, rec.Body)
+				}
+			}
+			for _, path := range []string{"/trades/u3", "/portfolio/u3"} {
+				if tt.ofOthers == 0 {
+					continue
+				}
+				if rec := send(e, http.MethodGet, path, tt.token, "10.0.0.1"); rec.Code != tt.ofOthers {
+					t.Errorf("GET %s: status %d, want %d", path, rec.Code, tt.ofOthers)
+				}
+			}
+		})
+	}
+}
+
+func TestTradeOwnerNotFound(t *testing.T) {
+	e, _, removed := newAccessServer(t)
+	removedTrade := "/trades/" + strconv.FormatInt(removed, 10)
+
+	tests := []struct {
+		name         string
+		method, path string
+		status       int
+	}{
+		// a removed trade is gone for edits, whoever asks, until it is restored
+		{"UpdateRemoved", http.MethodPut, removedTrade, http.StatusNotFound},
+		{"RemoveRemoved", http.MethodDelete, removedTrade, http.StatusNotFound},
+		{"UpdateMissing", http.MethodPut, "/trades/999", http.StatusNotFound},
+		{"RemoveMissing", http.MethodDelete, "/trades/999", http.StatusNotFound},
+		{"RestoreMissing", http.MethodPost, "/trades/999/restore", http.StatusNotFound},
+		{"HistoryOfMissing", http.MethodGet, "/trades/999/history", http.StatusNotFound},
+		{"InvalidID", http.MethodPut, "/trades/abc", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, token := range []string{"owner", "other", "admin"} {
+				rec := send(e, tt.method, tt.path, token, "10.0.0.1")
+				if rec.Code != tt.status {
+					t.Errorf("%s: status %d, want %d: %s", token, rec.Code, tt.status, rec.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+// CreateAccount opens a demat or broker account for a user
+// @Summary Create an account
+// @Description Opens an account trades can be recorded in, the first account of a user becomes its default.
+// @Description Setting default moves the default of the user to the new account.
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Param userId path string true "User ID"
+// @Param account body domain.Account true "Name, broker and default flag of the account"
+// @Success 201 {object} domain.Account
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /users/{userId}/accounts [post]
+func (h *APIHandler) CreateAccount(c echo.Context) error {
+	account := new(domain.Account)
+	if err := c.Bind(account); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	account.UserID = c.Param("userId")
+
+	if err := h.accountService.CreateAccount(c.Request().Context(), account); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, account)
+}
+
+// FetchAccounts lists the accounts of a user
+// @Summary Fetch user accounts
+// @Description Lists the accounts of a user, oldest first
+// @Tags accounts
+// @Produce json
+// @Param userId path string true "User ID"
+// @Success 200 {array} domain.Account
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /users/{userId}/accounts [get]
+func (h *APIHandler) FetchAccounts(c echo.Context) error {
+	accounts, err := h.accountService.FetchAccounts(c.Request().Context(), c.Param("userId"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// FetchAccount fetches an account
+// @Summary Fetch an account
+// @Tags accounts
+// @Produce json
+// @Param accountId path int true "Account ID"
+// @Success 200 {object} domain.Account
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /accounts/{accountId} [get]
+func (h *APIHandler) FetchAccount(c echo.Context) error {
+	id, err := accountID(c)
+	if err != nil {
+		return err
+	}
+
+	account, err := h.accountService.FetchAccount(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+// UpdateAccount renames an account or makes it the default
+// @Summary Update an account
+// @Description Changes the name and broker given in the body, default true makes it the account trades without an accountId go to.
+// @Description An account stops being the default only when another one is made the default.
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Param accountId path int true "Account ID"
+// @Param account body domain.Account true "Fields to change"
+// @Success 200 {object} domain.Account
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /accounts/{accountId} [put]
+func (h *APIHandler) UpdateAccount(c echo.Context) error {
+	id, err := accountID(c)
+	if err != nil {
+		return err
+	}
+	update := new(domain.Account)
+	if err := c.Bind(update); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	account, err := h.accountService.UpdateAccount(c.Request().Context(), id, update)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
+
+// RemoveAccount deletes an account
+// @Summary Remove an account
+// @Description Deletes an account without trades, removed trades included. The oldest remaining account takes over as default.
+// @Tags accounts
+// @Produce json
+// @Param accountId path int true "Account ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem "The account still has trades"
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /accounts/{accountId} [delete]
+func (h *APIHandler) RemoveAccount(c echo.Context) error {
+	id, err := accountID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.accountService.RemoveAccount(c.Request().Context(), id); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// FetchAccountPortfolio fetches the portfolio of one account
+// @Summary Fetch account portfolio
+// @Description Fetches the holdings of a single account with the latest known prices
+// @Tags portfolio
+// @Produce json
+// @Param accountId path int true "Account ID"
+// @Success 200 {array} domain.Portfolio
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /accounts/{accountId}/portfolio [get]
+func (h *APIHandler) FetchAccountPortfolio(c echo.Context) error {
+	id, err := accountID(c)
+	if err != nil {
+		return err
+	}
+
+	portfolio, err := h.portfolioService.FetchAccountPortfolio(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, portfolio)
+}
+
+func accountID(c echo.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("accountId"), 10, 64)
+	if err != nil {
+		return 0, domain.NewValidationError("accountId", "Invalid account ID")
+	}
+	return id, nil
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FetchClients lists the clients of an advisor
+// @Summary Fetch advisor clients
+// @Description Lists the clients whose trades and portfolios an advisor may read and change
+// @Tags admin
+// @Produce json
+// @Param advisorId path string true "Advisor user ID"
+// @Success 200 {array} domain.AdvisorClient
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Router /admin/advisors/{advisorId}/clients [get]
+func (h *APIHandler) FetchClients(c echo.Context) error {
+	clients, err := h.accessService.FetchClients(c.Request().Context(), c.Param("advisorId"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, clients)
+}
+
+// LinkClient links a client to an advisor
+// @Summary Link a client to an advisor
+// @Description Lets the advisor read and change the trades and portfolio of the client, linking them again changes nothing
+// @Tags admin
+// @Produce json
+// @Param advisorId path string true "Advisor user ID"
+// @Param clientId path string true "Client user ID"
+// @Success 200 {object} domain.AdvisorClient
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Router /admin/advisors/{advisorId}/clients/{clientId} [put]
+func (h *APIHandler) LinkClient(c echo.Context) error {
+	link, err := h.accessService.LinkClient(c.Request().Context(), c.Param("advisorId"), c.Param("clientId"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, link)
+}
+
+// UnlinkClient removes a client from an advisor
+// @Summary Unlink a client from an advisor
+// @Description Takes away the advisor's access to the client
+// @Tags admin
+// @Produce json
+// @Param advisorId path string true "Advisor user ID"
+// @Param clientId path string true "Client user ID"
+// @Success 204 "No Content"
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Router /admin/advisors/{advisorId}/clients/{clientId} [delete]
+func (h *APIHandler) UnlinkClient(c echo.Context) error {
+	if err := h.accessService.UnlinkClient(c.Request().Context(), c.Param("advisorId"), c.Param("clientId")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -12,12 +16,23 @@ import (
 )
 
 type APIHandler struct {
-	tradeService     ports.TradeService
-	portfolioService ports.PortfolioService
+	tradeService       ports.TradeService
+	portfolioService   ports.PortfolioService
+	idempotencyService ports.IdempotencyService
+	apiKeyService      ports.APIKeyService
+	accessService      ports.AccessService
+	accountService     ports.AccountService
 }
 
-func NewAPIHandler(tradeService ports.TradeService, portfolioService ports.PortfolioService) *APIHandler {
-	return &APIHandler{tradeService: tradeService, portfolioService: portfolioService}
+func NewAPIHandler(tradeService ports.TradeService, portfolioService ports.PortfolioService, idempotencyService ports.IdempotencyService, apiKeyService ports.APIKeyService, accessService ports.AccessService, accountService ports.AccountService) *APIHandler {
+	return &APIHandler{
+		tradeService:       tradeService,
+		portfolioService:   portfolioService,
+		idempotencyService: idempotencyService,
+		apiKeyService:      apiKeyService,
+		accessService:      accessService,
+		accountService:     accountService,
+	}
 }
 
 // Root handler
@@ -36,125 +51,299 @@ func (h *APIHandler) Root(c echo.Context) error {
 
 // AddTrade adds a new trade
 // @Summary Add a new trade
-// @Description Adds a new trade to the system
+// @Description Adds a new trade to the system. The timestamp is optional and defaults to now,
+// @Description past timestamps insert the trade at that point in the history, future ones are rejected.
+// @Description userId defaults to the token subject and accountId to the default account of the user, opened on the first trade.
+// @Description An accountId that is unknown or belongs to another user is a 400. A 400 lists every invalid field at once. Retries sent with the same Idempotency-Key and body
+// @Description get the first response back (marked by an Idempotent-Replayed header) instead of adding the trade again.
 // @Tags trades
 // @Accept json
 // @Produce json
 // @Param trade body domain.Trade true "Trade object"
+// @Param X-Actor header string false "Who makes the change when authentication is disabled, otherwise the token subject is recorded"
+// @Param X-Change-Reason header string false "Why the change is made, recorded in the trade history"
+// @Param Idempotency-Key header string false "Unique key of this trade, retries reuse it"
 // @Success 201 {object} domain.Trade
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 409 {object} Problem "Also sent when the Idempotency-Key was used with another body or is still in progress"
+// @Failure 422 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /trades [post]
 func (h *APIHandler) AddTrade(c echo.Context) error {
+	// kept to tell a retry of this request from a different one reusing its Idempotency-Key
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
 	trade := new(domain.Trade)
 	if err := c.Bind(trade); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
-	}
-	trade.Timestamp = time.Now()
-	// Basic Validation
-	if trade.Quantity <= 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Quantity must be positive"})
-	}
-	if trade.Price <= 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Price must be positive"})
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
 	}
-	if trade.Type != domain.Buy && trade.Type != domain.Sell {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid trade type"})
+	trade.UserID = requestedUser(c, trade.UserID)
+	if err := h.authorize(c, domain.WriteTrades, trade.UserID); err != nil {
+		return err
 	}
 
-	if err := h.tradeService.AddTrade(trade); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-
-	return c.JSON(http.StatusCreated, trade)
+	return h.idempotent(c, trade.UserID, body, func() (int, any, error) {
+		if err := h.tradeService.AddTrade(changeContext(c), trade); err != nil {
+			return 0, nil, err
+		}
+		return http.StatusCreated, trade, nil
+	})
 }
 
 // UpdateTrade updates an existing trade
 // @Summary Update a trade
-// @Description Updates an existing trade in the system
+// @Description Updates an existing trade in the system, fields left out of the body keep their value.
+// @Description Moving a trade to another userId without an accountId moves it to the default account of that user.
 // @Tags trades
 // @Accept json
 // @Produce json
 // @Param id path int true "Trade ID"
 // @Param trade body domain.Trade true "Updated Trade object"
-// @Success 200 {object} domain.Trade
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Param X-Actor header string false "Who makes the change when authentication is disabled, otherwise the token subject is recorded"
+// @Param X-Change-Reason header string false "Why the change is made, recorded in the trade history"
+// @Success 200 {object} domain.Trade "The trade as stored after the update"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem "Also sent for removed trades, restore them first"
+// @Failure 409 {object} Problem "The currency clashes with the position's"
+// @Failure 422 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /trades/{id} [put]
 func (h *APIHandler) UpdateTrade(c echo.Context) error {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid trade ID"})
+		return domain.NewValidationError("id", "Invalid trade ID")
 	}
 
 	trade := new(domain.Trade)
 	if err := c.Bind(trade); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	// moving the trade to another user needs access to that user as well
+	if userID := strings.TrimSpace(trade.UserID); userID != "" {
+		if err := h.authorize(c, domain.WriteTrades, userID); err != nil {
+			return err
+		}
 	}
 
-	if err := h.tradeService.UpdateTrade(id, trade); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update trade"})
+	updated, err := h.tradeService.UpdateTrade(changeContext(c), id, trade)
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, trade)
+	return c.JSON(http.StatusOK, updated)
 }
 
 // RemoveTrade deletes an existing trade
 // @Summary Remove a trade
-// @Description Removes an existing trade from the system
+// @Description Removes an existing trade from the system, it stays in the trade history and can be restored
 // @Tags trades
 // @Produce json
 // @Param id path int true "Trade ID"
+// @Param X-Actor header string false "Who makes the change when authentication is disabled, otherwise the token subject is recorded"
+// @Param X-Change-Reason header string false "Why the change is made, recorded in the trade history"
 // @Success 204 "No Content"
-// @Failure 400 {object} map[string]string
-// @Failure 500 {object} map[string]string
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem "Also sent for removed trades"
+// @Failure 422 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /trades/{id} [delete]
 func (h *APIHandler) RemoveTrade(c echo.Context) error {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid trade ID"})
+		return domain.NewValidationError("id", "Invalid trade ID")
 	}
 
-	if err := h.tradeService.RemoveTrade(id); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to remove trade"})
+	if err := h.tradeService.RemoveTrade(changeContext(c), id); err != nil {
+		return err
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
 
-// FetchTrades fetches all trades for a user
+// RestoreTrade brings back a removed trade
+// @Summary Restore a trade
+// @Description Restores a removed trade, rejected when the position can no longer take it at its point in history
+// @Tags trades
+// @Produce json
+// @Param id path int true "Trade ID"
+// @Param X-Actor header string false "Who makes the change when authentication is disabled, otherwise the token subject is recorded"
+// @Param X-Change-Reason header string false "Why the change is made, recorded in the trade history"
+// @Success 200 {object} domain.Trade
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /trades/{id}/restore [post]
+func (h *APIHandler) RestoreTrade(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return domain.NewValidationError("id", "Invalid trade ID")
+	}
+
+	trade, err := h.tradeService.RestoreTrade(changeContext(c), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, trade)
+}
+
+// FetchTradeHistory fetches the audit log of a trade
+// @Summary Fetch trade history
+// @Description Every create, update, delete and restore of a trade, oldest first, with the trade before and after each change
+// @Tags trades
+// @Produce json
+// @Param id path int true "Trade ID"
+// @Success 200 {array} domain.TradeAudit
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /trades/{id}/history [get]
+func (h *APIHandler) FetchTradeHistory(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return domain.NewValidationError("id", "Invalid trade ID")
+	}
+
+	history, err := h.tradeService.FetchTradeHistory(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+// FetchTrades fetches trades for a user a page at a time
 // @Summary Fetch user trades
-// @Description Fetches all trades for a specific user
+// @Description Fetches trades for a specific user ordered by timestamp, pass nextCursor back as cursor for the following page
 // @Tags trades
 // @Produce json
 // @Param userId path string true "User ID"
-// @Success 200 {array} domain.Trade
-// @Failure 500 {object} map[string]string
+// @Param ticker query string false "Ticker"
+// @Param type query string false "BUY or SELL"
+// @Param accountId query int false "Account ID"
+// @Param from query string false "Start date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Param sort query string false "asc or desc (default)"
+// @Param limit query int false "Page size, default 50, max 500"
+// @Param cursor query string false "Cursor from a previous page"
+// @Success 200 {object} domain.TradePage
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /trades/{userId} [get]
 func (h *APIHandler) FetchTrades(c echo.Context) error {
 	userID := c.Param("userId")
-	trades, err := h.tradeService.FetchTrades(userID)
+	filter, err := parseTradeFilter(c, domain.DefaultSort)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch trades"})
+		return err
+	}
+
+	page, err := h.tradeService.FetchTrades(c.Request().Context(), userID, filter)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, page)
+}
+
+// Ticker query parameter normalized like the tickers of stored trades, so "tcs " finds TCS
+func tickerParam(c echo.Context) string {
+	return strings.ToUpper(strings.TrimSpace(c.QueryParam("ticker")))
+}
+
+// Reads trade history filters and paging from the query string, sorting in defaultSort unless asked otherwise
+func parseTradeFilter(c echo.Context, defaultSort domain.SortDirection) (domain.TradeFilter, error) {
+	filter := domain.TradeFilter{
+		Ticker: tickerParam(c),
+		Type:   domain.TradeType(strings.ToUpper(c.QueryParam("type"))),
+		Cursor: c.QueryParam("cursor"),
+		Sort:   domain.SortDirection(strings.ToLower(c.QueryParam("sort"))),
+	}
+	if filter.Type != "" && filter.Type != domain.Buy && filter.Type != domain.Sell {
+		return filter, domain.NewValidationError("type", "Invalid trade type")
+	}
+	switch filter.Sort {
+	case "":
+		filter.Sort = defaultSort
+	case domain.Asc, domain.Desc:
+	default:
+		return filter, domain.NewValidationError("sort", "Sort must be asc or desc")
+	}
+	if value := c.QueryParam("accountId"); value != "" {
+		accountID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || accountID <= 0 {
+			return filter, domain.NewValidationError("accountId", "Invalid account ID")
+		}
+		filter.AccountID = accountID
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, domain.NewValidationError("limit", "Limit must be a positive number")
+		}
+		filter.Limit = limit
 	}
 
-	return c.JSON(http.StatusOK, trades)
+	var err error
+	filter.From, filter.To, err = parseDateRange(c)
+	return filter, err
 }
 
 // FetchPortfolio fetches portfolio of user
 // @Summary Fetch user portfolio
-// @Description Fetches the portfolio for a specific user
+// @Description Fetches the portfolio for a specific user, holdings of the same ticker in several accounts are added up
+// @Description with the average buy price weighted by quantity. GET /accounts/{accountId}/portfolio has the holdings of one account.
 // @Tags portfolio
 // @Produce json
 // @Param userId path string true "User ID"
 // @Success 200 {array} domain.Portfolio
-// @Failure 500 {object} map[string]string
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /portfolio/{userId} [get]
 func (h *APIHandler) FetchPortfolio(c echo.Context) error {
 	userID := c.Param("userId")
-	portfolio, err := h.portfolioService.FetchPortfolio(userID)
+	portfolio, err := h.portfolioService.FetchPortfolio(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch portfolio"})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, portfolio)
@@ -162,19 +351,138 @@ func (h *APIHandler) FetchPortfolio(c echo.Context) error {
 
 // FetchReturns fetches user returns
 // @Summary Fetch user returns
-// @Description Fetches the returns for a specific user
+// @Description Fetches the returns for a specific user across all of its accounts, totalled per currency. The top level totals are left out when positions are in more than one currency.
 // @Tags returns
 // @Produce json
-// @Param userId query string true "User ID"
+// @Param userId query string false "User ID, defaults to the token subject"
 // @Success 200 {object} domain.Returns
-// @Failure 500 {object} map[string]string
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /returns [get]
 func (h *APIHandler) FetchReturns(c echo.Context) error {
-	userID := c.QueryParam("userId")
-	returns, err := h.portfolioService.FetchReturns(userID)
+	userID := requestedUser(c, c.QueryParam("userId"))
+	returns, err := h.portfolioService.FetchReturns(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch returns"})
+		return err
 	}
 
 	return c.JSON(http.StatusOK, returns)
 }
+
+// FetchLots fetches tax lots of user
+// @Summary Fetch user lots
+// @Description Fetches every lot opened by a BUY for a specific user, along with the quantity still held and the account it is in
+// @Tags portfolio
+// @Produce json
+// @Param userId path string true "User ID"
+// @Param ticker query string false "Ticker"
+// @Success 200 {array} domain.Lot
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /portfolio/{userId}/lots [get]
+func (h *APIHandler) FetchLots(c echo.Context) error {
+	userID := c.Param("userId")
+	lots, err := h.portfolioService.FetchLots(c.Request().Context(), userID, tickerParam(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, lots)
+}
+
+// FetchCostBasis fetches cost basis method of user
+// @Summary Fetch cost basis method
+// @Description Fetches the method used to pick lots when a user sells
+// @Tags portfolio
+// @Produce json
+// @Param userId path string true "User ID"
+// @Success 200 {object} domain.UserSettings
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /users/{userId}/cost-basis [get]
+func (h *APIHandler) FetchCostBasis(c echo.Context) error {
+	userID := c.Param("userId")
+	method, err := h.portfolioService.FetchCostBasisMethod(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &domain.UserSettings{UserID: userID, CostBasisMethod: method})
+}
+
+// SetCostBasis sets cost basis method of user
+// @Summary Set cost basis method
+// @Description Sets the method (FIFO, LIFO or HIFO) used to pick lots for future sells
+// @Tags portfolio
+// @Accept json
+// @Produce json
+// @Param userId path string true "User ID"
+// @Param settings body domain.UserSettings true "Cost basis method"
+// @Success 200 {object} domain.UserSettings
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /users/{userId}/cost-basis [put]
+func (h *APIHandler) SetCostBasis(c echo.Context) error {
+	settings := new(domain.UserSettings)
+	if err := c.Bind(settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	settings.UserID = c.Param("userId")
+
+	if err := h.portfolioService.SetCostBasisMethod(c.Request().Context(), settings.UserID, settings.CostBasisMethod); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+// RebuildPortfolios rebuilds all portfolios
+// @Summary Rebuild portfolios
+// @Description Recomputes every portfolio and its lots by replaying all trades in timestamp order, admins only
+// @Tags admin
+// @Produce json
+// @Success 200 {object} domain.RebuildReport
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Router /admin/rebuild [post]
+func (h *APIHandler) RebuildPortfolios(c echo.Context) error {
+	report, err := h.portfolioService.RebuildPortfolios(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// Request context carrying the actor and reason, written to the audit log of the trades it changes.
+// The actor is the authenticated caller, the X-Actor header only counts when authentication is disabled.
+func changeContext(c echo.Context) context.Context {
+	actor := c.Request().Header.Get("X-Actor")
+	if principal, ok := domain.PrincipalFrom(c.Request().Context()); ok && principal.Subject != "" {
+		actor = principal.Subject
+	}
+	return domain.WithChange(c.Request().Context(), domain.Change{
+		Actor:  actor,
+		Reason: c.Request().Header.Get("X-Change-Reason"),
+	})
+}
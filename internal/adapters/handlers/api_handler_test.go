@@ -0,0 +1,189 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/handlers"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+// Server without authentication over memory repositories holding buys of u1: TCS on the 1st to 3rd
+// of January 2024 and INFY on the 4th, trade ids 1 to 4 in that order
+func newTradeServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	ctx := context.Background()
+	repos := repositories.NewMemoryRepository()
+	for day := 1; day <= 4; day++ {
+		ticker := "TCS"
+		if day == 4 {
+			ticker = "INFY"
+		}
+		trade := &domain.Trade{
+			UserID:    "u1",
+			Ticker:    ticker,
+			Type:      domain.Buy,
+			Quantity:  decimal.NewFromInt(1),
+			Price:     decimal.NewFromInt(100),
+			Currency:  domain.DefaultCurrency,
+			Timestamp: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC),
+		}
+		if err := repos.Trades.AddTrade(ctx, trade); err != nil {
+			t.Fatalf("AddTrade: %v", err)
+		}
+	}
+	h := newHandler(repos, services.NewAccessService(repos.Advisors))
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(handlers.AllowAnonymous())
+	e.GET("/trades/:userId", h.FetchTrades)
+	e.GET("/trades/:userId/export", h.ExportTrades)
+	e.GET("/portfolio/:userId/lots", h.FetchLots)
+	e.PUT("/trades/:id", h.UpdateTrade)
+	e.DELETE("/trades/:id", h.RemoveTrade)
+	e.POST("/trades/:id/restore", h.RestoreTrade)
+	return e
+}
+
+// Days of the trades or lots a request returns, in order
+func tradeDays(t *testing.T, e *echo.Echo, path string) []int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var times []time.Time
+	switch {
+	case strings.Contains(path, "/export"):
+		// JSON Lines, a trade per line, with the columns of the other formats
+		decoder := json.NewDecoder(rec.Body)
+		for decoder.More() {
+			var row struct {
+				Timestamp time.Time `json:"timestamp"`
+			}
+			if err := decoder.Decode(&row); err != nil {
+				t.Fatalf("decoding export: %v", err)
+			}
+			times = append(times, row.Timestamp)
+		}
+	case strings.Contains(path, "/lots"):
+		var lots []*domain.Lot
+		if err := json.Unmarshal(rec.Body.Bytes(), &lots); err != nil {
+			t.Fatalf("decoding body %q: %v", rec.Body, err)
+		}
+		for _, lot := range lots {
+			times = append(times, lot.AcquiredAt)
+		}
+	default:
+		var page domain.TradePage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decoding body %q: %v", rec.Body, err)
+		}
+		for _, trade := range page.Trades {
+			times = append(times, trade.Timestamp)
+		}
+	}
+
+	var days []int
+	for _, timestamp := range times {
+		days = append(days, timestamp.Day())
+	}
+	return days
+}
+
+func TestTradeSort(t *testing.T) {
+	e := newTradeServer(t)
+	tests := []struct {
+		name string
+		path string
+		want []int
+	}{
+		// listings are newest first by default, exports oldest first
+		{"ListDefault", "/trades/u1", []int{4, 3, 2, 1}},
+		{"ListAsc", "/trades/u1?sort=asc", []int{1, 2, 3, 4}},
+		{"ListDesc", "/trades/u1?sort=DESC", []int{4, 3, 2, 1}},
+		{"ExportDefault", "/trades/u1/export?format=jsonl", []int{1, 2, 3, 4}},
+		{"ExportDesc", "/trades/u1/export?sort=desc&format=jsonl", []int{4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tradeDays(t, e, tt.path); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("days %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trades/u1?sort=sideways", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort: status %d, want 400", rec.Code)
+	}
+}
+
+func TestTickerFilter(t *testing.T) {
+	e := newTradeServer(t)
+	tests := []struct {
+		name string
+		path string
+		want []int
+	}{
+		// tickers are stored upper case, filters match them however they are typed
+		{"List", "/trades/u1?ticker=TCS&sort=asc", []int{1, 2, 3}},
+		{"ListLowerCase", "/trades/u1?ticker=tcs&sort=asc", []int{1, 2, 3}},
+		{"ListPadded", "/trades/u1?ticker=%20Infy%20", []int{4}},
+		{"ExportLowerCase", "/trades/u1/export?ticker=tcs&sort=asc&format=jsonl", []int{1, 2, 3}},
+		{"LotsLowerCase", "/portfolio/u1/lots?ticker=infy", []int{4}},
+		{"LotsPadded", "/portfolio/u1/lots?ticker=%20tcs", []int{1, 2, 3}},
+		{"Unknown", "/trades/u1?ticker=wipro", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tradeDays(t, e, tt.path); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("days %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemovedTradeNotFound(t *testing.T) {
+	e := newTradeServer(t)
+	send := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	steps := []struct {
+		name         string
+		method, path string
+		status       int
+	}{
+		{"Remove", http.MethodDelete, "/trades/3", http.StatusNoContent},
+		// a removed trade answers like a missing one until it is restored
+		{"UpdateRemoved", http.MethodPut, "/trades/3", http.StatusNotFound},
+		{"RemoveRemoved", http.MethodDelete, "/trades/3", http.StatusNotFound},
+		{"UpdateMissing", http.MethodPut, "/trades/99", http.StatusNotFound},
+		{"Restore", http.MethodPost, "/trades/3/restore", http.StatusOK},
+		{"UpdateRestored", http.MethodPut, "/trades/3", http.StatusOK},
+	}
+	for _, step := range steps {
+		if got := send(step.method, step.path, `{"price":"101"}`); got != step.status {
+			t.Errorf("%s: %s %s status %d, want %d", step.name, step.method, step.path, got, step.status)
+		}
+	}
+}
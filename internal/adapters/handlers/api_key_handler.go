@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+// CreateAPIKey creates an API key for a service client
+// @Summary Create an API key
+// @Description Creates a long-lived key for service clients to send in the X-API-Key header. The key is only shown in this response,
+// @Description just its hash is stored. scope is read-only (default) or read-write, userIds limits the key to those users and is unlimited when empty.
+// @Description expiresAt is optional, a key without it is valid until revoked.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param key body domain.APIKey true "Name, scope, user ids and expiry of the key"
+// @Success 201 {object} domain.NewAPIKey
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Router /admin/api-keys [post]
+func (h *APIHandler) CreateAPIKey(c echo.Context) error {
+
+	key := new(domain.APIKey)
+	if err := c.Bind(key); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	created, err := h.apiKeyService.CreateAPIKey(changeContext(c), key)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, created)
+}
+
+// ListAPIKeys lists every API key
+// @Summary List API keys
+// @Description Lists every API key, revoked and expired ones included, with when it was last used. Keys are identified by their prefix.
+// @Tags admin
+// @Produce json
+// @Success 200 {array} domain.APIKey
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Router /admin/api-keys [get]
+func (h *APIHandler) ListAPIKeys(c echo.Context) error {
+
+	keys, err := h.apiKeyService.ListAPIKeys(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes an API key
+// @Summary Revoke an API key
+// @Description Revokes an API key, requests made with it are rejected from then on. The key stays listed.
+// @Tags admin
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Router /admin/api-keys/{id} [delete]
+func (h *APIHandler) RevokeAPIKey(c echo.Context) error {
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return domain.NewValidationError("id", "Invalid API key ID")
+	}
+
+	if _, err := h.apiKeyService.RevokeAPIKey(c.Request().Context(), id); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// Middleware requiring a bearer token or an API key on every request not skipped, the verified caller
+// is attached to the request context for Require and the handlers to authorize against
+func Authenticate(tokens, apiKeys ports.Authenticator, skipper middleware.Skipper) echo.MiddlewareFunc {
+	if skipper == nil {
+		skipper = middleware.DefaultSkipper
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+
+			ctx := c.Request().Context()
+			var principal *domain.Principal
+			var err error
+			if key := c.Request().Header.Get(apiKeyHeader); key != "" {
+				principal, err = apiKeys.Authenticate(ctx, key)
+			} else {
+				scheme, token, _ := strings.Cut(c.Request().Header.Get(echo.HeaderAuthorization), " ")
+				token = strings.TrimSpace(token)
+				if !strings.EqualFold(scheme, "Bearer") || token == "" {
+					return &domain.UnauthorizedError{Message: "Missing bearer token or API key"}
+				}
+				principal, err = tokens.Authenticate(ctx, token)
+			}
+			if err != nil {
+				return err
+			}
+			c.SetRequest(c.Request().WithContext(domain.WithPrincipal(ctx, principal)))
+			return next(c)
+		}
+	}
+}
+
+// Middleware for running with authentication disabled, every request acts as an anonymous admin
+func AllowAnonymous() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := domain.WithPrincipal(c.Request().Context(), &domain.Principal{Role: domain.Admin})
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// Finds the user a request acts on before its handler runs, "" when the route has none
+type UserTarget func(c echo.Context) (string, error)
+
+// Route middleware letting the request through only when its caller holds permission for the target user.
+// Users only known once the handler reads the body are checked by the handler with authorize.
+func (h *APIHandler) Require(permission domain.Permission, target UserTarget) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID := ""
+			if target != nil {
+				var err error
+				if userID, err = target(c); err != nil {
+					return err
+				}
+			}
+			if err := h.accessService.Authorize(c.Request().Context(), permission, userID); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// Target named by a path parameter
+func PathUser(param string) UserTarget {
+	return func(c echo.Context) (string, error) {
+		return c.Param(param), nil
+	}
+}
+
+// Target named by a query parameter, the caller's own user when it is left out
+func QueryUser(param string) UserTarget {
+	return func(c echo.Context) (string, error) {
+		userID := requestedUser(c, c.QueryParam(param))
+		if userID == "" {
+			return "", domain.NewValidationError(param, "User ID is required")
+		}
+		return userID, nil
+	}
+}
+
+// Target owning the trade whose id is the path parameter, a removed trade is not found
+func (h *APIHandler) TradeOwner(param string) UserTarget {
+	return h.tradeOwner(param, false)
+}
+
+// Target owning the trade whose id is the path parameter, removed trades included for routes restoring them
+// or reading their history
+func (h *APIHandler) AnyTradeOwner(param string) UserTarget {
+	return h.tradeOwner(param, true)
+}
+
+func (h *APIHandler) tradeOwner(param string, removed bool) UserTarget {
+	return func(c echo.Context) (string, error) {
+		id, err := strconv.ParseInt(c.Param(param), 10, 64)
+		if err != nil {
+			return "", domain.NewValidationError("id", "Invalid trade ID")
+		}
+		trade, err := h.tradeService.FetchTrade(c.Request().Context(), id)
+		if err != nil {
+			return "", err
+		}
+		if trade.DeletedAt != nil && !removed {
+			return "", domain.TradeNotFound(id)
+		}
+		return trade.UserID, nil
+	}
+}
+
+// Target owning the account whose id is the path parameter
+func (h *APIHandler) AccountOwner(param string) UserTarget {
+	return func(c echo.Context) (string, error) {
+		id, err := strconv.ParseInt(c.Param(param), 10, 64)
+		if err != nil {
+			return "", domain.NewValidationError("accountId", "Invalid account ID")
+		}
+		account, err := h.accountService.FetchAccount(c.Request().Context(), id)
+		if err != nil {
+			return "", err
+		}
+		return account.UserID, nil
+	}
+}
+
+// Rejects the request unless its caller holds permission for userID, for users named in the body
+func (h *APIHandler) authorize(c echo.Context, permission domain.Permission, userID string) error {
+	// service clients have no user of their own to fall back on
+	if userID == "" {
+		return domain.NewValidationError("userId", "User ID is required")
+	}
+	return h.accessService.Authorize(c.Request().Context(), permission, userID)
+}
+
+// User a request acts for, the given userId or else the caller's own user
+func requestedUser(c echo.Context, userID string) string {
+	if userID = strings.TrimSpace(userID); userID != "" {
+		return userID
+	}
+	if principal, ok := domain.PrincipalFrom(c.Request().Context()); ok {
+		return principal.UserID
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/handlers"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+// Accepts the API key "key1" of a read-only service client
+var testAPIKeys = authenticatorFunc(func(ctx context.Context, key string) (*domain.Principal, error) {
+	if key != "key1" {
+		return nil, &domain.UnauthorizedError{Message: "Invalid API key"}
+	}
+	return &domain.Principal{Subject: "key:1", Role: domain.Service, ReadOnly: true}, nil
+})
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		status  int
+		// subject of the caller the handler sees
+		subject string
+	}{
+		{"NoCredentials", "/trades", nil, http.StatusUnauthorized, ""},
+		{"EmptyBearer", "/trades", map[string]string{"Authorization": "Bearer "}, http.StatusUnauthorized, ""},
+		{"BasicScheme", "/trades", map[string]string{"Authorization": "Basic dTE6cHc="}, http.StatusUnauthorized, ""},
+		{"InvalidToken", "/trades", map[string]string{"Authorization": "Bearer bad"}, http.StatusUnauthorized, ""},
+		{"Token", "/trades", map[string]string{"Authorization": "Bearer good"}, http.StatusOK, "u1"},
+		{"SchemeIsCaseInsensitive", "/trades", map[string]string{"Authorization": "bearer good"}, http.StatusOK, "u1"},
+		{"APIKey", "/trades", map[string]string{"X-API-Key": "key1"}, http.StatusOK, "key:1"},
+		{"InvalidAPIKey", "/trades", map[string]string{"X-API-Key": "key2"}, http.StatusUnauthorized, ""},
+		// a key that is sent is checked, a valid token next to it doesn't stand in for it
+		{"InvalidAPIKeyWithToken", "/trades", map[string]string{"X-API-Key": "key2", "Authorization": "Bearer good"}, http.StatusUnauthorized, ""},
+		{"PublicRoute", "/status", nil, http.StatusOK, ""},
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(handlers.Authenticate(testTokens, testAPIKeys, func(c echo.Context) bool { return c.Path() == "/status" }))
+	whoami := func(c echo.Context) error {
+		principal, _ := domain.PrincipalFrom(c.Request().Context())
+		if principal == nil {
+			return c.String(http.StatusOK, "")
+		}
+		return c.String(http.StatusOK, principal.Subject)
+	}
+	e.GET("/trades", whoami)
+	e.GET("/status", whoami)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				if rec.Body.String() != tt.subject {
+					t.Errorf("handler saw caller %q, want %q", rec.Body, tt.subject)
+				}
+				return
+			}
+
+			if got := rec.Header().Get(echo.HeaderWWWAuthenticate); got != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", got)
+			}
+			if got := rec.Header().Get(echo.HeaderContentType); got != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", got)
+			}
+			var problem handlers.Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("decoding body %q: %v", rec.Body, err)
+			}
+			if problem.Status != http.StatusUnauthorized || problem.Title != "Unauthorized" || problem.Detail == "" || problem.Instance != tt.path {
+				t.Errorf("problem = %+v", problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+const problemContentType = "application/problem+json"
+
+// Error body following RFC 7807, Errors lists the offending fields or import rows
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Errors   any    `json:"errors,omitempty"`
+}
+
+// Echo error handler turning every error returned by a handler or middleware into a problem+json response
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	problem := problemFor(err)
+	problem.Instance = c.Request().URL.Path
+	if problem.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s failed: %v", c.Request().Method, c.Request().URL.Path, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, problemContentType)
+	if problem.Status == http.StatusUnauthorized {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(problem.Status)
+	} else {
+		err = c.JSON(problem.Status, problem)
+	}
+	if err != nil {
+		log.Printf("writing error response failed: %v", err)
+	}
+}
+
+// Maps domain errors to their status, anything unknown is a 500 that hides the cause from the client
+func problemFor(err error) *Problem {
+	var (
+		notFoundErr   *domain.NotFoundError
+		quantityErr   *domain.InsufficientQuantityError
+		validationErr *domain.ValidationError
+		conflictErr   *domain.ConflictError
+		importErr     *domain.ImportError
+		unauthErr     *domain.UnauthorizedError
+		forbiddenErr  *domain.ForbiddenError
+		rateLimitErr  *domain.RateLimitError
+		httpErr       *echo.HTTPError
+	)
+	switch {
+	case errors.As(err, &notFoundErr):
+		return newProblem(http.StatusNotFound, err.Error())
+	case errors.As(err, &quantityErr):
+		return newProblem(http.StatusUnprocessableEntity, err.Error())
+	case errors.As(err, &importErr):
+		problem := newProblem(http.StatusBadRequest, err.Error())
+		problem.Errors = importErr.Errors
+		return problem
+	case errors.As(err, &validationErr):
+		problem := newProblem(http.StatusBadRequest, err.Error())
+		problem.Errors = validationErr.Errors
+		return problem
+	case errors.As(err, &conflictErr):
+		return newProblem(http.StatusConflict, err.Error())
+	case errors.As(err, &unauthErr):
+		return newProblem(http.StatusUnauthorized, err.Error())
+	case errors.As(err, &forbiddenErr):
+		return newProblem(http.StatusForbidden, err.Error())
+	case errors.As(err, &rateLimitErr):
+		return newProblem(http.StatusTooManyRequests, err.Error())
+	case errors.As(err, &httpErr):
+		if httpErr.Internal != nil {
+			log.Printf("%v", httpErr.Internal)
+		}
+		detail, ok := httpErr.Message.(string)
+		if !ok {
+			detail = http.StatusText(httpErr.Code)
+		}
+		return newProblem(httpErr.Code, detail)
+	case errors.Is(err, context.DeadlineExceeded):
+		return newProblem(http.StatusGatewayTimeout, "Request timed out")
+	default:
+		return newProblem(http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+func newProblem(status int, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
@@ -0,0 +1,135 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/handlers"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+func TestHTTPErrorHandler(t *testing.T) {
+	validationErr := &domain.ValidationError{Errors: []*domain.FieldError{
+		{Field: "ticker", Message: "Ticker is required"},
+		{Field: "price", Message: "Price must be positive"},
+	}}
+	importErr := &domain.ImportError{Errors: []*domain.RowError{{Row: 2, Error: "Ticker is required"}}}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		detail string
+		// JSON of the errors member, "" when it must be left out
+		errors string
+	}{
+		{"NotFound", domain.TradeNotFound(7), http.StatusNotFound, "trade 7 not found", ""},
+		{"WrappedNotFound", fmt.Errorf("loading trade: %w", domain.TradeNotFound(7)), http.StatusNotFound, "loading trade: trade 7 not found", ""},
+		{
+			"InsufficientQuantity",
+			&domain.InsufficientQuantityError{TradeID: 3, Timestamp: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Held: decimal.NewFromInt(5), Requested: decimal.NewFromInt(10)},
+			http.StatusUnprocessableEntity,
+			"insufficient quantity for sell trade 3 on 2024-01-02T00:00:00Z: holding 5, selling 10",
+			"",
+		},
+		{
+			"Validation", validationErr, http.StatusBadRequest, "Ticker is required; Price must be positive",
+			`[{"field":"ticker","message":"Ticker is required"},{"field":"price","message":"Price must be positive"}]`,
+		},
+		{"Import", importErr, http.StatusBadRequest, "import rejected: 1 invalid rows", `[{"row":2,"error":"Ticker is required"}]`},
+		{"Conflict", &domain.ConflictError{Message: "Idempotency key reused"}, http.StatusConflict, "Idempotency key reused", ""},
+		{"Unauthorized", &domain.UnauthorizedError{Message: "Invalid token"}, http.StatusUnauthorized, "Invalid token", ""},
+		{"Forbidden", &domain.ForbiddenError{Message: "Not your trade"}, http.StatusForbidden, "Not your trade", ""},
+		{"RateLimit", &domain.RateLimitError{RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests, "Rate limit exceeded, retry in 2 seconds", ""},
+		{"HTTPError", echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload"), http.StatusBadRequest, "Invalid request payload", ""},
+		// echo's own errors carry their status and a message that is no string
+		{"HTTPErrorWithoutMessage", echo.NewHTTPError(http.StatusRequestEntityTooLarge, map[string]int{"limit": 1}), http.StatusRequestEntityTooLarge, "Request Entity Too Large", ""},
+		{"HTTPErrorHidesInternal", echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload").SetInternal(errors.New("json: secret field")), http.StatusBadRequest, "Invalid request payload", ""},
+		{"DeadlineExceeded", fmt.Errorf("fetching quotes: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, "Request timed out", ""},
+		// causes of unknown errors stay in the log
+		{"Unknown", errors.New(`pq: password authentication failed for user "portfolio"`), http.StatusInternalServerError, "Internal server error", ""},
+		{"Canceled", context.Canceled, http.StatusInternalServerError, "Internal server error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.HTTPErrorHandler = handlers.HTTPErrorHandler
+			e.GET("/fail", func(c echo.Context) error { return tt.err })
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get(echo.HeaderContentType); got != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", got)
+			}
+			if got, want := rec.Header().Get(echo.HeaderWWWAuthenticate), map[bool]string{true: "Bearer"}[tt.status == http.StatusUnauthorized]; got != want {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, want)
+			}
+
+			var body struct {
+				handlers.Problem
+				Errors json.RawMessage `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding body %q: %v", rec.Body, err)
+			}
+			want := handlers.Problem{Type: "about:blank", Title: http.StatusText(tt.status), Status: tt.status, Detail: tt.detail, Instance: "/fail"}
+			body.Problem.Errors = nil
+			if body.Problem != want {
+				t.Errorf("problem = %+v, want %+v", body.Problem, want)
+			}
+			if string(body.Errors) != tt.errors {
+				t.Errorf("errors = %s, want %s", body.Errors, tt.errors)
+			}
+			if tt.status == http.StatusInternalServerError && strings.Contains(rec.Body.String(), tt.err.Error()) {
+				t.Errorf("body %s leaks the cause", rec.Body)
+			}
+		})
+	}
+}
+
+func TestHTTPErrorHandlerRoutes(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/trades/:id", func(c echo.Context) error { return domain.TradeNotFound(1) })
+
+	tests := []struct {
+		name         string
+		method, path string
+		status       int
+		// HEAD answers carry no body
+		body bool
+	}{
+		{"UnknownRoute", http.MethodGet, "/nowhere", http.StatusNotFound, true},
+		{"WrongMethod", http.MethodPost, "/trades/1", http.StatusMethodNotAllowed, true},
+		{"Head", http.MethodHead, "/trades/1", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get(echo.HeaderContentType); got != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", got)
+			}
+			if (rec.Body.Len() > 0) != tt.body {
+				t.Errorf("body %q", rec.Body)
+			}
+		})
+	}
+}
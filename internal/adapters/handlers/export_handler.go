@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/pkg/export"
+)
+
+// Column order of every export, kept stable for downstream consumers so new columns go last
+var (
+	tradeColumns     = []string{"id", "userId", "ticker", "type", "quantity", "price", "timestamp", "costBasis", "lotIds", "currency", "accountId"}
+	portfolioColumns = []string{"userId", "ticker", "quantity", "averageBuyPrice", "realizedPnl", "lastUpdated", "price", "priceFetchedAt", "priceStale", "currency"}
+	returnsColumns   = []string{"userId", "ticker", "quantity", "averageBuyPrice", "price", "realizedPnl", "unrealizedPnl", "totalPnl", "priced", "currency"}
+)
+
+// ExportTrades streams the trades of a user as a file
+// @Summary Export user trades
+// @Description Streams the trades of a user in timestamp order, oldest first unless sort=desc, as CSV, JSON Lines or XLSX
+// @Tags trades
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param userId path string true "User ID"
+// @Param format query string false "csv (default), jsonl or xlsx"
+// @Param ticker query string false "Ticker"
+// @Param type query string false "BUY or SELL"
+// @Param accountId query int false "Account ID"
+// @Param from query string false "Start date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Param sort query string false "asc (default) or desc"
+// @Success 200 {file} file
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /trades/{userId}/export [get]
+func (h *APIHandler) ExportTrades(c echo.Context) error {
+	userID := c.Param("userId")
+	filter, err := parseTradeFilter(c, domain.DefaultExportSort)
+	if err != nil {
+		return err
+	}
+
+	return streamExport(c, "trades-"+userID, tradeColumns, func(w export.Writer) error {
+		return h.tradeService.StreamTrades(c.Request().Context(), userID, filter, func(trade *domain.Trade) error {
+			lotIDs := make([]string, len(trade.LotIDs))
+			for i, id := range trade.LotIDs {
+				lotIDs[i] = fmt.Sprint(id)
+			}
+			return w.WriteRow(trade.Id, trade.UserID, trade.Ticker, string(trade.Type), trade.Quantity, trade.Price,
+				trade.Timestamp, string(trade.CostBasis), strings.Join(lotIDs, ";"), string(trade.Currency), trade.AccountID)
+		})
+	})
+}
+
+// ExportPortfolio exports the portfolio of a user as a file
+// @Summary Export user portfolio
+// @Description Exports the holdings of a user as CSV, JSON Lines or XLSX, the date range filters on lastUpdated
+// @Tags portfolio
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param userId path string true "User ID"
+// @Param format query string false "csv (default), jsonl or xlsx"
+// @Param from query string false "Start date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Success 200 {file} file
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /portfolio/{userId}/export [get]
+func (h *APIHandler) ExportPortfolio(c echo.Context) error {
+	userID := c.Param("userId")
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return err
+	}
+
+	portfolio, err := h.portfolioService.FetchPortfolio(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return streamExport(c, "portfolio-"+userID, portfolioColumns, func(w export.Writer) error {
+		for _, security := range portfolio {
+			if !inRange(security.LastUpdated, from, to) {
+				continue
+			}
+			var price, fetchedAt, stale any
+			if security.Price != nil {
+				price, fetchedAt, stale = security.Price.Price, security.Price.FetchedAt, security.Price.Stale
+			}
+			err := w.WriteRow(security.UserID, security.Ticker, security.Quantity, security.AverageBuyPrice,
+				security.RealizedPnL, security.LastUpdated, price, fetchedAt, stale, string(security.Currency))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExportReturns exports the returns of a user as a file
+// @Summary Export user returns
+// @Description Exports per ticker P&L of a user as CSV, JSON Lines or XLSX, the date range filters on the last trade of each ticker and currency
+// @Tags returns
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param userId query string false "User ID, defaults to the token subject"
+// @Param format query string false "csv (default), jsonl or xlsx"
+// @Param from query string false "Start date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Param to query string false "End date, inclusive (YYYY-MM-DD or RFC3339)"
+// @Success 200 {file} file
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /returns/export [get]
+func (h *APIHandler) ExportReturns(c echo.Context) error {
+	userID := requestedUser(c, c.QueryParam("userId"))
+	from, to, err := parseDateRange(c)
+	if err != nil {
+		return err
+	}
+
+	portfolio, err := h.portfolioService.FetchPortfolio(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+	// a ticker held in several currencies is a holding per currency, each with its own last trade
+	lastUpdated := make(map[domain.QuoteKey]time.Time, len(portfolio))
+	for _, security := range portfolio {
+		lastUpdated[domain.QuoteKey{Ticker: security.Ticker, Currency: security.Currency}] = security.LastUpdated
+	}
+
+	returns, err := h.portfolioService.FetchReturns(c.Request().Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return streamExport(c, "returns-"+userID, returnsColumns, func(w export.Writer) error {
+		for _, position := range returns.Positions {
+			if !inRange(lastUpdated[domain.QuoteKey{Ticker: position.Ticker, Currency: position.Currency}], from, to) {
+				continue
+			}
+			var price any
+			if position.Price != nil {
+				price = position.Price.Price
+			}
+			err := w.WriteRow(userID, position.Ticker, position.Quantity, position.AverageBuyPrice, price,
+				position.RealizedPnL, position.UnrealizedPnL, position.TotalPnL, position.Priced, string(position.Currency))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Writes rows produced by write straight to the response in the requested format
+func streamExport(c echo.Context, name string, columns []string, write func(export.Writer) error) error {
+	format := strings.ToLower(c.QueryParam("format"))
+	if format == "" {
+		format = export.CSV
+	}
+	if format != export.CSV && format != export.JSONL && format != export.XLSX {
+		return domain.NewValidationError("format", "Format must be csv, jsonl or xlsx")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, export.ContentType(format))
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	res.WriteHeader(http.StatusOK)
+
+	w, err := export.NewWriter(format, res, columns)
+	if err == nil {
+		err = write(w)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	// Headers are already sent, a failure can only cut the download short
+	if err != nil {
+		log.Printf("export %s failed: %v", name, err)
+	}
+	return nil
+}
+
+// Reads the from/to query params, to is returned as an exclusive bound
+func parseDateRange(c echo.Context) (time.Time, time.Time, error) {
+	var from, to time.Time
+	if value := c.QueryParam("from"); value != "" {
+		t, err := parseDate(value)
+		if err != nil {
+			return from, to, domain.NewValidationError("from", fmt.Sprintf("Invalid from date %q", value))
+		}
+		from = t
+	}
+	if value := c.QueryParam("to"); value != "" {
+		t, err := parseDate(value)
+		if err != nil {
+			return from, to, domain.NewValidationError("to", fmt.Sprintf("Invalid to date %q", value))
+		}
+		// a bare date covers the whole day
+		if len(value) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1)
+		} else {
+			t = t.Add(time.Nanosecond)
+		}
+		to = t
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, domain.NewValidationError("from", "from must be before to")
+	}
+	return from, to, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Checks t against [from, to), zero bounds are open
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
@@ -0,0 +1,84 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/handlers"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+func TestExportReturnsDateRange(t *testing.T) {
+	ctx := context.Background()
+	repos := repositories.NewMemoryRepository()
+	// TCS in INR last traded on the 1st, in USD from another account on the 20th
+	inr := &domain.Account{UserID: "u1", Name: "Broker", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	usd := &domain.Account{UserID: "u1", Name: "US broker", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, account := range []*domain.Account{inr, usd} {
+		if err := repos.Accounts.CreateAccount(ctx, account); err != nil {
+			t.Fatalf("CreateAccount: %v", err)
+		}
+	}
+	trades := []*domain.Trade{
+		{UserID: "u1", Ticker: "TCS", Currency: "INR", AccountID: inr.ID, Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{UserID: "u1", Ticker: "TCS", Currency: "USD", AccountID: usd.ID, Timestamp: time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, trade := range trades {
+		trade.Type, trade.Quantity, trade.Price = domain.Buy, decimal.NewFromInt(1), decimal.NewFromInt(100)
+		if err := repos.Trades.AddTrade(ctx, trade); err != nil {
+			t.Fatalf("AddTrade: %v", err)
+		}
+	}
+
+	h := newHandler(repos, services.NewAccessService(repos.Advisors))
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Use(handlers.AllowAnonymous())
+	e.GET("/returns/export", h.ExportReturns)
+
+	tests := []struct {
+		name  string
+		query string
+		// currencies of the exported rows
+		want []string
+	}{
+		{"Everything", "", []string{"INR", "USD"}},
+		{"BeforeUSDTrade", "&to=2024-01-10", []string{"INR"}},
+		{"AfterINRTrade", "&from=2024-01-10", []string{"USD"}},
+		{"Neither", "&from=2024-01-05&to=2024-01-10", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/returns/export?userId=u1&format=jsonl"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			var currencies []string
+			decoder := json.NewDecoder(rec.Body)
+			for decoder.More() {
+				var row struct {
+					Ticker   string `json:"ticker"`
+					Currency string `json:"currency"`
+				}
+				if err := decoder.Decode(&row); err != nil {
+					t.Fatalf("decoding export: %v", err)
+				}
+				currencies = append(currencies, row.Currency)
+			}
+			if fmt.Sprint(currencies) != fmt.Sprint(tt.want) {
+				t.Errorf("rows in %v, want %v", currencies, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	maxIdempotencyKeyLength = 255
+)
+
+// Runs create once per Idempotency-Key of userID and responds with its result. Retries with the same
+// key and body get the stored response back, a failed create frees the key so it can be retried.
+func (h *APIHandler) idempotent(c echo.Context, userID string, body []byte, create func() (int, any, error)) error {
+	key := c.Request().Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		status, response, err := create()
+		if err != nil {
+			return err
+		}
+		return c.JSON(status, response)
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return domain.NewValidationError(idempotencyKeyHeader, fmt.Sprintf("Idempotency-Key cannot be longer than %d characters", maxIdempotencyKeyLength))
+	}
+
+	ctx := c.Request().Context()
+	hash := sha256.Sum256(body)
+	record, err := h.idempotencyService.Begin(ctx, userID, key, hex.EncodeToString(hash[:]))
+	if err != nil {
+		return err
+	}
+	if record != nil {
+		c.Response().Header().Set("Idempotent-Replayed", "true")
+		return c.JSONBlob(record.Status, []byte(record.Response))
+	}
+
+	// the outcome is stored even when the client stopped waiting, its retry is what needs it
+	storeCtx := context.WithoutCancel(ctx)
+	status, response, err := create()
+	if err != nil {
+		if abortErr := h.idempotencyService.Abort(storeCtx, userID, key); abortErr != nil {
+			log.Printf("failed to release idempotency key: %v", abortErr)
+		}
+		return err
+	}
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if err := h.idempotencyService.Complete(storeCtx, userID, key, status, string(encoded)); err != nil {
+		log.Printf("failed to store idempotent response: %v", err)
+	}
+	return c.JSONBlob(status, encoded)
+}
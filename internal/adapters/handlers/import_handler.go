@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+// Accepted layouts for the timestamp column
+var importTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// ImportTrades adds trades in bulk from a CSV file
+// @Summary Import trades
+// @Description Imports trades from CSV with the header userId,ticker,type,quantity,price,timestamp,currency,accountId (timestamp, currency and accountId optional).
+// @Description Rows without an accountId go to the default account of their user.
+// @Description Every row is validated like POST /trades and the batch is applied in a single transaction.
+// @Description Rows in the error report are numbered from 1 for the first line after the header, row 0 is an already stored trade the import would break.
+// @Description Open to admins and read-write API keys.
+// @Tags trades
+// @Accept text/csv
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file false "CSV file, alternatively send the CSV as the request body"
+// @Param dryRun query bool false "Preview the resulting portfolio without storing anything"
+// @Param X-Actor header string false "Who makes the change when authentication is disabled, otherwise the token subject is recorded"
+// @Param X-Change-Reason header string false "Why the change is made, recorded in the trade history"
+// @Success 200 {object} domain.ImportResult "Dry run, the portfolio the import would produce, nothing is stored"
+// @Success 201 {object} domain.ImportResult "Trades imported"
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 429 {object} Problem
+// @Failure 500 {object} Problem
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /trades/import [post]
+func (h *APIHandler) ImportTrades(c echo.Context) error {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+
+	body := io.Reader(c.Request().Body)
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid file")
+		}
+		defer f.Close()
+		body = f
+	}
+
+	trades, rowErrors, err := parseTradesCSV(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(rowErrors) > 0 {
+		return &domain.ImportError{Errors: rowErrors}
+	}
+
+	// rows without a userId belong to the caller, every other user needs access
+	authorized := make(map[string]bool)
+	for _, trade := range trades {
+		trade.UserID = requestedUser(c, trade.UserID)
+		if authorized[trade.UserID] {
+			continue
+		}
+		if err := h.authorize(c, domain.ImportTrades, trade.UserID); err != nil {
+			return err
+		}
+		authorized[trade.UserID] = true
+	}
+
+	result, err := h.tradeService.ImportTrades(changeContext(c), trades, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		return c.JSON(http.StatusOK, result)
+	}
+	return c.JSON(http.StatusCreated, result)
+}
+
+// Parses and validates every row, collecting row errors instead of stopping at the first one
+func parseTradesCSV(r io.Reader) ([]*domain.Trade, []*domain.RowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "")] = i
+	}
+	for _, name := range []string{"userid", "ticker", "type", "quantity", "price"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("CSV header is missing column %q", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var trades []*domain.Trade
+	var rowErrors []*domain.RowError
+	now := time.Now()
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, &domain.RowError{Row: row, Error: err.Error()})
+			continue
+		}
+
+		trade, err := parseTradeRecord(field, record, now)
+		if err != nil {
+			rowErrors = append(rowErrors, &domain.RowError{Row: row, Error: err.Error()})
+			continue
+		}
+		trades = append(trades, trade)
+	}
+
+	if len(trades) == 0 && len(rowErrors) == 0 {
+		return nil, nil, errors.New("CSV has no trades")
+	}
+	return trades, rowErrors, nil
+}
+
+func parseTradeRecord(field func([]string, string) string, record []string, now time.Time) (*domain.Trade, error) {
+	trade := &domain.Trade{
+		UserID:    field(record, "userid"),
+		Ticker:    field(record, "ticker"),
+		Type:      domain.TradeType(strings.ToUpper(field(record, "type"))),
+		Timestamp: now,
+	}
+	quantity, err := decimal.NewFromString(field(record, "quantity"))
+	if err != nil {
+		return nil, errors.New("Quantity must be a number")
+	}
+	trade.Quantity = quantity
+
+	price, err := decimal.NewFromString(field(record, "price"))
+	if err != nil {
+		return nil, errors.New("Price must be a number")
+	}
+	trade.Price = price
+
+	trade.Currency = domain.Currency(strings.ToUpper(field(record, "currency")))
+
+	if value := field(record, "accountid"); value != "" {
+		accountID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("Account ID must be a number")
+		}
+		trade.AccountID = accountID
+	}
+
+	if value := field(record, "timestamp"); value != "" {
+		timestamp, err := parseImportTime(value)
+		if err != nil {
+			return nil, err
+		}
+		trade.Timestamp = timestamp
+	}
+	return trade, nil
+}
+
+func parseImportTime(value string) (time.Time, error) {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid timestamp %q", value)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// Response headers of the IETF RateLimit header fields draft
+const (
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+)
+
+// Middleware counting every request not skipped against the quota of its client, reads and writes separately.
+// Runs after Authenticate so clients are told apart by API key or user, anonymous requests by IP.
+// Requests are let through when the store fails, an outage of the limiter doesn't take the API down.
+func RateLimit(limiter ports.RateLimiter, skipper middleware.Skipper) echo.MiddlewareFunc {
+	return rateLimit(skipper, func(c echo.Context) (*domain.RateLimitResult, error) {
+		return limiter.Allow(c.Request().Context(), rateLimitClient(c), writeRequest(c.Request().Method))
+	})
+}
+
+// Middleware counting every request not skipped against the quota of its IP address. Runs before Authenticate
+// so requests with bad credentials are counted too and guessing tokens or keys is held to the address quota.
+// The client quota checked after authentication sets the RateLimit headers again.
+func RateLimitAddress(limiter ports.RateLimiter, skipper middleware.Skipper) echo.MiddlewareFunc {
+	return rateLimit(skipper, func(c echo.Context) (*domain.RateLimitResult, error) {
+		return limiter.AllowAddress(c.Request().Context(), c.RealIP())
+	})
+}
+
+// Takes a token with allow and reports the bucket in the RateLimit headers, denied requests get a
+// Retry-After header and a RateLimitError
+func rateLimit(skipper middleware.Skipper, allow func(c echo.Context) (*domain.RateLimitResult, error)) echo.MiddlewareFunc {
+	if skipper == nil {
+		skipper = middleware.DefaultSkipper
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+
+			result, err := allow(c)
+			if err != nil {
+				log.Printf("rate limit check failed, letting request through: %v", err)
+				return next(c)
+			}
+			if result == nil {
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set(headerRateLimitLimit, strconv.Itoa(result.Limit))
+			header.Set(headerRateLimitRemaining, strconv.Itoa(result.Remaining))
+			header.Set(headerRateLimitReset, strconv.FormatInt(domain.CeilSeconds(result.Reset), 10))
+			if !result.Allowed {
+				header.Set(echo.HeaderRetryAfter, strconv.FormatInt(domain.CeilSeconds(result.RetryAfter), 10))
+				return &domain.RateLimitError{RetryAfter: result.RetryAfter}
+			}
+			return next(c)
+		}
+	}
+}
+
+// Bucket owner of a request, the API key of service clients, the user of tokens or else the client IP
+func rateLimitClient(c echo.Context) string {
+	if principal, ok := domain.PrincipalFrom(c.Request().Context()); ok {
+		if principal.Role == domain.Service && principal.Subject != "" {
+			return principal.Subject
+		}
+		if principal.UserID != "" {
+			return "user:" + principal.UserID
+		}
+	}
+	return "ip:" + c.RealIP()
+}
+
+// Anything but reading counts against the write quota
+func writeRequest(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/handlers"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/ratelimit"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+// Two requests a minute, a token every 30 seconds
+var testQuota = domain.Quota{Limit: 2, Period: time.Minute}
+
+// Verifies tokens with a function
+type authenticatorFunc func(ctx context.Context, token string) (*domain.Principal, error)
+
+func (f authenticatorFunc) Authenticate(ctx context.Context, token string) (*domain.Principal, error) {
+	return f(ctx, token)
+}
+
+// Accepts the token "good" for user u1 and nothing else
+var testTokens = authenticatorFunc(func(ctx context.Context, token string) (*domain.Principal, error) {
+	if token != "good" {
+		return nil, &domain.UnauthorizedError{Message: "Invalid token"}
+	}
+	return &domain.Principal{Subject: "u1", UserID: "u1", Role: domain.Investor}, nil
+})
+
+// Fails every take, like an unreachable Redis
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, quota domain.Quota, now time.Time) (*domain.RateLimitResult, error) {
+	return nil, errors.New("connection refused")
+}
+
+// Server limiting addresses before authentication and clients after it, like cmd/main.go
+func newLimitedServer(store ports.RateLimitStore, read, write, address domain.Quota) *echo.Echo {
+	limiter := services.NewRateLimiter(store, read, write, address)
+	public := func(c echo.Context) bool { return c.Path() == "/status" }
+
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.Use(handlers.RateLimitAddress(limiter, public))
+	e.Use(handlers.Authenticate(testTokens, testTokens, public))
+	e.Use(handlers.RateLimit(limiter, public))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	e.GET("/status", ok)
+	e.GET("/trades", ok)
+	e.POST("/trades", ok)
+	return e
+}
+
+func send(e *echo.Echo, method, path, token, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":40000"
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimit(t *testing.T) {
+	type request struct {
+		method, path, token, ip string
+		status                  int
+		// RateLimit-Remaining expected, "" when the header must be absent
+		remaining string
+	}
+	get := func(token, ip string, status int, remaining string) request {
+		return request{http.MethodGet, "/trades", token, ip, status, remaining}
+	}
+	post := func(token, ip string, status int, remaining string) request {
+		return request{http.MethodPost, "/trades", token, ip, status, remaining}
+	}
+	unlimited := domain.Quota{}
+
+	tests := []struct {
+		name                 string
+		store                ports.RateLimitStore
+		read, write, address domain.Quota
+		requests             []request
+	}{
+		{
+			name: "ClientBucketRunsOut",
+			read: testQuota, write: testQuota, address: unlimited,
+			requests: []request{
+				get("good", "10.0.0.1", http.StatusNoContent, "1"),
+				get("good", "10.0.0.2", http.StatusNoContent, "0"),
+				get("good", "10.0.0.3", http.StatusTooManyRequests, "0"),
+			},
+		},
+		{
+			name: "ReadsAndWritesAreSeparate",
+			read: testQuota, write: testQuota, address: unlimited,
+			requests: []request{
+				get("good", "10.0.0.1", http.StatusNoContent, "1"),
+				get("good", "10.0.0.1", http.StatusNoContent, "0"),
+				post("good", "10.0.0.1", http.StatusNoContent, "1"),
+			},
+		},
+		{
+			// guessing credentials is limited by the address, the request never reaches Authenticate
+			name: "BadCredentialsCountAgainstAddress",
+			read: unlimited, write: unlimited, address: testQuota,
+			requests: []request{
+				get("bad", "10.0.0.1", http.StatusUnauthorized, "1"),
+				get("", "10.0.0.1", http.StatusUnauthorized, "0"),
+				get("good", "10.0.0.1", http.StatusTooManyRequests, "0"),
+				get("good", "10.0.0.2", http.StatusNoContent, "1"),
+			},
+		},
+		{
+			name: "PublicRoutesAreNotLimited",
+			read: testQuota, write: testQuota, address: testQuota,
+			requests: []request{
+				{http.MethodGet, "/status", "", "10.0.0.1", http.StatusNoContent, ""},
+				{http.MethodGet, "/status", "", "10.0.0.1", http.StatusNoContent, ""},
+				{http.MethodGet, "/status", "", "10.0.0.1", http.StatusNoContent, ""},
+			},
+		},
+		{
+			name:  "StoreOutageLetsRequestsThrough",
+			store: failingStore{},
+			read:  testQuota, write: testQuota, address: testQuota,
+			requests: []request{
+				get("good", "10.0.0.1", http.StatusNoContent, ""),
+				get("good", "10.0.0.1", http.StatusNoContent, ""),
+				get("good", "10.0.0.1", http.StatusNoContent, ""),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store
+			if store == nil {
+				store = ratelimit.NewMemoryStore()
+			}
+			e := newLimitedServer(store, tt.read, tt.write, tt.address)
+			for i, r := range tt.requests {
+				rec := send(e, r.method, r.path, r.token, r.ip)
+				if rec.Code != r.status {
+					t.Fatalf("request %d: status %d, want %d", i, rec.Code, r.status)
+				}
+				if got := rec.Header().Get("RateLimit-Remaining"); got != r.remaining {
+					t.Errorf("request %d: RateLimit-Remaining %q, want %q", i, got, r.remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitDeniedResponse(t *testing.T) {
+	e := newLimitedServer(ratelimit.NewMemoryStore(), testQuota, testQuota, domain.Quota{})
+	for i := 0; i < testQuota.Limit; i++ {
+		send(e, http.MethodGet, "/trades", "good", "10.0.0.1")
+	}
+	rec := send(e, http.MethodGet, "/trades", "good", "10.0.0.1")
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", rec.Code)
+	}
+	headers := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"Retry-After":         "30",
+		"Content-Type":        "application/problem+json",
+	}
+	// waits are rounded up to whole seconds, so the refill while the test runs doesn't show
+	for name, want := range headers {
+		if got := rec.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	var problem handlers.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("decoding body %q: %v", rec.Body, err)
+	}
+	if problem.Status != http.StatusTooManyRequests || problem.Title != "Too Many Requests" || problem.Instance != "/trades" {
+		t.Errorf("problem = %+v", problem)
+	}
+	if problem.Detail != "Rate limit exceeded, retry in 30 seconds" {
+		t.Errorf("detail = %q", problem.Detail)
+	}
+}
@@ -0,0 +1,35 @@
+package prices
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// Serves quotes stored by the price refresher instead of calling the upstream provider
+type cachedProvider struct {
+	quoteRepo ports.QuoteRepository
+}
+
+// Creates a Price Provider reading from the quote cache
+func NewCachedProvider(quoteRepo ports.QuoteRepository) ports.PriceProvider {
+	return &cachedProvider{quoteRepo: quoteRepo}
+}
+
+func (p *cachedProvider) LatestQuote(ctx context.Context, key domain.QuoteKey) (*domain.Quote, error) {
+	quotes, err := p.quoteRepo.FetchQuotes(ctx, []domain.QuoteKey{key})
+	if err != nil {
+		return nil, err
+	}
+	quote, ok := quotes[key]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", domain.ErrNoQuote, key)
+	}
+	return quote, nil
+}
+
+func (p *cachedProvider) LatestQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error) {
+	return p.quoteRepo.FetchQuotes(ctx, keys)
+}
@@ -0,0 +1,187 @@
+package prices
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// Serves quotes from a local CSV (ticker,price[,currency]) or JSON ({"TICKER": price} or {"TICKER/USD": price}) file.
+// Prices without a currency are in the default currency. The file is re-read whenever its modification time changes.
+type fileProvider struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	quotes  map[domain.QuoteKey]*domain.Quote
+}
+
+// Creates a new file backed Price Provider
+func NewFileProvider(path string) (ports.PriceProvider, error) {
+	p := &fileProvider{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *fileProvider) LatestQuote(ctx context.Context, key domain.QuoteKey) (*domain.Quote, error) {
+	quotes, err := p.LatestQuotes(ctx, []domain.QuoteKey{key})
+	if err != nil {
+		return nil, err
+	}
+	quote, ok := quotes[key]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", domain.ErrNoQuote, key)
+	}
+	return quote, nil
+}
+
+func (p *fileProvider) LatestQuotes(_ context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error) {
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make(map[domain.QuoteKey]*domain.Quote, len(keys))
+	for _, key := range keys {
+		if quote, ok := p.quotes[domain.QuoteKey{Ticker: normalizeTicker(key.Ticker), Currency: key.Currency}]; ok {
+			q := *quote
+			q.Ticker = key.Ticker
+			result[key] = &q
+		}
+	}
+	return result, nil
+}
+
+// Re-reads the price file if it changed since the last read
+func (p *fileProvider) reload() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read price file: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.quotes != nil && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read price file: %w", err)
+	}
+	defer f.Close()
+
+	var prices map[domain.QuoteKey]decimal.Decimal
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".json":
+		prices, err = parseJSON(f)
+	default:
+		prices, err = parseCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse price file %s: %w", p.path, err)
+	}
+
+	quotes := make(map[domain.QuoteKey]*domain.Quote, len(prices))
+	for key, price := range prices {
+		if !price.IsPositive() {
+			return fmt.Errorf("invalid price %s for %s in %s", price, key, p.path)
+		}
+		quotes[key] = &domain.Quote{Ticker: key.Ticker, Currency: key.Currency, Price: price, FetchedAt: info.ModTime()}
+	}
+
+	p.quotes = quotes
+	p.modTime = info.ModTime()
+	return nil
+}
+
+// Parses ticker,price[,currency] rows, a header row is skipped if present
+func parseCSV(r io.Reader) (map[domain.QuoteKey]decimal.Decimal, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	prices := make(map[domain.QuoteKey]decimal.Decimal)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return prices, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != 2 && len(record) != 3 {
+			return nil, fmt.Errorf("line %d: want ticker,price or ticker,price,currency", line)
+		}
+		price, err := decimal.NewFromString(strings.TrimSpace(record[1]))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid price %q", line, record[1])
+		}
+		currency := ""
+		if len(record) == 3 {
+			currency = record[2]
+		}
+		key, err := quoteKey(record[0], currency)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		prices[key] = price
+	}
+}
+
+// Parses {"TICKER": price} objects, a ticker may name its currency like "AAPL/USD"
+func parseJSON(r io.Reader) (map[domain.QuoteKey]decimal.Decimal, error) {
+	var values map[string]decimal.Decimal
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		return nil, err
+	}
+	prices := make(map[domain.QuoteKey]decimal.Decimal, len(values))
+	for name, price := range values {
+		ticker, currency, _ := strings.Cut(name, "/")
+		key, err := quoteKey(ticker, currency)
+		if err != nil {
+			return nil, err
+		}
+		prices[key] = price
+	}
+	return prices, nil
+}
+
+// Key of a quote read from the file, an empty currency is the default one
+func quoteKey(ticker, currency string) (domain.QuoteKey, error) {
+	key := domain.QuoteKey{Ticker: normalizeTicker(ticker), Currency: normalizeCurrency(currency)}
+	if !key.Currency.Valid() {
+		return key, fmt.Errorf("unsupported currency %q for %s", currency, key.Ticker)
+	}
+	return key, nil
+}
+
+func normalizeTicker(ticker string) string {
+	return strings.ToUpper(strings.TrimSpace(ticker))
+}
+
+// Quotes without a currency are in the default currency
+func normalizeCurrency(currency string) domain.Currency {
+	if currency = strings.ToUpper(strings.TrimSpace(currency)); currency == "" {
+		return domain.DefaultCurrency
+	}
+	return domain.Currency(currency)
+}
@@ -0,0 +1,96 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// Fetches quotes from a quote server exposing
+// GET {baseURL}/quotes?tickers=A,B -> [{"ticker":"A","price":1.5,"currency":"USD"}, ...]
+// Quotes without a currency are in the default currency.
+type httpProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// Creates a new HTTP backed Price Provider
+func NewHTTPProvider(baseURL string, timeout time.Duration) ports.PriceProvider {
+	return &httpProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *httpProvider) LatestQuote(ctx context.Context, key domain.QuoteKey) (*domain.Quote, error) {
+	quotes, err := p.LatestQuotes(ctx, []domain.QuoteKey{key})
+	if err != nil {
+		return nil, err
+	}
+	quote, ok := quotes[key]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", domain.ErrNoQuote, key)
+	}
+	return quote, nil
+}
+
+func (p *httpProvider) LatestQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error) {
+	result := make(map[domain.QuoteKey]*domain.Quote, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	// a ticker held in several currencies is asked for once
+	requested := make(map[domain.QuoteKey]domain.QuoteKey, len(keys))
+	var tickers []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		ticker := normalizeTicker(key.Ticker)
+		requested[domain.QuoteKey{Ticker: ticker, Currency: key.Currency}] = key
+		if !seen[ticker] {
+			seen[ticker] = true
+			tickers = append(tickers, ticker)
+		}
+	}
+
+	endpoint := p.baseURL + "/quotes?tickers=" + url.QueryEscape(strings.Join(tickers, ","))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch quotes: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch quotes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch quotes: quote server returned %s", resp.Status)
+	}
+
+	var quotes []*domain.Quote
+	if err := json.NewDecoder(resp.Body).Decode(&quotes); err != nil {
+		return nil, fmt.Errorf("failed to decode quotes: %w", err)
+	}
+
+	fetchedAt := time.Now()
+	for _, quote := range quotes {
+		key, ok := requested[domain.QuoteKey{Ticker: normalizeTicker(quote.Ticker), Currency: normalizeCurrency(string(quote.Currency))}]
+		if !ok || !quote.Price.IsPositive() {
+			continue
+		}
+		quote.Ticker, quote.Currency = key.Ticker, key.Currency
+		if quote.FetchedAt.IsZero() {
+			quote.FetchedAt = fetchedAt
+		}
+		result[key] = quote
+	}
+	return result, nil
+}
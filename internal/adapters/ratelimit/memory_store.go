@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// How often buckets that refilled completely are dropped, a missing bucket is the same as a full one
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	quota   domain.Quota
+}
+
+// Keeps buckets in process memory, each instance of the server limits on its own
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Creates a Rate Limit Store holding buckets in memory
+func NewMemoryStore() ports.RateLimitStore {
+	return &memoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *memoryStore) Take(ctx context.Context, key string, quota domain.Quota, now time.Time) (*domain.RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(quota.Limit), updated: now}
+		s.buckets[key] = b
+	} else if now.After(b.updated) {
+		b.tokens = min(float64(quota.Limit), b.tokens+quota.Refill(now.Sub(b.updated)))
+		b.updated = now
+	}
+	b.quota = quota
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return quota.Result(allowed, b.tokens), nil
+}
+
+// Drops full buckets once per sweepInterval so clients that went away don't pile up
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if b.tokens+b.quota.Refill(now.Sub(b.updated)) >= float64(b.quota.Limit) {
+			delete(s.buckets, key)
+		}
+	}
+}
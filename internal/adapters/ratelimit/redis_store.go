@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+const redisKeyPrefix = "ratelimit:"
+
+// Refills and takes from the bucket hash in one step so instances sharing it never race.
+// Tokens are returned as a string as Redis truncates Lua numbers to integers.
+var takeScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil or updated == nil then
+	tokens = limit
+	updated = now
+elseif now > updated then
+	tokens = math.min(limit, tokens + (now - updated) * limit / period)
+	updated = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', updated)
+redis.call('PEXPIRE', KEYS[1], math.ceil((limit - tokens) * period / limit) + 1)
+return {allowed, tostring(tokens)}
+`)
+
+// Keeps buckets in Redis, or anything speaking its protocol, so every instance shares the same quotas
+type redisStore struct {
+	client *redis.Client
+}
+
+// Creates a Rate Limit Store on the Redis server at url, like redis://localhost:6379/0
+func NewRedisStore(url string) (ports.RateLimitStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connecting to redis: %w", err)
+	}
+	return &redisStore{client: client}, nil
+}
+
+func (s *redisStore) Take(ctx context.Context, key string, quota domain.Quota, now time.Time) (*domain.RateLimitResult, error) {
+	reply, err := takeScript.Run(ctx, s.client, []string{redisKeyPrefix + key},
+		quota.Limit, quota.Period.Milliseconds(), now.UnixMilli()).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("taking rate limit token: %w", err)
+	}
+	if len(reply) != 2 {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	allowed, _ := reply[0].(int64)
+	remaining, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(remaining, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	return quota.Result(allowed == 1, tokens), nil
+}
@@ -0,0 +1,130 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/ratelimit"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+func TestMemoryStore(t *testing.T) {
+	runStore(t, func(t *testing.T) ports.RateLimitStore {
+		return ratelimit.NewMemoryStore()
+	})
+}
+
+func TestRedisStore(t *testing.T) {
+	runStore(t, func(t *testing.T) ports.RateLimitStore {
+		server := miniredis.RunT(t)
+		store, err := ratelimit.NewRedisStore("redis://" + server.Addr())
+		if err != nil {
+			t.Fatalf("NewRedisStore: %v", err)
+		}
+		return store
+	})
+}
+
+// Runs the same checks against every store so they limit alike
+func runStore(t *testing.T, newStore func(t *testing.T) ports.RateLimitStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store ports.RateLimitStore)
+	}{
+		{"BurstThenDeny", testBurstThenDeny},
+		{"RefillsOverPeriod", testRefillsOverPeriod},
+		{"KeysAreSeparate", testKeysAreSeparate},
+		{"ClockGoingBack", testClockGoingBack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+var (
+	quota = domain.Quota{Limit: 3, Period: 3 * time.Second}
+	start = time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+)
+
+func take(t *testing.T, store ports.RateLimitStore, key string, now time.Time) *domain.RateLimitResult {
+	t.Helper()
+	result, err := store.Take(context.Background(), key, quota, now)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	return result
+}
+
+func testBurstThenDeny(t *testing.T, store ports.RateLimitStore) {
+	for i := 0; i < quota.Limit; i++ {
+		result := take(t, store, "client", start)
+		if !result.Allowed || result.Remaining != quota.Limit-1-i || result.Limit != quota.Limit {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", i, result, quota.Limit-1-i)
+		}
+	}
+	if result := take(t, store, "client", start); result.Reset != 3*time.Second {
+		t.Errorf("empty bucket resets in %s, want 3s", result.Reset)
+	}
+
+	result := take(t, store, "client", start.Add(500*time.Millisecond))
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("got %+v, want denied with nothing remaining", result)
+	}
+	if result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("retry after %s, want 500ms", result.RetryAfter)
+	}
+}
+
+func testRefillsOverPeriod(t *testing.T, store ports.RateLimitStore) {
+	for i := 0; i < quota.Limit; i++ {
+		take(t, store, "client", start)
+	}
+
+	result := take(t, store, "client", start.Add(time.Second))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("after a third of the period got %+v, want one request allowed", result)
+	}
+	if result.Reset != 3*time.Second {
+		t.Errorf("reset in %s, want 3s", result.Reset)
+	}
+
+	// refills stop at the limit however long the client was away
+	result = take(t, store, "client", start.Add(time.Hour))
+	if !result.Allowed || result.Remaining != quota.Limit-1 || result.Reset != time.Second {
+		t.Errorf("after an hour got %+v, want a full bucket less one request", result)
+	}
+}
+
+func testKeysAreSeparate(t *testing.T, store ports.RateLimitStore) {
+	for i := 0; i < quota.Limit; i++ {
+		take(t, store, "read:user:u1", start)
+	}
+	if take(t, store, "read:user:u1", start).Allowed {
+		t.Fatal("want u1 reads denied")
+	}
+	if result := take(t, store, "write:user:u1", start); !result.Allowed || result.Remaining != quota.Limit-1 {
+		t.Errorf("u1 writes got %+v, want their own full bucket", result)
+	}
+	if result := take(t, store, "read:user:u2", start); !result.Allowed || result.Remaining != quota.Limit-1 {
+		t.Errorf("u2 reads got %+v, want their own full bucket", result)
+	}
+}
+
+// Instances sharing a store don't have the same clock, an earlier time must not refill or fail
+func testClockGoingBack(t *testing.T, store ports.RateLimitStore) {
+	for i := 0; i < quota.Limit; i++ {
+		take(t, store, "client", start)
+	}
+	if result := take(t, store, "client", start.Add(-time.Hour)); result.Allowed {
+		t.Fatalf("got %+v, want denied", result)
+	}
+	if result := take(t, store, "client", start.Add(time.Second)); !result.Allowed {
+		t.Errorf("got %+v, want allowed a second after the first requests", result)
+	}
+}
@@ -0,0 +1,44 @@
+package repositories_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories/repotest"
+)
+
+func conformance(repos *repositories.Repositories) repotest.Repositories {
+	return repotest.Repositories{Trades: repos.Trades, Portfolios: repos.Portfolios, Quotes: repos.Quotes, Idempotency: repos.Idempotency, APIKeys: repos.APIKeys, Advisors: repos.Advisors, Accounts: repos.Accounts}
+}
+
+func TestMemoryRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		return conformance(repositories.NewMemoryRepository())
+	})
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		repos, err := repositories.NewSQLiteRepository("sqlite://:memory:", true)
+		if err != nil {
+			t.Fatalf("NewSQLiteRepository: %v", err)
+		}
+		return conformance(repos)
+	})
+}
+
+// Runs against a real database when TEST_DATABASE_URL is set, e.g. the postgres-test service of docker-compose
+func TestPostgresRepository(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	repos, err := repositories.NewpgRepository(url, true)
+	if err != nil {
+		t.Fatalf("NewpgRepository: %v", err)
+	}
+	repotest.Run(t, func(t *testing.T) repotest.Repositories {
+		return conformance(repos)
+	})
+}
@@ -0,0 +1,995 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+// Keeps everything in process memory, writes are all or nothing like the SQL transactions
+type memoryRepository struct {
+	mu            sync.RWMutex
+	nextID        int64
+	trades        map[int64]*domain.Trade
+	deleted       map[int64]*domain.Trade
+	audits        []*domain.TradeAudit
+	portfolios    map[positionKey]*domain.Portfolio
+	lots          map[positionKey][]*domain.Lot
+	settings      map[string]domain.CostBasisMethod
+	quotes        map[domain.QuoteKey]*domain.Quote
+	idempotency   map[idempotencyKey]*domain.IdempotencyRecord
+	apiKeys       []*domain.APIKey
+	clients       []*domain.AdvisorClient
+	users         map[string]*domain.User
+	accounts      map[int64]*domain.Account
+	nextAccountID int64
+}
+
+type idempotencyKey struct {
+	UserID string
+	Key    string
+}
+
+// Creates Repositories backed by memory, nothing survives a restart
+func NewMemoryRepository() *Repositories {
+	repo := &memoryRepository{
+		trades:      make(map[int64]*domain.Trade),
+		deleted:     make(map[int64]*domain.Trade),
+		portfolios:  make(map[positionKey]*domain.Portfolio),
+		lots:        make(map[positionKey][]*domain.Lot),
+		settings:    make(map[string]domain.CostBasisMethod),
+		quotes:      make(map[domain.QuoteKey]*domain.Quote),
+		idempotency: make(map[idempotencyKey]*domain.IdempotencyRecord),
+		users:       make(map[string]*domain.User),
+		accounts:    make(map[int64]*domain.Account),
+	}
+	return &Repositories{Trades: repo, Portfolios: repo, Quotes: repo, Idempotency: repo, APIKeys: repo, Advisors: repo, Accounts: repo}
+}
+
+// Result of replaying one position, applied only once every touched position replayed cleanly
+type replayed struct {
+	key       positionKey
+	portfolio *domain.Portfolio
+	lots      []*domain.Lot
+}
+
+// Adds a new Trade (with all validations)
+func (r *memoryRepository) AddTrade(ctx context.Context, trade *domain.Trade) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opened := make(map[string]*domain.Account)
+	if err := r.resolveAccount(trade, opened); err != nil {
+		return err
+	}
+	if trade.Type == domain.Sell {
+		r.resolveCostBasis(trade)
+	}
+	r.nextID++
+	trade.Id = r.nextID
+	r.trades[trade.Id] = copyTrade(trade)
+
+	result, err := r.replay(tradePosition(trade))
+	if err != nil {
+		delete(r.trades, trade.Id)
+		return err
+	}
+	r.openAccounts(opened)
+	r.apply(result)
+	r.audit(domain.NewTradeAudit(ctx, trade.Id, domain.AuditCreate, nil, copyTrade(trade), utcNow()))
+	return nil
+}
+
+// Updates a existing Trade (with all validations) and returns it as stored
+func (r *memoryRepository) UpdateTrade(ctx context.Context, id int64, updatedTrade *domain.Trade, check func(*domain.Trade) error) (*domain.Trade, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// deleted trades are not found until restored
+	originalTrade, ok := r.trades[id]
+	if !ok {
+		return nil, domain.TradeNotFound(id)
+	}
+
+	stored := mergeTrade(originalTrade, updatedTrade)
+	normalizeTimestamp(stored)
+	if check != nil {
+		if err := check(stored); err != nil {
+			return nil, err
+		}
+	}
+
+	opened := make(map[string]*domain.Account)
+	target := updatedPosition(originalTrade, updatedTrade)
+	if err := r.resolveAccount(target, opened); err != nil {
+		return nil, err
+	}
+	stored.AccountID = target.AccountID
+
+	if stored.Type == domain.Sell && len(updatedTrade.LotIDs) > 0 {
+		stored.CostBasis = domain.SpecificLot
+	}
+	// A sell edited in from a buy picks up the users current method
+	if stored.Type == domain.Sell && stored.CostBasis == "" {
+		r.resolveCostBasis(stored)
+	}
+	r.trades[id] = stored
+
+	// The edit may move the trade to another account or ticker, both positions are rebuilt
+	keys := []positionKey{tradePosition(originalTrade)}
+	if tradePosition(stored) != tradePosition(originalTrade) {
+		keys = append(keys, tradePosition(stored))
+	}
+	var results []*replayed
+	for _, key := range keys {
+		result, err := r.replay(key)
+		if err != nil {
+			r.trades[id] = originalTrade
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	r.openAccounts(opened)
+	r.apply(results...)
+	r.audit(domain.NewTradeAudit(ctx, id, domain.AuditUpdate, copyTrade(originalTrade), copyTrade(stored), utcNow()))
+	return copyTrade(stored), nil
+}
+
+// Removes a Trade (with all validations), it is kept aside so it can be restored
+func (r *memoryRepository) RemoveTrade(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	trade, ok := r.trades[id]
+	if !ok {
+		return domain.TradeNotFound(id)
+	}
+
+	delete(r.trades, id)
+	result, err := r.replay(tradePosition(trade))
+	if err != nil {
+		r.trades[id] = trade
+		return err
+	}
+	r.apply(result)
+
+	now := utcNow()
+	removed := copyTrade(trade)
+	removed.DeletedAt = &now
+	r.deleted[id] = removed
+	r.audit(domain.NewTradeAudit(ctx, id, domain.AuditDelete, copyTrade(trade), nil, now))
+	return nil
+}
+
+// Brings back a deleted trade, rejected like an add when the position can't take it at its point in history
+func (r *memoryRepository) RestoreTrade(ctx context.Context, id int64) (*domain.Trade, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed, ok := r.deleted[id]
+	if !ok {
+		if _, ok := r.trades[id]; ok {
+			return nil, &domain.ConflictError{Message: fmt.Sprintf("trade %d is not deleted", id)}
+		}
+		return nil, domain.TradeNotFound(id)
+	}
+
+	trade := copyTrade(removed)
+	trade.DeletedAt = nil
+	r.trades[id] = trade
+	result, err := r.replay(tradePosition(trade))
+	if err != nil {
+		delete(r.trades, id)
+		return nil, err
+	}
+	r.apply(result)
+
+	delete(r.deleted, id)
+	r.audit(domain.NewTradeAudit(ctx, id, domain.AuditRestore, copyTrade(removed), copyTrade(trade), utcNow()))
+	return copyTrade(trade), nil
+}
+
+// Trade with id, a removed trade is returned with DeletedAt set
+func (r *memoryRepository) FetchTrade(ctx context.Context, id int64) (*domain.Trade, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if trade, ok := r.trades[id]; ok {
+		return copyTrade(trade), nil
+	}
+	if removed, ok := r.deleted[id]; ok {
+		return copyTrade(removed), nil
+	}
+	return nil, domain.TradeNotFound(id)
+}
+
+// Audit entries of a trade, oldest first. Trades stored before auditing began have an empty history.
+func (r *memoryRepository) FetchTradeHistory(ctx context.Context, id int64) ([]*domain.TradeAudit, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := []*domain.TradeAudit{}
+	for _, entry := range r.audits {
+		if entry.TradeID == id {
+			copied := *entry
+			history = append(history, &copied)
+		}
+	}
+	if len(history) == 0 {
+		_, live := r.trades[id]
+		_, deleted := r.deleted[id]
+		if !live && !deleted {
+			return nil, domain.TradeNotFound(id)
+		}
+	}
+	return history, nil
+}
+
+// Adds a batch of trades atomically, dryRun discards them after computing the resulting positions
+func (r *memoryRepository) ImportTrades(ctx context.Context, trades []*domain.Trade, dryRun bool) ([]*domain.Portfolio, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	opened := make(map[string]*domain.Account)
+	importErr := &domain.ImportError{}
+	for i, trade := range trades {
+		if err := r.resolveAccount(trade, opened); err != nil {
+			importErr.Errors = append(importErr.Errors, &domain.RowError{Row: i + 1, Error: err.Error()})
+		}
+	}
+	if len(importErr.Errors) > 0 {
+		return nil, importErr
+	}
+
+	rows := make(map[int64]int, len(trades))
+	keys := []positionKey{}
+	seen := make(map[positionKey]bool)
+	for i, trade := range trades {
+		if trade.Type == domain.Sell {
+			r.resolveCostBasis(trade)
+		}
+		r.nextID++
+		trade.Id = r.nextID
+		r.trades[trade.Id] = copyTrade(trade)
+
+		rows[trade.Id] = i + 1
+		key := tradePosition(trade)
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	discard := func() {
+		for _, trade := range trades {
+			delete(r.trades, trade.Id)
+		}
+	}
+
+	// Replay every touched position so all failing rows are reported at once
+	var results []*replayed
+	for _, key := range keys {
+		result, err := r.replay(key)
+		if err != nil {
+			var tradeErr *domain.TradeError
+			if !errors.As(err, &tradeErr) {
+				discard()
+				return nil, err
+			}
+			// row 0 means an already stored trade broke because of the import
+			importErr.Errors = append(importErr.Errors, &domain.RowError{Row: rows[tradeErr.TradeID], Error: err.Error()})
+			continue
+		}
+		results = append(results, result)
+	}
+	if len(importErr.Errors) > 0 {
+		discard()
+		sort.SliceStable(importErr.Errors, func(i, j int) bool { return importErr.Errors[i].Row < importErr.Errors[j].Row })
+		return nil, importErr
+	}
+
+	portfolio := make([]*domain.Portfolio, 0, len(results))
+	for _, result := range results {
+		position := *result.portfolio
+		portfolio = append(portfolio, &position)
+	}
+	if dryRun {
+		discard()
+		return portfolio, nil
+	}
+	r.openAccounts(opened)
+	r.apply(results...)
+	now := utcNow()
+	for _, trade := range trades {
+		r.audit(domain.NewTradeAudit(ctx, trade.Id, domain.AuditCreate, nil, copyTrade(trade), now))
+	}
+	return portfolio, nil
+}
+
+// Rebuilds every portfolio and its lots from the trades, each position on its own
+func (r *memoryRepository) RebuildPortfolios(ctx context.Context) (*domain.RebuildReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[positionKey]bool)
+	for _, trade := range r.trades {
+		seen[tradePosition(trade)] = true
+	}
+	for key := range r.portfolios {
+		seen[key] = true
+	}
+
+	report := &domain.RebuildReport{Failed: []*domain.RebuildFailure{}}
+	for _, key := range sortedKeys(seen) {
+		result, err := r.replay(key)
+		if err != nil {
+			report.Failed = append(report.Failed, &domain.RebuildFailure{UserID: key.UserID, AccountID: key.AccountID, Ticker: key.Ticker, Error: err.Error()})
+			continue
+		}
+		r.apply(result)
+		report.Rebuilt++
+	}
+	return report, nil
+}
+
+// Fetch a page of trades for a user, ordered by timestamp then id
+func (r *memoryRepository) FetchTrades(ctx context.Context, userID string, filter domain.TradeFilter) (*domain.TradePage, error) {
+	trades := r.matchingTrades(userID, filter)
+	page := &domain.TradePage{Trades: []*domain.Trade{}, Total: int64(len(trades))}
+
+	if filter.Cursor != "" {
+		timestamp, id, err := domain.DecodeTradeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after := trades[:0]
+		for _, trade := range trades {
+			var past bool
+			if filter.Sort == domain.Desc {
+				past = trade.Timestamp.Before(timestamp) || (trade.Timestamp.Equal(timestamp) && trade.Id < id)
+			} else {
+				past = trade.Timestamp.After(timestamp) || (trade.Timestamp.Equal(timestamp) && trade.Id > id)
+			}
+			if past {
+				after = append(after, trade)
+			}
+		}
+		trades = after
+	}
+
+	if len(trades) > filter.Limit {
+		trades = trades[:filter.Limit]
+		page.NextCursor = domain.EncodeTradeCursor(trades[filter.Limit-1])
+	}
+	page.Trades = append(page.Trades, trades...)
+	return page, nil
+}
+
+// Streams trades of a user matching filter, fn runs on a snapshot so it may write back
+func (r *memoryRepository) StreamTrades(ctx context.Context, userID string, filter domain.TradeFilter, fn func(*domain.Trade) error) error {
+	for _, trade := range r.matchingTrades(userID, filter) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(trade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch Portfolio, one row per account and ticker
+func (r *memoryRepository) FetchPortfolio(ctx context.Context, userID string) ([]*domain.Portfolio, error) {
+	return r.positions(func(key positionKey) bool { return key.UserID == userID }), nil
+}
+
+// Fetch the positions of one account
+func (r *memoryRepository) FetchAccountPortfolio(ctx context.Context, accountID int64) ([]*domain.Portfolio, error) {
+	return r.positions(func(key positionKey) bool { return key.AccountID == accountID }), nil
+}
+
+// Fetch all lots of a user, optionally narrowed to one ticker
+func (r *memoryRepository) FetchLots(ctx context.Context, userID, ticker string) ([]*domain.Lot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lots := []*domain.Lot{}
+	for key, positionLots := range r.lots {
+		if key.UserID != userID || (ticker != "" && key.Ticker != ticker) {
+			continue
+		}
+		for _, lot := range positionLots {
+			copied := *lot
+			lots = append(lots, &copied)
+		}
+	}
+	sort.Slice(lots, func(i, j int) bool {
+		a, b := lots[i], lots[j]
+		if a.Ticker != b.Ticker {
+			return a.Ticker < b.Ticker
+		}
+		if !a.AcquiredAt.Equal(b.AcquiredAt) {
+			return a.AcquiredAt.Before(b.AcquiredAt)
+		}
+		return a.ID < b.ID
+	})
+	return lots, nil
+}
+
+// Fetch the cost basis method of a user, DefaultCostBasisMethod when none was set
+func (r *memoryRepository) FetchCostBasisMethod(ctx context.Context, userID string) (domain.CostBasisMethod, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if method, ok := r.settings[userID]; ok && method != "" {
+		return method, nil
+	}
+	return domain.DefaultCostBasisMethod, nil
+}
+
+// Set the cost basis method used for future sells of a user
+func (r *memoryRepository) SetCostBasisMethod(ctx context.Context, userID string, method domain.CostBasisMethod) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.settings[userID] = method
+	return nil
+}
+
+// Fetch distinct tickers with an open position, a ticker held in two currencies is listed for both
+func (r *memoryRepository) FetchQuoteKeys(ctx context.Context) ([]domain.QuoteKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	seen := make(map[domain.QuoteKey]bool)
+	keys := []domain.QuoteKey{}
+	for key, position := range r.portfolios {
+		quoteKey := domain.QuoteKey{Ticker: key.Ticker, Currency: position.Currency}
+		if position.Quantity.IsPositive() && !seen[quoteKey] {
+			seen[quoteKey] = true
+			keys = append(keys, quoteKey)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Ticker != keys[j].Ticker {
+			return keys[i].Ticker < keys[j].Ticker
+		}
+		return keys[i].Currency < keys[j].Currency
+	})
+	return keys, nil
+}
+
+// Save quotes, replacing any previously cached quote for the same ticker and currency
+func (r *memoryRepository) SaveQuotes(ctx context.Context, quotes []*domain.Quote) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, quote := range quotes {
+		copied := *quote
+		r.quotes[quote.Key()] = &copied
+	}
+	return nil
+}
+
+// Fetch cached quotes, keys without a quote are absent from the map
+func (r *memoryRepository) FetchQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[domain.QuoteKey]*domain.Quote, len(keys))
+	for _, key := range keys {
+		if quote, ok := r.quotes[key]; ok {
+			copied := *quote
+			result[key] = &copied
+		}
+	}
+	return result, nil
+}
+
+// Stores record unless its user and key are taken, the record already stored is returned in that case
+func (r *memoryRepository) ReserveIdempotencyKey(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := idempotencyKey{UserID: record.UserID, Key: record.Key}
+	if existing, ok := r.idempotency[key]; ok {
+		copied := *existing
+		return &copied, nil
+	}
+	copied := *record
+	r.idempotency[key] = &copied
+	return nil, nil
+}
+
+// Stores the response of a reserved key
+func (r *memoryRepository) CompleteIdempotencyKey(ctx context.Context, userID, key string, status int, response string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if record, ok := r.idempotency[idempotencyKey{UserID: userID, Key: key}]; ok {
+		record.Status, record.Response = status, response
+	}
+	return nil
+}
+
+// Frees a key so the request can be made again
+func (r *memoryRepository) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.idempotency, idempotencyKey{UserID: userID, Key: key})
+	return nil
+}
+
+// Deletes records created before cutoff
+func (r *memoryRepository) PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	for key, record := range r.idempotency {
+		if record.CreatedAt.Before(cutoff) {
+			delete(r.idempotency, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (r *memoryRepository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.apiKeys {
+		if existing.KeyHash == key.KeyHash {
+			return fmt.Errorf("api key hash already exists")
+		}
+	}
+	key.ID = int64(len(r.apiKeys)) + 1
+	r.apiKeys = append(r.apiKeys, copyAPIKey(key))
+	return nil
+}
+
+// Every key, revoked ones included, oldest first
+func (r *memoryRepository) FetchAPIKeys(ctx context.Context) ([]*domain.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]*domain.APIKey, len(r.apiKeys))
+	for i, key := range r.apiKeys {
+		keys[i] = copyAPIKey(key)
+	}
+	return keys, nil
+}
+
+// Key with the given hash
+func (r *memoryRepository) FetchAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.apiKeys {
+		if key.KeyHash == hash {
+			return copyAPIKey(key), nil
+		}
+	}
+	return nil, &domain.NotFoundError{Resource: "api key"}
+}
+
+// Marks a key revoked, revoking it again keeps the first time
+func (r *memoryRepository) RevokeAPIKey(ctx context.Context, id int64, at time.Time) (*domain.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id < 1 || id > int64(len(r.apiKeys)) {
+		return nil, domain.APIKeyNotFound(id)
+	}
+	key := r.apiKeys[id-1]
+	if key.RevokedAt == nil {
+		revokedAt := at.UTC()
+		key.RevokedAt = &revokedAt
+	}
+	return copyAPIKey(key), nil
+}
+
+// Records that a key was used
+func (r *memoryRepository) TouchAPIKey(ctx context.Context, id int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id >= 1 && id <= int64(len(r.apiKeys)) {
+		lastUsedAt := at.UTC()
+		r.apiKeys[id-1].LastUsedAt = &lastUsedAt
+	}
+	return nil
+}
+
+// Links a client to an advisor, linking them again keeps the first link
+func (r *memoryRepository) LinkClient(ctx context.Context, link *domain.AdvisorClient) (*domain.AdvisorClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.clients {
+		if existing.AdvisorID == link.AdvisorID && existing.ClientID == link.ClientID {
+			copied := *existing
+			return &copied, nil
+		}
+	}
+	stored := *link
+	r.clients = append(r.clients, &stored)
+	copied := stored
+	return &copied, nil
+}
+
+// Removes a link between an advisor and a client
+func (r *memoryRepository) UnlinkClient(ctx context.Context, advisorID, clientID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.clients {
+		if existing.AdvisorID == advisorID && existing.ClientID == clientID {
+			r.clients = append(r.clients[:i], r.clients[i+1:]...)
+			return nil
+		}
+	}
+	return &domain.NotFoundError{Resource: "client", ID: clientID}
+}
+
+// Clients of an advisor in the order they were linked
+func (r *memoryRepository) FetchClients(ctx context.Context, advisorID string) ([]*domain.AdvisorClient, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clients := []*domain.AdvisorClient{}
+	for _, link := range r.clients {
+		if link.AdvisorID == advisorID {
+			copied := *link
+			clients = append(clients, &copied)
+		}
+	}
+	return clients, nil
+}
+
+func (r *memoryRepository) IsClient(ctx context.Context, advisorID, clientID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, link := range r.clients {
+		if link.AdvisorID == advisorID && link.ClientID == clientID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Opens an account, the first account of a user becomes its default
+func (r *memoryRepository) CreateAccount(ctx context.Context, account *domain.Account) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.defaultAccount(account.UserID) == nil {
+		account.Default = true
+	} else if account.Default {
+		r.clearDefaultAccount(account.UserID)
+	}
+	r.nextAccountID++
+	account.ID = r.nextAccountID
+	stored := *account
+	r.openAccounts(map[string]*domain.Account{account.UserID: &stored})
+	return nil
+}
+
+// Account with id
+func (r *memoryRepository) FetchAccount(ctx context.Context, id int64) (*domain.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	account, ok := r.accounts[id]
+	if !ok {
+		return nil, domain.AccountNotFound(id)
+	}
+	copied := *account
+	return &copied, nil
+}
+
+// Accounts of a user, oldest first
+func (r *memoryRepository) FetchAccounts(ctx context.Context, userID string) ([]*domain.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accounts := []*domain.Account{}
+	for _, account := range r.accounts {
+		if account.UserID == userID {
+			copied := *account
+			accounts = append(accounts, &copied)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+// Renames an account or makes it the default of its user
+func (r *memoryRepository) UpdateAccount(ctx context.Context, id int64, update *domain.Account) (*domain.Account, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	account, ok := r.accounts[id]
+	if !ok {
+		return nil, domain.AccountNotFound(id)
+	}
+	if update.Name != "" {
+		account.Name = update.Name
+	}
+	if update.Broker != "" {
+		account.Broker = update.Broker
+	}
+	if update.Default && !account.Default {
+		r.clearDefaultAccount(account.UserID)
+		account.Default = true
+	}
+	copied := *account
+	return &copied, nil
+}
+
+// Deletes an account without trades, removed trades included as they could be restored into it
+func (r *memoryRepository) RemoveAccount(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	account, ok := r.accounts[id]
+	if !ok {
+		return domain.AccountNotFound(id)
+	}
+	for _, trades := range []map[int64]*domain.Trade{r.trades, r.deleted} {
+		for _, trade := range trades {
+			if trade.AccountID == id {
+				return errAccountHasTrades(id)
+			}
+		}
+	}
+
+	delete(r.accounts, id)
+	if !account.Default {
+		return nil
+	}
+	var next *domain.Account
+	for _, other := range r.accounts {
+		if other.UserID == account.UserID && (next == nil || other.ID < next.ID) {
+			next = other
+		}
+	}
+	if next != nil {
+		next.Default = true
+	}
+	return nil
+}
+
+// Copies of the trades of a user matching filter, ordered by filter.Sort
+func (r *memoryRepository) matchingTrades(userID string, filter domain.TradeFilter) []*domain.Trade {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var trades []*domain.Trade
+	for _, trade := range r.trades {
+		if trade.UserID != userID ||
+			(filter.Ticker != "" && trade.Ticker != filter.Ticker) ||
+			(filter.AccountID != 0 && trade.AccountID != filter.AccountID) ||
+			(filter.Type != "" && trade.Type != filter.Type) ||
+			(!filter.From.IsZero() && trade.Timestamp.Before(filter.From)) ||
+			(!filter.To.IsZero() && !trade.Timestamp.Before(filter.To)) {
+			continue
+		}
+		trades = append(trades, copyTrade(trade))
+	}
+	sortTrades(trades, filter.Sort)
+	return trades
+}
+
+// Records the cost basis method a SELL is matched with, explicit lot ids always win
+func (r *memoryRepository) resolveCostBasis(trade *domain.Trade) {
+	if len(trade.LotIDs) > 0 {
+		trade.CostBasis = domain.SpecificLot
+		return
+	}
+	trade.CostBasis = domain.DefaultCostBasisMethod
+	if method, ok := r.settings[trade.UserID]; ok && method != "" {
+		trade.CostBasis = method
+	}
+}
+
+// Checks the account of a trade belongs to its user, a trade without one goes to the default account of its user.
+// Default accounts opened on the way are collected in opened and only stored by openAccounts once the write succeeds.
+func (r *memoryRepository) resolveAccount(trade *domain.Trade, opened map[string]*domain.Account) error {
+	if trade.AccountID == 0 {
+		account := r.defaultAccount(trade.UserID)
+		if account == nil {
+			account = opened[trade.UserID]
+		}
+		if account == nil {
+			r.nextAccountID++
+			account = &domain.Account{ID: r.nextAccountID, UserID: trade.UserID, Name: domain.DefaultAccountName, Default: true, CreatedAt: utcNow()}
+			opened[trade.UserID] = account
+		}
+		trade.AccountID = account.ID
+		return nil
+	}
+
+	account, ok := r.accounts[trade.AccountID]
+	if !ok {
+		return errUnknownAccount(trade.AccountID)
+	}
+	if account.UserID != trade.UserID {
+		return errForeignAccount(trade.AccountID, trade.UserID)
+	}
+	return nil
+}
+
+// Stores accounts along with their users when they are new
+func (r *memoryRepository) openAccounts(opened map[string]*domain.Account) {
+	for _, account := range opened {
+		if _, ok := r.users[account.UserID]; !ok {
+			r.users[account.UserID] = &domain.User{ID: account.UserID, CreatedAt: utcNow()}
+		}
+		r.accounts[account.ID] = account
+	}
+}
+
+func (r *memoryRepository) defaultAccount(userID string) *domain.Account {
+	for _, account := range r.accounts {
+		if account.UserID == userID && account.Default {
+			return account
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) clearDefaultAccount(userID string) {
+	for _, account := range r.accounts {
+		if account.UserID == userID {
+			account.Default = false
+		}
+	}
+}
+
+// Copies of the stored positions matching keep, ordered by ticker then account
+func (r *memoryRepository) positions(keep func(positionKey) bool) []*domain.Portfolio {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	portfolio := []*domain.Portfolio{}
+	for key, position := range r.portfolios {
+		if keep(key) {
+			copied := *position
+			portfolio = append(portfolio, &copied)
+		}
+	}
+	sort.Slice(portfolio, func(i, j int) bool {
+		if portfolio[i].Ticker != portfolio[j].Ticker {
+			return portfolio[i].Ticker < portfolio[j].Ticker
+		}
+		return portfolio[i].AccountID < portfolio[j].AccountID
+	})
+	return portfolio
+}
+
+// Replays the stored trades of a position without touching the stored portfolio or lots
+func (r *memoryRepository) replay(key positionKey) (*replayed, error) {
+	var trades []*domain.Trade
+	for _, trade := range r.trades {
+		if trade.AccountID == key.AccountID && trade.Ticker == key.Ticker {
+			trades = append(trades, copyTrade(trade))
+		}
+	}
+	sortTrades(trades, domain.Asc)
+
+	portfolio, lots, err := domain.Replay(key.UserID, key.AccountID, key.Ticker, trades)
+	if err != nil {
+		return nil, err
+	}
+	if len(trades) == 0 {
+		portfolio = nil
+	}
+	return &replayed{key: key, portfolio: portfolio, lots: lots}, nil
+}
+
+// Stores replayed positions, a position without trades is dropped
+func (r *memoryRepository) apply(results ...*replayed) {
+	for _, result := range results {
+		if result.portfolio == nil {
+			delete(r.portfolios, result.key)
+			delete(r.lots, result.key)
+			continue
+		}
+		r.portfolios[result.key] = result.portfolio
+		r.lots[result.key] = result.lots
+	}
+}
+
+// Appends to the audit log, entries are never changed afterwards
+func (r *memoryRepository) audit(entry *domain.TradeAudit) {
+	entry.ID = int64(len(r.audits) + 1)
+	r.audits = append(r.audits, entry)
+}
+
+// Applies the non-zero fields of update over stored, matching gorm's Updates
+func mergeTrade(stored, update *domain.Trade) *domain.Trade {
+	merged := copyTrade(stored)
+	if update.UserID != "" {
+		merged.UserID = update.UserID
+	}
+	if update.AccountID != 0 {
+		merged.AccountID = update.AccountID
+	}
+	if update.Ticker != "" {
+		merged.Ticker = update.Ticker
+	}
+	if update.Type != "" {
+		merged.Type = update.Type
+	}
+	if !update.Quantity.IsZero() {
+		merged.Quantity = update.Quantity
+	}
+	if !update.Price.IsZero() {
+		merged.Price = update.Price
+	}
+	if update.Currency != "" {
+		merged.Currency = update.Currency
+	}
+	if !update.Timestamp.IsZero() {
+		merged.Timestamp = update.Timestamp
+	}
+	if update.LotIDs != nil {
+		merged.LotIDs = append([]int64(nil), update.LotIDs...)
+	}
+	if update.CostBasis != "" {
+		merged.CostBasis = update.CostBasis
+	}
+	// A sell edited into a buy has no lots to pick from
+	if merged.Type == domain.Buy && update.LotIDs == nil {
+		merged.LotIDs, merged.CostBasis = nil, ""
+	}
+	return merged
+}
+
+func copyTrade(trade *domain.Trade) *domain.Trade {
+	copied := *trade
+	if trade.LotIDs != nil {
+		copied.LotIDs = append([]int64(nil), trade.LotIDs...)
+	}
+	if trade.DeletedAt != nil {
+		deletedAt := *trade.DeletedAt
+		copied.DeletedAt = &deletedAt
+	}
+	return &copied
+}
+
+func copyAPIKey(key *domain.APIKey) *domain.APIKey {
+	copied := *key
+	copied.UserIDs = append([]string(nil), key.UserIDs...)
+	return &copied
+}
+
+func sortTrades(trades []*domain.Trade, direction domain.SortDirection) {
+	sort.Slice(trades, func(i, j int) bool {
+		a, b := trades[i], trades[j]
+		if direction == domain.Desc {
+			a, b = b, a
+		}
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return a.Id < b.Id
+	})
+}
+
+func sortedKeys(keys map[positionKey]bool) []positionKey {
+	sorted := make([]positionKey, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].before(sorted[j]) })
+	return sorted
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS user_settings;
+DROP TABLE IF EXISTS lots;
+DROP TABLE IF EXISTS quotes;
+DROP TABLE IF EXISTS portfolios;
+DROP TABLE IF EXISTS trades;
@@ -0,0 +1,66 @@
+-- Baseline schema. Databases set up by the old AutoMigrate startup already have these tables,
+-- they are brought to the same shape: missing columns added, float and integer amounts converted to NUMERIC.
+
+CREATE TABLE IF NOT EXISTS trades (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT,
+    ticker TEXT,
+    type TEXT,
+    quantity NUMERIC(24,8),
+    price NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    timestamp TIMESTAMPTZ,
+    lot_ids TEXT,
+    cost_basis TEXT
+);
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS currency VARCHAR(3) DEFAULT 'INR';
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS lot_ids TEXT;
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS cost_basis TEXT;
+ALTER TABLE trades
+    ALTER COLUMN quantity TYPE NUMERIC(24,8) USING ROUND(quantity::numeric, 8),
+    ALTER COLUMN price TYPE NUMERIC(24,8) USING ROUND(price::numeric, 8);
+CREATE INDEX IF NOT EXISTS idx_trades_user_id ON trades (user_id);
+
+CREATE TABLE IF NOT EXISTS portfolios (
+    user_id TEXT,
+    ticker TEXT,
+    quantity NUMERIC(24,8),
+    average_buy_price NUMERIC(24,8),
+    realized_pnl NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    last_updated TIMESTAMPTZ
+);
+ALTER TABLE portfolios ADD COLUMN IF NOT EXISTS realized_pnl NUMERIC(24,8);
+ALTER TABLE portfolios ADD COLUMN IF NOT EXISTS currency VARCHAR(3) DEFAULT 'INR';
+ALTER TABLE portfolios
+    ALTER COLUMN quantity TYPE NUMERIC(24,8) USING ROUND(quantity::numeric, 8),
+    ALTER COLUMN average_buy_price TYPE NUMERIC(24,8) USING ROUND(average_buy_price::numeric, 8),
+    ALTER COLUMN realized_pnl TYPE NUMERIC(24,8) USING ROUND(realized_pnl::numeric, 8);
+CREATE INDEX IF NOT EXISTS idx_portfolios_user_id ON portfolios (user_id);
+
+CREATE TABLE IF NOT EXISTS quotes (
+    ticker TEXT PRIMARY KEY,
+    price NUMERIC(24,8),
+    fetched_at TIMESTAMPTZ
+);
+ALTER TABLE quotes ALTER COLUMN price TYPE NUMERIC(24,8) USING ROUND(price::numeric, 8);
+
+CREATE TABLE IF NOT EXISTS lots (
+    id BIGINT PRIMARY KEY,
+    user_id TEXT,
+    ticker TEXT,
+    quantity NUMERIC(24,8),
+    remaining_quantity NUMERIC(24,8),
+    price NUMERIC(24,8),
+    acquired_at TIMESTAMPTZ
+);
+ALTER TABLE lots
+    ALTER COLUMN quantity TYPE NUMERIC(24,8) USING ROUND(quantity::numeric, 8),
+    ALTER COLUMN remaining_quantity TYPE NUMERIC(24,8) USING ROUND(remaining_quantity::numeric, 8),
+    ALTER COLUMN price TYPE NUMERIC(24,8) USING ROUND(price::numeric, 8);
+CREATE INDEX IF NOT EXISTS idx_lots_user_ticker ON lots (user_id, ticker);
+
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id TEXT PRIMARY KEY,
+    cost_basis_method TEXT
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_trades_user_ticker_timestamp;
+CREATE INDEX IF NOT EXISTS idx_portfolios_user_id ON portfolios (user_id);
+DROP INDEX IF EXISTS idx_portfolios_user_ticker;
@@ -0,0 +1,11 @@
+-- One portfolio row per user and ticker. Duplicates left by concurrent first buys are dropped,
+-- run POST /admin/rebuild afterwards so the surviving rows are recomputed from the ledger.
+DELETE FROM portfolios a
+    USING portfolios b
+    WHERE a.user_id = b.user_id AND a.ticker = b.ticker AND a.ctid < b.ctid;
+
+CREATE UNIQUE INDEX idx_portfolios_user_ticker ON portfolios (user_id, ticker);
+-- covered by the unique index
+DROP INDEX IF EXISTS idx_portfolios_user_id;
+
+CREATE INDEX idx_trades_user_ticker_timestamp ON trades (user_id, ticker, timestamp, id);
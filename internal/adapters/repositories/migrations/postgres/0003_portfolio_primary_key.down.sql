@@ -0,0 +1,5 @@
+ALTER TABLE portfolios DROP CONSTRAINT portfolios_pkey;
+ALTER TABLE portfolios
+    ALTER COLUMN user_id DROP NOT NULL,
+    ALTER COLUMN ticker DROP NOT NULL;
+CREATE UNIQUE INDEX idx_portfolios_user_ticker ON portfolios (user_id, ticker);
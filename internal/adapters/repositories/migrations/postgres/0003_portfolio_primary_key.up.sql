@@ -0,0 +1,5 @@
+-- (user_id, ticker) becomes the primary key, writers lock this row while they replay a position
+ALTER TABLE portfolios
+    ALTER COLUMN user_id SET NOT NULL,
+    ALTER COLUMN ticker SET NOT NULL;
+ALTER TABLE portfolios ADD CONSTRAINT portfolios_pkey PRIMARY KEY USING INDEX idx_portfolios_user_ticker;
@@ -0,0 +1,6 @@
+-- Soft deleted trades are dropped for good, the schema had no way to keep them
+DROP TABLE trade_audits;
+DROP FUNCTION trade_audits_append_only();
+DELETE FROM trades WHERE deleted_at IS NOT NULL;
+DROP INDEX idx_trades_deleted_at;
+ALTER TABLE trades DROP COLUMN deleted_at;
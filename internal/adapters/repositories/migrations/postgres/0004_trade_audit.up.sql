@@ -0,0 +1,25 @@
+-- Deletes become soft so a trade can be restored, every change is recorded in an append-only audit log
+ALTER TABLE trades ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX idx_trades_deleted_at ON trades (deleted_at);
+
+CREATE TABLE trade_audits (
+    id BIGSERIAL PRIMARY KEY,
+    trade_id BIGINT NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    before_data TEXT,
+    after_data TEXT,
+    timestamp TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_trade_audits_trade_id ON trade_audits (trade_id);
+
+CREATE FUNCTION trade_audits_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'trade_audits is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trade_audits_append_only
+    BEFORE UPDATE OR DELETE ON trade_audits
+    FOR EACH ROW EXECUTE FUNCTION trade_audits_append_only();
@@ -0,0 +1,11 @@
+-- Responses of requests sent with an Idempotency-Key, status stays 0 while the first request runs
+CREATE TABLE idempotency_records (
+    user_id TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status INTEGER NOT NULL DEFAULT 0,
+    response TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, idempotency_key)
+);
+CREATE INDEX idx_idempotency_records_created_at ON idempotency_records (created_at);
@@ -0,0 +1,14 @@
+-- Long-lived credentials of service clients, only the SHA-256 of each key is stored
+CREATE TABLE api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    user_ids TEXT,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_api_keys_key_hash ON api_keys (key_hash);
@@ -0,0 +1,7 @@
+-- Clients whose portfolios an advisor may read and change
+CREATE TABLE advisor_clients (
+    advisor_id TEXT NOT NULL,
+    client_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (advisor_id, client_id)
+);
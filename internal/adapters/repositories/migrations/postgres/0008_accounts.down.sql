@@ -0,0 +1,22 @@
+-- Positions a user holds in several accounts are added up, POST /admin/rebuild replays them as one
+ALTER TABLE portfolios DROP CONSTRAINT portfolios_pkey;
+CREATE TABLE portfolios_merged AS
+SELECT user_id, ticker, SUM(quantity) AS quantity,
+    CASE WHEN SUM(quantity) > 0 THEN SUM(average_buy_price * quantity) / SUM(quantity) ELSE MAX(average_buy_price) END AS average_buy_price,
+    SUM(realized_pnl) AS realized_pnl, MIN(currency) AS currency, MAX(last_updated) AS last_updated
+FROM portfolios GROUP BY user_id, ticker;
+DELETE FROM portfolios;
+ALTER TABLE portfolios DROP COLUMN account_id;
+INSERT INTO portfolios (user_id, ticker, quantity, average_buy_price, realized_pnl, currency, last_updated)
+SELECT user_id, ticker, quantity, average_buy_price, realized_pnl, currency, last_updated FROM portfolios_merged;
+DROP TABLE portfolios_merged;
+ALTER TABLE portfolios ADD CONSTRAINT portfolios_pkey PRIMARY KEY (user_id, ticker);
+
+DROP INDEX idx_lots_account_ticker;
+ALTER TABLE lots DROP COLUMN account_id;
+
+DROP INDEX idx_trades_account_id;
+ALTER TABLE trades DROP COLUMN account_id;
+
+DROP TABLE accounts;
+DROP TABLE users;
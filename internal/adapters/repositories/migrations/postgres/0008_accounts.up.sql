@@ -0,0 +1,40 @@
+-- Users and their demat or broker accounts, every trade belongs to an account from now on
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE accounts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users (id),
+    name TEXT NOT NULL,
+    broker TEXT NOT NULL DEFAULT '',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_accounts_user_id ON accounts (user_id);
+-- trades sent without an account go to the one default account of their user
+CREATE UNIQUE INDEX idx_accounts_default ON accounts (user_id) WHERE is_default;
+
+-- Every existing user gets a default account holding all of its trades and positions
+INSERT INTO users (id, created_at)
+SELECT user_id, now() FROM (
+    SELECT user_id FROM trades UNION SELECT user_id FROM portfolios UNION SELECT user_id FROM user_settings
+) AS seen WHERE user_id IS NOT NULL;
+INSERT INTO accounts (user_id, name, is_default, created_at)
+SELECT id, 'Default', TRUE, created_at FROM users ORDER BY id;
+
+ALTER TABLE trades ADD COLUMN account_id BIGINT;
+UPDATE trades SET account_id = a.id FROM accounts a WHERE a.user_id = trades.user_id AND a.is_default;
+CREATE INDEX idx_trades_account_id ON trades (account_id);
+
+ALTER TABLE lots ADD COLUMN account_id BIGINT;
+UPDATE lots SET account_id = a.id FROM accounts a WHERE a.user_id = lots.user_id AND a.is_default;
+CREATE INDEX idx_lots_account_ticker ON lots (account_id, ticker);
+
+-- (account_id, ticker) becomes the primary key, writers lock this row while they replay a position
+ALTER TABLE portfolios ADD COLUMN account_id BIGINT;
+UPDATE portfolios SET account_id = a.id FROM accounts a WHERE a.user_id = portfolios.user_id AND a.is_default;
+ALTER TABLE portfolios DROP CONSTRAINT portfolios_pkey;
+ALTER TABLE portfolios ALTER COLUMN account_id SET NOT NULL;
+ALTER TABLE portfolios ADD CONSTRAINT portfolios_pkey PRIMARY KEY (account_id, ticker);
@@ -0,0 +1,2 @@
+-- NUMERIC is exact in Postgres, only SQLite needed its decimal columns changed to text
+SELECT 1;
@@ -0,0 +1,5 @@
+-- Only the INR quote of a ticker is kept, the price refresher fetches the others again
+DELETE FROM quotes WHERE currency <> 'INR';
+ALTER TABLE quotes DROP CONSTRAINT quotes_pkey;
+ALTER TABLE quotes DROP COLUMN currency;
+ALTER TABLE quotes ADD CONSTRAINT quotes_pkey PRIMARY KEY (ticker);
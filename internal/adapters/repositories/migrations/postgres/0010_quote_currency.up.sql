@@ -0,0 +1,4 @@
+-- Quotes are kept per ticker and currency, cached quotes were fetched without one and are taken as INR
+ALTER TABLE quotes ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'INR';
+ALTER TABLE quotes DROP CONSTRAINT quotes_pkey;
+ALTER TABLE quotes ADD CONSTRAINT quotes_pkey PRIMARY KEY (ticker, currency);
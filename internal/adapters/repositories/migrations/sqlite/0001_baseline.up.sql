@@ -0,0 +1,48 @@
+-- Baseline schema, IF NOT EXISTS keeps databases set up by the old AutoMigrate startup as they are
+
+CREATE TABLE IF NOT EXISTS trades (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT,
+    ticker TEXT,
+    type TEXT,
+    quantity NUMERIC(24,8),
+    price NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    timestamp DATETIME,
+    lot_ids TEXT,
+    cost_basis TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_trades_user_id ON trades (user_id);
+
+CREATE TABLE IF NOT EXISTS portfolios (
+    user_id TEXT,
+    ticker TEXT,
+    quantity NUMERIC(24,8),
+    average_buy_price NUMERIC(24,8),
+    realized_pnl NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    last_updated DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_portfolios_user_id ON portfolios (user_id);
+
+CREATE TABLE IF NOT EXISTS quotes (
+    ticker TEXT PRIMARY KEY,
+    price NUMERIC(24,8),
+    fetched_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS lots (
+    id INTEGER PRIMARY KEY,
+    user_id TEXT,
+    ticker TEXT,
+    quantity NUMERIC(24,8),
+    remaining_quantity NUMERIC(24,8),
+    price NUMERIC(24,8),
+    acquired_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_lots_user_ticker ON lots (user_id, ticker);
+
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id TEXT PRIMARY KEY,
+    cost_basis_method TEXT
+);
@@ -0,0 +1,10 @@
+-- One portfolio row per user and ticker. Duplicates are dropped,
+-- run POST /admin/rebuild afterwards so the surviving rows are recomputed from the ledger.
+DELETE FROM portfolios
+    WHERE rowid NOT IN (SELECT MAX(rowid) FROM portfolios GROUP BY user_id, ticker);
+
+CREATE UNIQUE INDEX idx_portfolios_user_ticker ON portfolios (user_id, ticker);
+-- covered by the unique index
+DROP INDEX IF EXISTS idx_portfolios_user_id;
+
+CREATE INDEX idx_trades_user_ticker_timestamp ON trades (user_id, ticker, timestamp, id);
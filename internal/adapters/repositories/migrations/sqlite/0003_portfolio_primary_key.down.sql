@@ -0,0 +1,13 @@
+CREATE TABLE portfolios_old (
+    user_id TEXT,
+    ticker TEXT,
+    quantity NUMERIC(24,8),
+    average_buy_price NUMERIC(24,8),
+    realized_pnl NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    last_updated DATETIME
+);
+INSERT INTO portfolios_old SELECT user_id, ticker, quantity, average_buy_price, realized_pnl, currency, last_updated FROM portfolios;
+DROP TABLE portfolios;
+ALTER TABLE portfolios_old RENAME TO portfolios;
+CREATE UNIQUE INDEX idx_portfolios_user_ticker ON portfolios (user_id, ticker);
@@ -0,0 +1,14 @@
+-- (user_id, ticker) becomes the primary key, SQLite can only add one by rebuilding the table
+CREATE TABLE portfolios_new (
+    user_id TEXT NOT NULL,
+    ticker TEXT NOT NULL,
+    quantity NUMERIC(24,8),
+    average_buy_price NUMERIC(24,8),
+    realized_pnl NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    last_updated DATETIME,
+    PRIMARY KEY (user_id, ticker)
+);
+INSERT INTO portfolios_new SELECT user_id, ticker, quantity, average_buy_price, realized_pnl, currency, last_updated FROM portfolios;
+DROP TABLE portfolios;
+ALTER TABLE portfolios_new RENAME TO portfolios;
@@ -0,0 +1,5 @@
+-- Soft deleted trades are dropped for good, the schema had no way to keep them
+DROP TABLE trade_audits;
+DELETE FROM trades WHERE deleted_at IS NOT NULL;
+DROP INDEX idx_trades_deleted_at;
+ALTER TABLE trades DROP COLUMN deleted_at;
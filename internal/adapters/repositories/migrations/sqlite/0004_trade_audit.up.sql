@@ -0,0 +1,24 @@
+-- Deletes become soft so a trade can be restored, every change is recorded in an append-only audit log
+ALTER TABLE trades ADD COLUMN deleted_at DATETIME;
+CREATE INDEX idx_trades_deleted_at ON trades (deleted_at);
+
+CREATE TABLE trade_audits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    trade_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    before_data TEXT,
+    after_data TEXT,
+    timestamp DATETIME NOT NULL
+);
+CREATE INDEX idx_trade_audits_trade_id ON trade_audits (trade_id);
+
+CREATE TRIGGER trade_audits_no_update BEFORE UPDATE ON trade_audits
+BEGIN
+    SELECT RAISE(ABORT, 'trade_audits is append-only');
+END;
+CREATE TRIGGER trade_audits_no_delete BEFORE DELETE ON trade_audits
+BEGIN
+    SELECT RAISE(ABORT, 'trade_audits is append-only');
+END;
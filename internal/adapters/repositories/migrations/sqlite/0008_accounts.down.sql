@@ -0,0 +1,27 @@
+-- Positions a user holds in several accounts are added up, POST /admin/rebuild replays them as one
+CREATE TABLE portfolios_old (
+    user_id TEXT NOT NULL,
+    ticker TEXT NOT NULL,
+    quantity NUMERIC(24,8),
+    average_buy_price NUMERIC(24,8),
+    realized_pnl NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    last_updated DATETIME,
+    PRIMARY KEY (user_id, ticker)
+);
+INSERT INTO portfolios_old
+SELECT user_id, ticker, SUM(quantity),
+    CASE WHEN SUM(quantity) > 0 THEN SUM(average_buy_price * quantity) / SUM(quantity) ELSE MAX(average_buy_price) END,
+    SUM(realized_pnl), MIN(currency), MAX(last_updated)
+FROM portfolios GROUP BY user_id, ticker;
+DROP TABLE portfolios;
+ALTER TABLE portfolios_old RENAME TO portfolios;
+
+DROP INDEX idx_lots_account_ticker;
+ALTER TABLE lots DROP COLUMN account_id;
+
+DROP INDEX idx_trades_account_id;
+ALTER TABLE trades DROP COLUMN account_id;
+
+DROP TABLE accounts;
+DROP TABLE users;
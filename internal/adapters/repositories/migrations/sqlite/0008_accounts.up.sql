@@ -0,0 +1,52 @@
+-- Users and their demat or broker accounts, every trade belongs to an account from now on
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL REFERENCES users (id),
+    name TEXT NOT NULL,
+    broker TEXT NOT NULL DEFAULT '',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX idx_accounts_user_id ON accounts (user_id);
+-- trades sent without an account go to the one default account of their user
+CREATE UNIQUE INDEX idx_accounts_default ON accounts (user_id) WHERE is_default;
+
+-- Every existing user gets a default account holding all of its trades and positions
+INSERT INTO users (id, created_at)
+SELECT user_id, CURRENT_TIMESTAMP FROM (
+    SELECT user_id FROM trades UNION SELECT user_id FROM portfolios UNION SELECT user_id FROM user_settings
+) AS seen WHERE user_id IS NOT NULL;
+INSERT INTO accounts (user_id, name, is_default, created_at)
+SELECT id, 'Default', TRUE, created_at FROM users ORDER BY id;
+
+ALTER TABLE trades ADD COLUMN account_id INTEGER;
+UPDATE trades SET account_id = (SELECT id FROM accounts WHERE accounts.user_id = trades.user_id AND is_default);
+CREATE INDEX idx_trades_account_id ON trades (account_id);
+
+ALTER TABLE lots ADD COLUMN account_id INTEGER;
+UPDATE lots SET account_id = (SELECT id FROM accounts WHERE accounts.user_id = lots.user_id AND is_default);
+CREATE INDEX idx_lots_account_ticker ON lots (account_id, ticker);
+
+-- (account_id, ticker) becomes the primary key, SQLite can only change it by rebuilding the table
+CREATE TABLE portfolios_new (
+    user_id TEXT NOT NULL,
+    account_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    quantity NUMERIC(24,8),
+    average_buy_price NUMERIC(24,8),
+    realized_pnl NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    last_updated DATETIME,
+    PRIMARY KEY (account_id, ticker)
+);
+INSERT INTO portfolios_new
+SELECT p.user_id, a.id, p.ticker, p.quantity, p.average_buy_price, p.realized_pnl, p.currency, p.last_updated
+FROM portfolios p JOIN accounts a ON a.user_id = p.user_id AND a.is_default;
+DROP TABLE portfolios;
+ALTER TABLE portfolios_new RENAME TO portfolios;
+CREATE INDEX idx_portfolios_user_id ON portfolios (user_id);
@@ -0,0 +1,73 @@
+-- Decimals go back to NUMERIC affinity, values with more than about 15 significant digits are rounded
+CREATE TABLE trades_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT,
+    account_id INTEGER,
+    ticker TEXT,
+    type TEXT,
+    quantity NUMERIC(24,8),
+    price NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    timestamp DATETIME,
+    lot_ids TEXT,
+    cost_basis TEXT,
+    deleted_at DATETIME
+);
+INSERT INTO trades_new
+SELECT id, user_id, account_id, ticker, type, quantity, price, currency, timestamp, lot_ids, cost_basis, deleted_at
+FROM trades;
+-- ids of trades deleted before deletes became soft are never handed out again
+UPDATE sqlite_sequence SET seq = MAX(seq, (SELECT seq FROM sqlite_sequence WHERE name = 'trades')) WHERE name = 'trades_new';
+INSERT INTO sqlite_sequence (name, seq)
+SELECT 'trades_new', seq FROM sqlite_sequence WHERE name = 'trades' AND NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = 'trades_new');
+DROP TABLE trades;
+ALTER TABLE trades_new RENAME TO trades;
+CREATE INDEX idx_trades_user_id ON trades (user_id);
+CREATE INDEX idx_trades_user_ticker_timestamp ON trades (user_id, ticker, timestamp, id);
+CREATE INDEX idx_trades_deleted_at ON trades (deleted_at);
+CREATE INDEX idx_trades_account_id ON trades (account_id);
+
+CREATE TABLE portfolios_new (
+    user_id TEXT NOT NULL,
+    account_id INTEGER NOT NULL,
+    ticker TEXT NOT NULL,
+    quantity NUMERIC(24,8),
+    average_buy_price NUMERIC(24,8),
+    realized_pnl NUMERIC(24,8),
+    currency VARCHAR(3) DEFAULT 'INR',
+    last_updated DATETIME,
+    PRIMARY KEY (account_id, ticker)
+);
+INSERT INTO portfolios_new
+SELECT user_id, account_id, ticker, quantity, average_buy_price, realized_pnl, currency, last_updated
+FROM portfolios;
+DROP TABLE portfolios;
+ALTER TABLE portfolios_new RENAME TO portfolios;
+CREATE INDEX idx_portfolios_user_id ON portfolios (user_id);
+
+CREATE TABLE lots_new (
+    id INTEGER PRIMARY KEY,
+    user_id TEXT,
+    account_id INTEGER,
+    ticker TEXT,
+    quantity NUMERIC(24,8),
+    remaining_quantity NUMERIC(24,8),
+    price NUMERIC(24,8),
+    acquired_at DATETIME
+);
+INSERT INTO lots_new
+SELECT id, user_id, account_id, ticker, quantity, remaining_quantity, price, acquired_at
+FROM lots;
+DROP TABLE lots;
+ALTER TABLE lots_new RENAME TO lots;
+CREATE INDEX idx_lots_user_ticker ON lots (user_id, ticker);
+CREATE INDEX idx_lots_account_ticker ON lots (account_id, ticker);
+
+CREATE TABLE quotes_new (
+    ticker TEXT PRIMARY KEY,
+    price NUMERIC(24,8),
+    fetched_at DATETIME
+);
+INSERT INTO quotes_new SELECT ticker, price, fetched_at FROM quotes;
+DROP TABLE quotes;
+ALTER TABLE quotes_new RENAME TO quotes;
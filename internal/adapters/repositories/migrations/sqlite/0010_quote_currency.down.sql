@@ -0,0 +1,9 @@
+-- Only the INR quote of a ticker is kept, the price refresher fetches the others again
+CREATE TABLE quotes_old (
+    ticker TEXT PRIMARY KEY,
+    price TEXT,
+    fetched_at DATETIME
+);
+INSERT INTO quotes_old (ticker, price, fetched_at) SELECT ticker, price, fetched_at FROM quotes WHERE currency = 'INR';
+DROP TABLE quotes;
+ALTER TABLE quotes_old RENAME TO quotes;
@@ -0,0 +1,11 @@
+-- Quotes are kept per ticker and currency, cached quotes were fetched without one and are taken as INR
+CREATE TABLE quotes_new (
+    ticker TEXT NOT NULL,
+    currency VARCHAR(3) NOT NULL DEFAULT 'INR',
+    price TEXT,
+    fetched_at DATETIME,
+    PRIMARY KEY (ticker, currency)
+);
+INSERT INTO quotes_new (ticker, currency, price, fetched_at) SELECT ticker, 'INR', price, fetched_at FROM quotes;
+DROP TABLE quotes;
+ALTER TABLE quotes_new RENAME TO quotes;
@@ -0,0 +1,2 @@
+-- Keys may expire, existing keys keep working until they are revoked
+ALTER TABLE api_keys ADD COLUMN expires_at DATETIME;
@@ -0,0 +1,281 @@
+package repositories
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// Returned on startup when the database schema does not match the migrations of this build
+var ErrSchemaNotMigrated = errors.New("database schema is not migrated")
+
+// One versioned schema change, Down reverts Up
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Data change SQL can't express, run after Up in the same transaction
+	Run func(tx *gorm.DB) error
+}
+
+// Migrations written in Go, shared by every dialect. They have no SQL and rolling one back only
+// unrecords it, the data they derive stays valid.
+var goMigrations = []*Migration{
+	{Version: 12, Name: "backfill_lots", Run: backfillLots},
+}
+
+// Migration and when it was applied, AppliedAt is nil while pending
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Row of the schema_migrations table
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Applies and rolls back the SQL migrations of one dialect
+type Migrator struct {
+	db         *gorm.DB
+	migrations []*Migration
+}
+
+// Opens the database of driver ("postgres" or "sqlite") for migrating, without checking its schema
+func OpenMigrator(driver, dbUrl string) (*Migrator, error) {
+	var db *gorm.DB
+	var err error
+	switch driver {
+	case "postgres":
+		db, err = openPostgres(dbUrl)
+	case "sqlite":
+		db, err = openSQLite(dbUrl)
+	default:
+		return nil, fmt.Errorf("storage driver %q has no migrations", driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, driver)
+}
+
+func newMigrator(db *gorm.DB, dialect string) (*Migrator, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Reads migrations/<dialect>/NNNN_name.up.sql and the matching .down.sql, ordered by version
+func loadMigrations(dialect string) ([]*Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", dialect, err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		base, direction, ok := cutDirection(entry.Name())
+		if !ok {
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", entry.Name())
+		}
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(number, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s does not start with a version number", entry.Name())
+		}
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	for _, migration := range goMigrations {
+		if _, ok := byVersion[migration.Version]; ok {
+			return nil, fmt.Errorf("migration %d_%s has the version of a SQL migration", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func cutDirection(file string) (string, string, bool) {
+	if base, ok := strings.CutSuffix(file, ".up.sql"); ok {
+		return base, "up", true
+	}
+	if base, ok := strings.CutSuffix(file, ".down.sql"); ok {
+		return base, "down", true
+	}
+	return "", "", false
+}
+
+// Versions recorded in schema_migrations, with the time each was applied
+func (m *Migrator) applied() (map[int64]time.Time, error) {
+	err := m.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name TEXT NOT NULL, applied_at TIMESTAMP NOT NULL)").Error
+	if err != nil {
+		return nil, err
+	}
+	var rows []schemaMigration
+	if err := m.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// Applies every pending migration in order, each in its own transaction
+func (m *Migrator) Up() ([]*Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if migration.Up != "" {
+				if err := tx.Exec(migration.Up).Error; err != nil {
+					return err
+				}
+			}
+			if migration.Run != nil {
+				if err := migration.Run(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Reverts the last steps applied migrations, newest first
+func (m *Migrator) Down(steps int) ([]*Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if migration.Down != "" {
+				if err := tx.Exec(migration.Down).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&schemaMigration{Version: migration.Version}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Every known migration, applied or pending
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if at, ok := applied[migration.Version]; ok {
+			s.AppliedAt = &at
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Fails with ErrSchemaNotMigrated when migrations are pending or the database was migrated by a newer build
+func (m *Migrator) Check() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	known := make(map[int64]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migrations, run `migrate up`", ErrSchemaNotMigrated, pending)
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: migration %d was applied by a newer version", ErrSchemaNotMigrated, version)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) Close() error {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,1316 @@
+// Package repotest is a conformance suite for implementations of the trade and portfolio repositories.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// Repositories under test, Trades and Portfolios must share the same storage
+type Repositories struct {
+	Trades      ports.TradeRepository
+	Portfolios  ports.PortfolioRepository
+	Quotes      ports.QuoteRepository
+	Idempotency ports.IdempotencyRepository
+	APIKeys     ports.APIKeyRepository
+	Advisors    ports.AdvisorRepository
+	Accounts    ports.AccountRepository
+}
+
+// Returns repositories for one test, they may be shared across tests as every test uses its own user ids
+type Factory func(t *testing.T) Repositories
+
+// Runs every conformance test against the repositories returned by newRepos
+func Run(t *testing.T, newRepos Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repos Repositories)
+	}{
+		{"BuysAverageThePrice", testBuysAverageThePrice},
+		{"SellRealizesPnL", testSellRealizesPnL},
+		{"OversellIsRejected", testOversellIsRejected},
+		{"BackdatedSellIsCheckedAtItsPointInHistory", testBackdatedSell},
+		{"UpdateRecomputesPosition", testUpdateRecomputesPosition},
+		{"UpdateMovesTradeToAnotherTicker", testUpdateMovesTrade},
+		{"UpdateBreakingLaterSellIsRejected", testUpdateBreakingSell},
+		{"UpdateCheckSeesMergedTrade", testUpdateCheck},
+		{"RemoveRecomputesPosition", testRemoveRecomputesPosition},
+		{"RemoveBreakingLaterSellIsRejected", testRemoveBreakingSell},
+		{"NotFound", testNotFound},
+		{"RemoveIsSoftAndRestorable", testRemoveIsSoftAndRestorable},
+		{"RestoreBreakingPositionIsRejected", testRestoreBreakingPosition},
+		{"HistoryRecordsEveryChange", testHistoryRecordsEveryChange},
+		{"LotsFollowCostBasisMethod", testLotsFollowCostBasisMethod},
+		{"ImportIsAtomic", testImportIsAtomic},
+		{"ImportDryRunStoresNothing", testImportDryRun},
+		{"TradesArePaginated", testTradesArePaginated},
+		{"StreamingLetsOthersWrite", testStreamingLetsOthersWrite},
+		{"RebuildReplaysLedger", testRebuildReplaysLedger},
+		{"DecimalsKeepEveryDigit", testDecimalsKeepEveryDigit},
+		{"QuotesArePerCurrency", testQuotesPerCurrency},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"ConcurrentFirstBuysShareOnePosition", testConcurrentFirstBuys},
+		{"ConcurrentMixedWritersMatchLedger", testConcurrentMixedWriters},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"APIKeys", testAPIKeys},
+		{"AdvisorClients", testAdvisorClients},
+		{"TradesBelongToAccounts", testTradesBelongToAccounts},
+		{"AccountsKeepOneDefault", testAccountsKeepOneDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepos(t))
+		})
+	}
+}
+
+var (
+	ctx = context.Background()
+	// Whole seconds, stored exactly by every backend
+	base   = time.Date(2024, 1, 2, 9, 15, 0, 0, time.UTC)
+	userID atomic.Int64
+)
+
+// Unique user id so tests sharing a database do not see each other's trades
+func newUser() string {
+	return fmt.Sprintf("repotest-%d-%d", time.Now().UnixNano(), userID.Add(1))
+}
+
+func day(n int) time.Time {
+	return base.AddDate(0, 0, n)
+}
+
+func trade(user, ticker string, tradeType domain.TradeType, quantity, price string, timestamp time.Time) *domain.Trade {
+	return &domain.Trade{
+		UserID:    user,
+		Ticker:    ticker,
+		Type:      tradeType,
+		Quantity:  decimal.RequireFromString(quantity),
+		Price:     decimal.RequireFromString(price),
+		Currency:  domain.DefaultCurrency,
+		Timestamp: timestamp,
+	}
+}
+
+func mustAdd(t *testing.T, repos Repositories, trades ...*domain.Trade) {
+	t.Helper()
+	for _, tr := range trades {
+		if err := repos.Trades.AddTrade(ctx, tr); err != nil {
+			t.Fatalf("AddTrade(%s %s %s): %v", tr.Type, tr.Quantity, tr.Ticker, err)
+		}
+		if tr.Id == 0 {
+			t.Fatalf("AddTrade did not assign an id")
+		}
+	}
+}
+
+// Position of user in ticker, nil when there is none
+func position(t *testing.T, repos Repositories, user, ticker string) *domain.Portfolio {
+	t.Helper()
+	portfolio, err := repos.Portfolios.FetchPortfolio(ctx, user)
+	if err != nil {
+		t.Fatalf("FetchPortfolio: %v", err)
+	}
+	var found *domain.Portfolio
+	for _, p := range portfolio {
+		if p.Ticker != ticker {
+			continue
+		}
+		if found != nil {
+			t.Fatalf("duplicate portfolio rows for %s", ticker)
+		}
+		found = p
+	}
+	return found
+}
+
+func assertPosition(t *testing.T, repos Repositories, user, ticker, quantity, average, realized string) {
+	t.Helper()
+	p := position(t, repos, user, ticker)
+	if p == nil {
+		t.Fatalf("no position in %s", ticker)
+	}
+	assertDecimal(t, "quantity", p.Quantity, quantity)
+	assertDecimal(t, "averageBuyPrice", p.AverageBuyPrice, average)
+	assertDecimal(t, "realizedPnl", p.RealizedPnL, realized)
+}
+
+func assertNoPosition(t *testing.T, repos Repositories, user, ticker string) {
+	t.Helper()
+	if p := position(t, repos, user, ticker); p != nil {
+		t.Fatalf("expected no position in %s, got quantity %s", ticker, p.Quantity)
+	}
+}
+
+func assertDecimal(t *testing.T, field string, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(decimal.RequireFromString(want)) {
+		t.Errorf("%s = %s, want %s", field, got, want)
+	}
+}
+
+func tradeCount(t *testing.T, repos Repositories, user string) int64 {
+	t.Helper()
+	page, err := repos.Trades.FetchTrades(ctx, user, domain.TradeFilter{Limit: domain.MaxPageSize, Sort: domain.Asc})
+	if err != nil {
+		t.Fatalf("FetchTrades: %v", err)
+	}
+	return page.Total
+}
+
+func testBuysAverageThePrice(t *testing.T, repos Repositories) {
+	user := newUser()
+	mustAdd(t, repos,
+		trade(user, "TCS", domain.Buy, "10", "100", day(0)),
+		trade(user, "TCS", domain.Buy, "5", "130", day(1)),
+	)
+	assertPosition(t, repos, user, "TCS", "15", "110", "0")
+
+	mustAdd(t, repos, trade(user, "TCS", domain.Buy, "0.5", "0.3", day(2)))
+	// (1650 + 0.15) / 15.5 rounded to the 6 places INR prices keep
+	assertPosition(t, repos, user, "TCS", "15.5", "106.461290", "0")
+}
+
+func testSellRealizesPnL(t *testing.T, repos Repositories) {
+	user := newUser()
+	mustAdd(t, repos,
+		trade(user, "INFY", domain.Buy, "10", "100", day(0)),
+		trade(user, "INFY", domain.Buy, "10", "120", day(1)),
+		trade(user, "INFY", domain.Sell, "5", "150", day(2)),
+	)
+	assertPosition(t, repos, user, "INFY", "15", "110", "200")
+
+	mustAdd(t, repos, trade(user, "INFY", domain.Sell, "15", "100", day(3)))
+	assertPosition(t, repos, user, "INFY", "0", "110", "50")
+}
+
+func testOversellIsRejected(t *testing.T, repos Repositories) {
+	user := newUser()
+	mustAdd(t, repos, trade(user, "TCS", domain.Buy, "10", "100", day(0)))
+
+	var quantityErr *domain.InsufficientQuantityError
+	if err := repos.Trades.AddTrade(ctx, trade(user, "TCS", domain.Sell, "10.00000001", "100", day(1))); !errors.As(err, &quantityErr) {
+		t.Fatalf("oversell: got %v, want InsufficientQuantityError", err)
+	}
+	if !quantityErr.Held.Equal(decimal.RequireFromString("10")) {
+		t.Errorf("oversell reports holding %s, want 10", quantityErr.Held)
+	}
+	if err := repos.Trades.AddTrade(ctx, trade(user, "WIPRO", domain.Sell, "1", "100", day(1))); !errors.As(err, &quantityErr) {
+		t.Fatalf("sell without a position: got %v, want InsufficientQuantityError", err)
+	}
+
+	assertPosition(t, repos, user, "TCS", "10", "100", "0")
+	assertNoPosition(t, repos, user, "WIPRO")
+	if n := tradeCount(t, repos, user); n != 1 {
+		t.Fatalf("rejected trades were stored, %d trades", n)
+	}
+}
+
+func testBackdatedSell(t *testing.T, repos Repositories) {
+	user := newUser()
+	mustAdd(t, repos, trade(user, "TCS", domain.Buy, "10", "100", day(5)))
+
+	// Enough quantity today, but nothing was held on day 1
+	if err := repos.Trades.AddTrade(ctx, trade(user, "TCS", domain.Sell, "5", "100", day(1))); err == nil {
+		t.Fatalf("sell before the first buy was accepted")
+	}
+	mustAdd(t, repos, trade(user, "TCS", domain.Buy, "5", "80", day(0)))
+	mustAdd(t, repos, trade(user, "TCS", domain.Sell, "5", "100", day(1)))
+	assertPosition(t, repos, user, "TCS", "10", "100", "100")
+}
+
+func testUpdateRecomputesPosition(t *testing.T, repos Repositories) {
+	user := newUser()
+	first := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	sell := trade(user, "TCS", domain.Sell, "4", "150", day(2))
+	mustAdd(t, repos, first, trade(user, "TCS", domain.Buy, "10", "200", day(1)), sell)
+	assertPosition(t, repos, user, "TCS", "16", "150", "0")
+
+	update := &domain.Trade{Price: decimal.RequireFromString("50")}
+	updated, err := repos.Trades.UpdateTrade(ctx, first.Id, update, nil)
+	if err != nil {
+		t.Fatalf("UpdateTrade: %v", err)
+	}
+	// the whole stored trade comes back, not just the fields that were sent
+	if updated.Id != first.Id || updated.UserID != user || updated.Ticker != "TCS" || updated.Type != domain.Buy || !updated.Timestamp.Equal(day(0)) {
+		t.Errorf("UpdateTrade returned %+v, want the stored trade", updated)
+	}
+	assertDecimal(t, "quantity", updated.Quantity, "10")
+	assertDecimal(t, "price", updated.Price, "50")
+	assertPosition(t, repos, user, "TCS", "16", "125", "100")
+
+	// Editing back restores the original position exactly
+	update = &domain.Trade{Price: decimal.RequireFromString("100")}
+	if _, err := repos.Trades.UpdateTrade(ctx, first.Id, update, nil); err != nil {
+		t.Fatalf("UpdateTrade: %v", err)
+	}
+	assertPosition(t, repos, user, "TCS", "16", "150", "0")
+}
+
+func testUpdateMovesTrade(t *testing.T, repos Repositories) {
+	user := newUser()
+	buy := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	mustAdd(t, repos, buy, trade(user, "TCS", domain.Buy, "10", "200", day(1)))
+
+	if _, err := repos.Trades.UpdateTrade(ctx, buy.Id, &domain.Trade{Ticker: "INFY"}, nil); err != nil {
+		t.Fatalf("UpdateTrade: %v", err)
+	}
+	assertPosition(t, repos, user, "TCS", "10", "200", "0")
+	assertPosition(t, repos, user, "INFY", "10", "100", "0")
+}
+
+func testUpdateBreakingSell(t *testing.T, repos Repositories) {
+	user := newUser()
+	buy := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	mustAdd(t, repos, buy, trade(user, "TCS", domain.Sell, "8", "120", day(1)))
+
+	if _, err := repos.Trades.UpdateTrade(ctx, buy.Id, &domain.Trade{Quantity: decimal.RequireFromString("5")}, nil); err == nil {
+		t.Fatalf("update leaving a later sell short was accepted")
+	}
+	if _, err := repos.Trades.UpdateTrade(ctx, buy.Id, &domain.Trade{Timestamp: day(2)}, nil); err == nil {
+		t.Fatalf("update moving the buy after the sell was accepted")
+	}
+	assertPosition(t, repos, user, "TCS", "2", "100", "160")
+
+	page, err := repos.Trades.FetchTrades(ctx, user, domain.TradeFilter{Limit: 10, Sort: domain.Asc})
+	if err != nil {
+		t.Fatalf("FetchTrades: %v", err)
+	}
+	stored := page.Trades[0]
+	assertDecimal(t, "stored quantity", stored.Quantity, "10")
+	if !stored.Timestamp.Equal(day(0)) {
+		t.Errorf("stored timestamp = %s, want %s", stored.Timestamp, day(0))
+	}
+}
+
+// The check gets the stored trade with the update applied, an error from it stores nothing
+func testUpdateCheck(t *testing.T, repos Repositories) {
+	user := newUser()
+	buy := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	mustAdd(t, repos, buy)
+
+	rejected := errors.New("rejected")
+	var checked *domain.Trade
+	_, err := repos.Trades.UpdateTrade(ctx, buy.Id, &domain.Trade{Price: decimal.RequireFromString("120")}, func(merged *domain.Trade) error {
+		checked = merged
+		return rejected
+	})
+	if !errors.Is(err, rejected) {
+		t.Fatalf("UpdateTrade error = %v, want the check error", err)
+	}
+	if checked == nil || checked.Id != buy.Id || checked.UserID != user || checked.Ticker != "TCS" || !checked.Timestamp.Equal(day(0)) {
+		t.Fatalf("check got %+v, want the merged trade", checked)
+	}
+	assertDecimal(t, "checked quantity", checked.Quantity, "10")
+	assertDecimal(t, "checked price", checked.Price, "120")
+	assertPosition(t, repos, user, "TCS", "10", "100", "0")
+
+	page, err := repos.Trades.FetchTrades(ctx, user, domain.TradeFilter{Limit: 10, Sort: domain.Asc})
+	if err != nil {
+		t.Fatalf("FetchTrades: %v", err)
+	}
+	assertDecimal(t, "stored price", page.Trades[0].Price, "100")
+}
+
+func testRemoveRecomputesPosition(t *testing.T, repos Repositories) {
+	user := newUser()
+	first := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	second := trade(user, "TCS", domain.Buy, "10", "200", day(1))
+	sell := trade(user, "TCS", domain.Sell, "5", "180", day(2))
+	mustAdd(t, repos, first, second, sell)
+
+	if err := repos.Trades.RemoveTrade(ctx, sell.Id); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+	assertPosition(t, repos, user, "TCS", "20", "150", "0")
+
+	if err := repos.Trades.RemoveTrade(ctx, first.Id); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+	assertPosition(t, repos, user, "TCS", "10", "200", "0")
+
+	if err := repos.Trades.RemoveTrade(ctx, second.Id); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+	assertNoPosition(t, repos, user, "TCS")
+	if n := tradeCount(t, repos, user); n != 0 {
+		t.Fatalf("%d trades left after removing all", n)
+	}
+}
+
+func testRemoveBreakingSell(t *testing.T, repos Repositories) {
+	user := newUser()
+	buy := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	mustAdd(t, repos, buy, trade(user, "TCS", domain.Sell, "10", "120", day(1)))
+
+	var quantityErr *domain.InsufficientQuantityError
+	if err := repos.Trades.RemoveTrade(ctx, buy.Id); !errors.As(err, &quantityErr) {
+		t.Fatalf("removing the buy a sell depends on: got %v, want InsufficientQuantityError", err)
+	}
+	assertPosition(t, repos, user, "TCS", "0", "100", "200")
+	if n := tradeCount(t, repos, user); n != 2 {
+		t.Fatalf("%d trades stored, want 2", n)
+	}
+}
+
+func testNotFound(t *testing.T, repos Repositories) {
+	const missing = int64(1) << 60
+
+	if _, err := repos.Trades.UpdateTrade(ctx, missing, &domain.Trade{Quantity: decimal.NewFromInt(1)}, nil); !errors.Is(err, domain.ErrTradeNotFound) {
+		t.Errorf("UpdateTrade on a missing id: got %v, want ErrTradeNotFound", err)
+	}
+	if err := repos.Trades.RemoveTrade(ctx, missing); !errors.Is(err, domain.ErrTradeNotFound) {
+		t.Errorf("RemoveTrade on a missing id: got %v, want ErrTradeNotFound", err)
+	}
+	if _, err := repos.Trades.FetchTrade(ctx, missing); !errors.Is(err, domain.ErrTradeNotFound) {
+		t.Errorf("FetchTrade on a missing id: got %v, want ErrTradeNotFound", err)
+	}
+	var notFoundErr *domain.NotFoundError
+	if err := repos.Trades.RemoveTrade(ctx, missing); !errors.As(err, &notFoundErr) || notFoundErr.ID != fmt.Sprint(missing) {
+		t.Errorf("RemoveTrade on a missing id: got %v, want NotFoundError for id %d", err, missing)
+	}
+
+	user := newUser()
+	portfolio, err := repos.Portfolios.FetchPortfolio(ctx, user)
+	if err != nil || len(portfolio) != 0 {
+		t.Errorf("FetchPortfolio of an unknown user: got %d positions, %v", len(portfolio), err)
+	}
+	lots, err := repos.Portfolios.FetchLots(ctx, user, "")
+	if err != nil || len(lots) != 0 {
+		t.Errorf("FetchLots of an unknown user: got %d lots, %v", len(lots), err)
+	}
+	page, err := repos.Trades.FetchTrades(ctx, user, domain.TradeFilter{Limit: 10, Sort: domain.Asc})
+	if err != nil || page.Total != 0 || len(page.Trades) != 0 || page.NextCursor != "" {
+		t.Errorf("FetchTrades of an unknown user: got %+v, %v", page, err)
+	}
+	method, err := repos.Portfolios.FetchCostBasisMethod(ctx, user)
+	if err != nil || method != domain.DefaultCostBasisMethod {
+		t.Errorf("FetchCostBasisMethod of an unknown user: got %q, %v", method, err)
+	}
+}
+
+func testRemoveIsSoftAndRestorable(t *testing.T, repos Repositories) {
+	user := newUser()
+	first, second := trade(user, "TCS", domain.Buy, "10", "100", day(0)), trade(user, "TCS", domain.Buy, "10", "200", day(1))
+	mustAdd(t, repos, first, second)
+
+	if err := repos.Trades.RemoveTrade(ctx, second.Id); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+	assertPosition(t, repos, user, "TCS", "10", "100", "0")
+	if n := tradeCount(t, repos, user); n != 1 {
+		t.Fatalf("%d trades listed after a remove, want 1", n)
+	}
+	if err := repos.Trades.RemoveTrade(ctx, second.Id); !errors.Is(err, domain.ErrTradeNotFound) {
+		t.Errorf("removing a removed trade: got %v, want ErrTradeNotFound", err)
+	}
+	removed, err := repos.Trades.FetchTrade(ctx, second.Id)
+	if err != nil || removed.UserID != user || removed.DeletedAt == nil {
+		t.Errorf("FetchTrade of a removed trade: got %+v, %v, want it with deletedAt set", removed, err)
+	}
+	if _, err := repos.Trades.UpdateTrade(ctx, second.Id, &domain.Trade{Quantity: decimal.NewFromInt(1)}, nil); !errors.Is(err, domain.ErrTradeNotFound) {
+		t.Errorf("updating a removed trade: got %v, want ErrTradeNotFound", err)
+	}
+
+	restored, err := repos.Trades.RestoreTrade(ctx, second.Id)
+	if err != nil {
+		t.Fatalf("RestoreTrade: %v", err)
+	}
+	if fetched, err := repos.Trades.FetchTrade(ctx, second.Id); err != nil || fetched.DeletedAt != nil {
+		t.Errorf("FetchTrade of a restored trade: got %+v, %v, want it without deletedAt", fetched, err)
+	}
+	if restored.Id != second.Id || restored.DeletedAt != nil {
+		t.Errorf("restored trade %d deletedAt %v, want trade %d without deletedAt", restored.Id, restored.DeletedAt, second.Id)
+	}
+	assertPosition(t, repos, user, "TCS", "20", "150", "0")
+	if n := tradeCount(t, repos, user); n != 2 {
+		t.Fatalf("%d trades listed after a restore, want 2", n)
+	}
+	var conflictErr *domain.ConflictError
+	if _, err := repos.Trades.RestoreTrade(ctx, second.Id); !errors.As(err, &conflictErr) {
+		t.Errorf("restoring a live trade: got %v, want ConflictError", err)
+	}
+	if _, err := repos.Trades.RestoreTrade(ctx, int64(1)<<60); !errors.Is(err, domain.ErrTradeNotFound) {
+		t.Errorf("restoring a missing trade: got %v, want ErrTradeNotFound", err)
+	}
+}
+
+func testRestoreBreakingPosition(t *testing.T, repos Repositories) {
+	user := newUser()
+	sell := trade(user, "TCS", domain.Sell, "10", "120", day(1))
+	mustAdd(t, repos, trade(user, "TCS", domain.Buy, "10", "100", day(0)), sell)
+	if err := repos.Trades.RemoveTrade(ctx, sell.Id); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+	mustAdd(t, repos, trade(user, "TCS", domain.Sell, "10", "130", day(2)))
+
+	// both sells together would sell 20 of the 10 held
+	var quantityErr *domain.InsufficientQuantityError
+	if _, err := repos.Trades.RestoreTrade(ctx, sell.Id); !errors.As(err, &quantityErr) {
+		t.Fatalf("restoring an oversell: got %v, want InsufficientQuantityError", err)
+	}
+	assertPosition(t, repos, user, "TCS", "0", "100", "300")
+	if n := tradeCount(t, repos, user); n != 2 {
+		t.Fatalf("%d trades listed after a rejected restore, want 2", n)
+	}
+}
+
+func testHistoryRecordsEveryChange(t *testing.T, repos Repositories) {
+	user := newUser()
+	changeCtx := domain.WithChange(ctx, domain.Change{Actor: "auditor", Reason: "broker statement"})
+	buy := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	if err := repos.Trades.AddTrade(changeCtx, buy); err != nil {
+		t.Fatalf("AddTrade: %v", err)
+	}
+	if _, err := repos.Trades.UpdateTrade(ctx, buy.Id, &domain.Trade{Price: decimal.RequireFromString("110")}, nil); err != nil {
+		t.Fatalf("UpdateTrade: %v", err)
+	}
+	if err := repos.Trades.RemoveTrade(changeCtx, buy.Id); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+	if _, err := repos.Trades.RestoreTrade(changeCtx, buy.Id); err != nil {
+		t.Fatalf("RestoreTrade: %v", err)
+	}
+
+	history, err := repos.Trades.FetchTradeHistory(ctx, buy.Id)
+	if err != nil {
+		t.Fatalf("FetchTradeHistory: %v", err)
+	}
+	want := []domain.AuditAction{domain.AuditCreate, domain.AuditUpdate, domain.AuditDelete, domain.AuditRestore}
+	if len(history) != len(want) {
+		t.Fatalf("%d history entries, want %d", len(history), len(want))
+	}
+	for i, entry := range history {
+		if entry.Action != want[i] || entry.TradeID != buy.Id {
+			t.Errorf("entry %d is %s of trade %d, want %s of trade %d", i, entry.Action, entry.TradeID, want[i], buy.Id)
+		}
+	}
+
+	created, updated, deleted, restored := history[0], history[1], history[2], history[3]
+	if created.Before != nil || created.After == nil || created.Actor != "auditor" || created.Reason != "broker statement" {
+		t.Errorf("create entry: before %v, after %v, actor %q, reason %q", created.Before, created.After, created.Actor, created.Reason)
+	}
+	if updated.Before == nil || updated.After == nil || updated.Actor != domain.AnonymousActor {
+		t.Fatalf("update entry: before %v, after %v, actor %q", updated.Before, updated.After, updated.Actor)
+	}
+	assertDecimal(t, "price before update", updated.Before.Price, "100")
+	assertDecimal(t, "price after update", updated.After.Price, "110")
+	if deleted.Before == nil || deleted.After != nil {
+		t.Errorf("delete entry: before %v, after %v", deleted.Before, deleted.After)
+	}
+	if restored.After == nil || restored.After.DeletedAt != nil {
+		t.Errorf("restore entry: after %v", restored.After)
+	}
+
+	if _, err := repos.Trades.FetchTradeHistory(ctx, int64(1)<<60); !errors.Is(err, domain.ErrTradeNotFound) {
+		t.Errorf("history of a missing trade: got %v, want ErrTradeNotFound", err)
+	}
+}
+
+func testLotsFollowCostBasisMethod(t *testing.T, repos Repositories) {
+	user := newUser()
+	if err := repos.Portfolios.SetCostBasisMethod(ctx, user, domain.HighestCost); err != nil {
+		t.Fatalf("SetCostBasisMethod: %v", err)
+	}
+	cheap := trade(user, "TCS", domain.Buy, "10", "100", day(0))
+	dear := trade(user, "TCS", domain.Buy, "10", "300", day(1))
+	mid := trade(user, "TCS", domain.Buy, "10", "200", day(2))
+	sell := trade(user, "TCS", domain.Sell, "15", "250", day(3))
+	mustAdd(t, repos, cheap, dear, mid, sell)
+	if sell.CostBasis != domain.HighestCost {
+		t.Errorf("sell cost basis = %q, want %q", sell.CostBasis, domain.HighestCost)
+	}
+
+	specific := trade(user, "TCS", domain.Sell, "5", "250", day(4))
+	specific.LotIDs = []int64{cheap.Id}
+	mustAdd(t, repos, specific)
+
+	lots, err := repos.Portfolios.FetchLots(ctx, user, "TCS")
+	if err != nil {
+		t.Fatalf("FetchLots: %v", err)
+	}
+	remaining := map[int64]string{cheap.Id: "5", dear.Id: "0", mid.Id: "5"}
+	if len(lots) != len(remaining) {
+		t.Fatalf("got %d lots, want %d", len(lots), len(remaining))
+	}
+	for i, lot := range lots {
+		if i > 0 && lots[i-1].AcquiredAt.After(lot.AcquiredAt) {
+			t.Errorf("lots are not ordered by acquisition")
+		}
+		assertDecimal(t, fmt.Sprintf("lot %d remaining", lot.ID), lot.RemainingQuantity, remaining[lot.ID])
+	}
+
+	// Switching method later leaves recorded sells alone
+	if err := repos.Portfolios.SetCostBasisMethod(ctx, user, domain.FIFO); err != nil {
+		t.Fatalf("SetCostBasisMethod: %v", err)
+	}
+	if method, _ := repos.Portfolios.FetchCostBasisMethod(ctx, user); method != domain.FIFO {
+		t.Errorf("cost basis method = %q, want FIFO", method)
+	}
+	if _, err := repos.Portfolios.RebuildPortfolios(ctx); err != nil {
+		t.Fatalf("RebuildPortfolios: %v", err)
+	}
+	lots, _ = repos.Portfolios.FetchLots(ctx, user, "TCS")
+	for _, lot := range lots {
+		assertDecimal(t, fmt.Sprintf("lot %d remaining after rebuild", lot.ID), lot.RemainingQuantity, remaining[lot.ID])
+	}
+}
+
+func testImportIsAtomic(t *testing.T, repos Repositories) {
+	user := newUser()
+	mustAdd(t, repos, trade(user, "TCS", domain.Buy, "10", "100", day(0)))
+
+	_, err := repos.Trades.ImportTrades(ctx, []*domain.Trade{
+		trade(user, "INFY", domain.Buy, "5", "100", day(1)),
+		trade(user, "TCS", domain.Sell, "4", "120", day(2)),
+		trade(user, "WIPRO", domain.Sell, "1", "100", day(3)),
+	}, false)
+	var importErr *domain.ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("import with an oversell: got %v, want ImportError", err)
+	}
+	if len(importErr.Errors) != 1 || importErr.Errors[0].Row != 3 {
+		t.Fatalf("row errors = %+v, want one error on row 3", importErr.Errors)
+	}
+	assertPosition(t, repos, user, "TCS", "10", "100", "0")
+	assertNoPosition(t, repos, user, "INFY")
+	if n := tradeCount(t, repos, user); n != 1 {
+		t.Fatalf("rejected import stored trades, %d trades", n)
+	}
+
+	portfolio, err := repos.Trades.ImportTrades(ctx, []*domain.Trade{
+		trade(user, "INFY", domain.Buy, "5", "100", day(1)),
+		trade(user, "TCS", domain.Sell, "4", "120", day(2)),
+	}, false)
+	if err != nil {
+		t.Fatalf("ImportTrades: %v", err)
+	}
+	if len(portfolio) != 2 {
+		t.Fatalf("import returned %d positions, want 2", len(portfolio))
+	}
+	assertPosition(t, repos, user, "TCS", "6", "100", "80")
+	assertPosition(t, repos, user, "INFY", "5", "100", "0")
+}
+
+func testImportDryRun(t *testing.T, repos Repositories) {
+	user := newUser()
+	portfolio, err := repos.Trades.ImportTrades(ctx, []*domain.Trade{
+		trade(user, "TCS", domain.Buy, "10", "100", day(0)),
+		trade(user, "TCS", domain.Sell, "5", "110", day(1)),
+	}, true)
+	if err != nil {
+		t.Fatalf("ImportTrades: %v", err)
+	}
+	if len(portfolio) != 1 {
+		t.Fatalf("dry run returned %d positions, want 1", len(portfolio))
+	}
+	assertDecimal(t, "dry run quantity", portfolio[0].Quantity, "5")
+	assertDecimal(t, "dry run realizedPnl", portfolio[0].RealizedPnL, "50")
+
+	assertNoPosition(t, repos, user, "TCS")
+	if n := tradeCount(t, repos, user); n != 0 {
+		t.Fatalf("dry run stored %d trades", n)
+	}
+}
+
+func testTradesArePaginated(t *testing.T, repos Repositories) {
+	user := newUser()
+	var added []*domain.Trade
+	for i := 0; i < 7; i++ {
+		ticker := "TCS"
+		if i%2 == 1 {
+			ticker = "INFY"
+		}
+		// two trades share each timestamp so the id breaks ties
+		added = append(added, trade(user, ticker, domain.Buy, "1", "100", day(i/2)))
+	}
+	mustAdd(t, repos, added...)
+
+	for _, sort := range []domain.SortDirection{domain.Asc, domain.Desc} {
+		var ids []int64
+		filter := domain.TradeFilter{Limit: 3, Sort: sort}
+		for pages := 0; ; pages++ {
+			if pages > len(added) {
+				t.Fatalf("%s: pagination does not terminate", sort)
+			}
+			page, err := repos.Trades.FetchTrades(ctx, user, filter)
+			if err != nil {
+				t.Fatalf("FetchTrades: %v", err)
+			}
+			if page.Total != int64(len(added)) {
+				t.Errorf("%s: total = %d, want %d", sort, page.Total, len(added))
+			}
+			for _, tr := range page.Trades {
+				ids = append(ids, tr.Id)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			filter.Cursor = page.NextCursor
+		}
+
+		if len(ids) != len(added) {
+			t.Fatalf("%s: paged through %d trades, want %d", sort, len(ids), len(added))
+		}
+		for i := range ids {
+			want := added[i].Id
+			if sort == domain.Desc {
+				want = added[len(added)-1-i].Id
+			}
+			if ids[i] != want {
+				t.Fatalf("%s: trade %d is id %d, want %d", sort, i, ids[i], want)
+			}
+		}
+	}
+
+	page, err := repos.Trades.FetchTrades(ctx, user, domain.TradeFilter{Ticker: "INFY", From: day(1), To: day(3), Limit: 10, Sort: domain.Asc})
+	if err != nil {
+		t.Fatalf("FetchTrades: %v", err)
+	}
+	if page.Total != 2 || len(page.Trades) != 2 {
+		t.Fatalf("filtered page has %d of %d trades, want 2", len(page.Trades), page.Total)
+	}
+
+	if _, err := repos.Trades.FetchTrades(ctx, user, domain.TradeFilter{Cursor: "not a cursor", Limit: 10}); !errors.Is(err, domain.ErrInvalidCursor) {
+		t.Errorf("invalid cursor: got %v, want ErrInvalidCursor", err)
+	}
+
+	var streamed int
+	err = repos.Trades.StreamTrades(ctx, user, domain.TradeFilter{Ticker: "TCS", Sort: domain.Asc}, func(*domain.Trade) error {
+		streamed++
+		return nil
+	})
+	if err != nil || streamed != 4 {
+		t.Errorf("StreamTrades: got %d trades, %v, want 4", streamed, err)
+	}
+}
+
+// A stream longer than any page keeps its order, and a slow reader of it doesn't hold up writers
+func testStreamingLetsOthersWrite(t *testing.T, repos Repositories) {
+	const count = 1234
+	user := newUser()
+	trades := make([]*domain.Trade, count)
+	for i := range trades {
+		trades[i] = trade(user, "TCS", domain.Buy, "1", "100", base.Add(time.Duration(i/3)*time.Minute))
+	}
+	if _, err := repos.Trades.ImportTrades(ctx, trades, false); err != nil {
+		t.Fatalf("ImportTrades: %v", err)
+	}
+
+	for _, sort := range []domain.SortDirection{domain.Asc, domain.Desc} {
+		var streamed []*domain.Trade
+		err := repos.Trades.StreamTrades(ctx, user, domain.TradeFilter{Ticker: "TCS", Sort: sort}, func(tr *domain.Trade) error {
+			if len(streamed) == count/2 {
+				writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				if err := repos.Trades.AddTrade(writeCtx, trade(user, "INFY", domain.Buy, "1", "100", day(0))); err != nil {
+					return fmt.Errorf("writing while streaming: %w", err)
+				}
+			}
+			streamed = append(streamed, tr)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("StreamTrades %s: %v", sort, err)
+		}
+		if len(streamed) != count {
+			t.Fatalf("StreamTrades %s: got %d trades, want %d", sort, len(streamed), count)
+		}
+		for i := 1; i < count; i++ {
+			prev, cur := streamed[i-1], streamed[i]
+			inOrder := prev.Timestamp.Before(cur.Timestamp) || prev.Timestamp.Equal(cur.Timestamp) && prev.Id < cur.Id
+			if sort == domain.Desc {
+				inOrder = cur.Timestamp.Before(prev.Timestamp) || prev.Timestamp.Equal(cur.Timestamp) && cur.Id < prev.Id
+			}
+			if !inOrder {
+				t.Fatalf("StreamTrades %s: trade %d (id %d) out of order after id %d", sort, i, cur.Id, prev.Id)
+			}
+		}
+	}
+}
+
+func testRebuildReplaysLedger(t *testing.T, repos Repositories) {
+	user := newUser()
+	mustAdd(t, repos,
+		trade(user, "TCS", domain.Buy, "10", "100", day(0)),
+		trade(user, "TCS", domain.Sell, "5", "120", day(1)),
+	)
+
+	report, err := repos.Portfolios.RebuildPortfolios(ctx)
+	if err != nil {
+		t.Fatalf("RebuildPortfolios: %v", err)
+	}
+	if report.Rebuilt == 0 {
+		t.Errorf("rebuild reported no positions")
+	}
+	assertPosition(t, repos, user, "TCS", "5", "100", "100")
+
+	keys, err := repos.Portfolios.FetchQuoteKeys(ctx)
+	if err != nil {
+		t.Fatalf("FetchQuoteKeys: %v", err)
+	}
+	var held bool
+	for _, key := range keys {
+		held = held || key == domain.QuoteKey{Ticker: "TCS", Currency: domain.DefaultCurrency}
+	}
+	if !held {
+		t.Errorf("FetchQuoteKeys = %v, missing TCS", keys)
+	}
+}
+
+// 18 and 16 significant digits, more than a float64 holds
+func testDecimalsKeepEveryDigit(t *testing.T, repos Repositories) {
+	const quantity, price = "12345678.12345678", "123456789012.123457"
+	user := newUser()
+	buy := trade(user, "TCS", domain.Buy, quantity, price, day(0))
+	mustAdd(t, repos, buy)
+
+	stored, err := repos.Trades.FetchTrade(ctx, buy.Id)
+	if err != nil {
+		t.Fatalf("FetchTrade: %v", err)
+	}
+	assertDecimal(t, "trade quantity", stored.Quantity, quantity)
+	assertDecimal(t, "trade price", stored.Price, price)
+	assertPosition(t, repos, user, "TCS", quantity, price, "0")
+
+	lots, err := repos.Portfolios.FetchLots(ctx, user, "TCS")
+	if err != nil {
+		t.Fatalf("FetchLots: %v", err)
+	}
+	if len(lots) != 1 {
+		t.Fatalf("got %d lots, want 1", len(lots))
+	}
+	assertDecimal(t, "lot quantity", lots[0].RemainingQuantity, quantity)
+	assertDecimal(t, "lot price", lots[0].Price, price)
+
+	// a position sold down to zero is not held, however its quantity is stored
+	closed := fmt.Sprintf("CLOSED%d", userID.Add(1))
+	mustAdd(t, repos,
+		trade(user, closed, domain.Buy, "0.5", "100", day(0)),
+		trade(user, closed, domain.Sell, "0.5", "100", day(1)),
+	)
+	keys, err := repos.Portfolios.FetchQuoteKeys(ctx)
+	if err != nil {
+		t.Fatalf("FetchQuoteKeys: %v", err)
+	}
+	for _, key := range keys {
+		if key.Ticker == closed {
+			t.Errorf("FetchQuoteKeys lists %s, which has no open position", closed)
+		}
+	}
+}
+
+// A ticker held in two currencies gets a quote in each, one never stands in for the other
+func testQuotesPerCurrency(t *testing.T, repos Repositories) {
+	ticker := fmt.Sprintf("DUAL%d", userID.Add(1))
+	inr := domain.QuoteKey{Ticker: ticker, Currency: domain.DefaultCurrency}
+	usd := domain.QuoteKey{Ticker: ticker, Currency: "USD"}
+
+	buy := trade(newUser(), ticker, domain.Buy, "1", "100", day(0))
+	buy.Currency = usd.Currency
+	mustAdd(t, repos, trade(newUser(), ticker, domain.Buy, "1", "8000", day(0)), buy)
+	keys, err := repos.Portfolios.FetchQuoteKeys(ctx)
+	if err != nil {
+		t.Fatalf("FetchQuoteKeys: %v", err)
+	}
+	held := make(map[domain.QuoteKey]bool)
+	for _, key := range keys {
+		held[key] = true
+	}
+	if !held[inr] || !held[usd] {
+		t.Errorf("FetchQuoteKeys = %v, want %s and %s", keys, inr, usd)
+	}
+
+	at := base.Add(time.Hour)
+	err = repos.Quotes.SaveQuotes(ctx, []*domain.Quote{
+		{Ticker: ticker, Currency: inr.Currency, Price: decimal.RequireFromString("8300"), FetchedAt: at},
+		{Ticker: ticker, Currency: usd.Currency, Price: decimal.RequireFromString("99.5"), FetchedAt: at},
+	})
+	if err != nil {
+		t.Fatalf("SaveQuotes: %v", err)
+	}
+	// saving again replaces the quote of the same currency only
+	err = repos.Quotes.SaveQuotes(ctx, []*domain.Quote{{Ticker: ticker, Currency: usd.Currency, Price: decimal.RequireFromString("101"), FetchedAt: at}})
+	if err != nil {
+		t.Fatalf("SaveQuotes: %v", err)
+	}
+
+	quotes, err := repos.Quotes.FetchQuotes(ctx, []domain.QuoteKey{inr, usd, {Ticker: ticker, Currency: "EUR"}})
+	if err != nil {
+		t.Fatalf("FetchQuotes: %v", err)
+	}
+	if len(quotes) != 2 || quotes[inr] == nil || quotes[usd] == nil {
+		t.Fatalf("FetchQuotes = %v, want the INR and USD quotes only", quotes)
+	}
+	assertDecimal(t, "INR price", quotes[inr].Price, "8300")
+	assertDecimal(t, "USD price", quotes[usd].Price, "101")
+	if quotes[usd].Currency != usd.Currency {
+		t.Errorf("USD quote has currency %s", quotes[usd].Currency)
+	}
+}
+
+func testConcurrentWriters(t *testing.T, repos Repositories) {
+	const writers = 20
+	user := newUser()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repos.Trades.AddTrade(ctx, trade(user, "TCS", domain.Buy, "1", fmt.Sprint(100+i), day(0).Add(time.Duration(i)*time.Second)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent buy: %v", err)
+		}
+	}
+	// average of 100..119
+	assertPosition(t, repos, user, "TCS", "20", "109.5", "0")
+
+	// More sellers than shares, exactly one sell per share may succeed
+	var accepted atomic.Int64
+	for i := 0; i < writers+5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := repos.Trades.AddTrade(ctx, trade(user, "TCS", domain.Sell, "1", "110", day(1))); err == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := accepted.Load(); n != writers {
+		t.Fatalf("%d concurrent sells accepted, want %d", n, writers)
+	}
+	assertPosition(t, repos, user, "TCS", "0", "109.5", "10")
+	if n := tradeCount(t, repos, user); n != 2*writers {
+		t.Fatalf("%d trades stored, want %d", n, 2*writers)
+	}
+}
+
+// Many goroutines open the same position at once, there must be exactly one portfolio row afterwards
+func testConcurrentFirstBuys(t *testing.T, repos Repositories) {
+	const rounds, writers = 10, 16
+
+	for round := 0; round < rounds; round++ {
+		user := newUser()
+		start := make(chan struct{})
+		errs := make(chan error, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				errs <- repos.Trades.AddTrade(ctx, trade(user, "TCS", domain.Buy, "1", "100", day(0).Add(time.Duration(i)*time.Second)))
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("round %d: concurrent first buy: %v", round, err)
+			}
+		}
+		assertPosition(t, repos, user, "TCS", fmt.Sprint(writers), "100", "0")
+	}
+}
+
+// Buys, sells, edits and removals race on one position, the stored position must match a replay of the stored trades
+func testConcurrentMixedWriters(t *testing.T, repos Repositories) {
+	const writers, operations = 8, 25
+	user := newUser()
+	mustAdd(t, repos, trade(user, "TCS", domain.Buy, "20", "100", day(0)))
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed))
+			var own []int64
+			<-start
+			for i := 0; i < operations; i++ {
+				at := day(1).Add(time.Duration(random.Intn(3600)) * time.Second)
+				price := fmt.Sprint(90 + random.Intn(20))
+				// rejected operations are expected, only the end state is checked
+				switch op := random.Intn(4); {
+				case op == 0 || len(own) == 0:
+					tr := trade(user, "TCS", domain.Buy, "1", price, at)
+					if repos.Trades.AddTrade(ctx, tr) == nil {
+						own = append(own, tr.Id)
+					}
+				case op == 1:
+					tr := trade(user, "TCS", domain.Sell, "2", price, at)
+					if repos.Trades.AddTrade(ctx, tr) == nil {
+						own = append(own, tr.Id)
+					}
+				case op == 2:
+					id := own[random.Intn(len(own))]
+					_, _ = repos.Trades.UpdateTrade(ctx, id, &domain.Trade{Price: decimal.RequireFromString(price), Timestamp: at}, nil)
+				default:
+					n := random.Intn(len(own))
+					if repos.Trades.RemoveTrade(ctx, own[n]) == nil {
+						own = append(own[:n], own[n+1:]...)
+					}
+				}
+			}
+		}(int64(w))
+	}
+	close(start)
+	wg.Wait()
+
+	before := position(t, repos, user, "TCS")
+	if before == nil {
+		t.Fatalf("position disappeared")
+	}
+	if before.Quantity.IsNegative() {
+		t.Fatalf("quantity went negative: %s", before.Quantity)
+	}
+	lots, err := repos.Portfolios.FetchLots(ctx, user, "TCS")
+	if err != nil {
+		t.Fatalf("FetchLots: %v", err)
+	}
+	remaining := decimal.Zero
+	for _, lot := range lots {
+		remaining = remaining.Add(lot.RemainingQuantity)
+	}
+	assertDecimal(t, "remaining lot quantity", remaining, before.Quantity.String())
+
+	if _, err := repos.Portfolios.RebuildPortfolios(ctx); err != nil {
+		t.Fatalf("RebuildPortfolios: %v", err)
+	}
+	assertPosition(t, repos, user, "TCS", before.Quantity.String(), before.AverageBuyPrice.String(), before.RealizedPnL.String())
+}
+
+func testIdempotencyKeys(t *testing.T, repos Repositories) {
+	user, other := newUser(), newUser()
+	record := &domain.IdempotencyRecord{UserID: user, Key: "retry-1", RequestHash: "hash", CreatedAt: day(0)}
+
+	if existing, err := repos.Idempotency.ReserveIdempotencyKey(ctx, record); err != nil || existing != nil {
+		t.Fatalf("first reserve: got %v, %v, want the key reserved", existing, err)
+	}
+	existing, err := repos.Idempotency.ReserveIdempotencyKey(ctx, &domain.IdempotencyRecord{UserID: user, Key: "retry-1", RequestHash: "other", CreatedAt: day(1)})
+	if err != nil || existing == nil {
+		t.Fatalf("second reserve: got %v, %v, want the first record", existing, err)
+	}
+	if existing.RequestHash != "hash" || existing.Completed() {
+		t.Errorf("second reserve returned hash %q status %d, want the pending first record", existing.RequestHash, existing.Status)
+	}
+	if existing, err := repos.Idempotency.ReserveIdempotencyKey(ctx, &domain.IdempotencyRecord{UserID: other, Key: "retry-1", RequestHash: "hash", CreatedAt: day(0)}); err != nil || existing != nil {
+		t.Errorf("same key of another user: got %v, %v, want it reserved separately", existing, err)
+	}
+
+	if err := repos.Idempotency.CompleteIdempotencyKey(ctx, user, "retry-1", 201, `{"id":1}`); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	existing, err = repos.Idempotency.ReserveIdempotencyKey(ctx, record)
+	if err != nil || existing == nil || existing.Status != 201 || existing.Response != `{"id":1}` {
+		t.Fatalf("reserve after completing: got %+v, %v, want status 201 with the response", existing, err)
+	}
+
+	if err := repos.Idempotency.ReleaseIdempotencyKey(ctx, user, "retry-1"); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey: %v", err)
+	}
+	if existing, err := repos.Idempotency.ReserveIdempotencyKey(ctx, record); err != nil || existing != nil {
+		t.Fatalf("reserve after releasing: got %v, %v, want the key reserved again", existing, err)
+	}
+
+	// records of this test are dated at day(0), anything newer is left alone
+	purged, err := repos.Idempotency.PurgeIdempotencyKeys(ctx, day(0).Add(time.Second))
+	if err != nil {
+		t.Fatalf("PurgeIdempotencyKeys: %v", err)
+	}
+	if purged < 2 {
+		t.Errorf("purged %d records, want at least 2", purged)
+	}
+	if existing, err := repos.Idempotency.ReserveIdempotencyKey(ctx, record); err != nil || existing != nil {
+		t.Errorf("reserve after purging: got %v, %v, want the key reserved again", existing, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, repos Repositories) {
+	user := newUser()
+	expiresAt := day(30)
+	key := &domain.APIKey{Name: "nightly import", Prefix: "pk_abc", KeyHash: "hash-" + user, Scope: domain.ReadWrite, UserIDs: []string{user, "other"}, CreatedAt: day(0), ExpiresAt: &expiresAt}
+	if err := repos.APIKeys.CreateAPIKey(ctx, key); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if key.ID == 0 {
+		t.Fatalf("CreateAPIKey did not assign an id")
+	}
+	if err := repos.APIKeys.CreateAPIKey(ctx, &domain.APIKey{Name: "copy", Prefix: "pk_abc", KeyHash: key.KeyHash, Scope: domain.ReadOnly, CreatedAt: day(0)}); err == nil {
+		t.Errorf("CreateAPIKey with a taken hash succeeded, want an error")
+	}
+
+	found, err := repos.APIKeys.FetchAPIKeyByHash(ctx, key.KeyHash)
+	if err != nil {
+		t.Fatalf("FetchAPIKeyByHash: %v", err)
+	}
+	if found.ID != key.ID || found.Scope != domain.ReadWrite || len(found.UserIDs) != 2 || found.UserIDs[0] != user || found.LastUsedAt != nil ||
+		found.ExpiresAt == nil || !found.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("FetchAPIKeyByHash: got %+v, want the stored key", found)
+	}
+	var notFoundErr *domain.NotFoundError
+	if _, err := repos.APIKeys.FetchAPIKeyByHash(ctx, "missing-"+user); !errors.As(err, &notFoundErr) {
+		t.Errorf("FetchAPIKeyByHash of an unknown hash: got %v, want NotFoundError", err)
+	}
+
+	if err := repos.APIKeys.TouchAPIKey(ctx, key.ID, day(1)); err != nil {
+		t.Fatalf("TouchAPIKey: %v", err)
+	}
+	revoked, err := repos.APIKeys.RevokeAPIKey(ctx, key.ID, day(2))
+	if err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if revoked.RevokedAt == nil || !revoked.RevokedAt.Equal(day(2)) || revoked.LastUsedAt == nil || !revoked.LastUsedAt.Equal(day(1)) {
+		t.Errorf("revoked key: got revokedAt %v lastUsedAt %v, want %v and %v", revoked.RevokedAt, revoked.LastUsedAt, day(2), day(1))
+	}
+	if again, err := repos.APIKeys.RevokeAPIKey(ctx, key.ID, day(3)); err != nil || !again.RevokedAt.Equal(day(2)) {
+		t.Errorf("revoking again: got %v, %v, want the first revocation time kept", again, err)
+	}
+	if _, err := repos.APIKeys.RevokeAPIKey(ctx, int64(1)<<60, day(3)); !errors.As(err, &notFoundErr) {
+		t.Errorf("revoking a missing key: got %v, want NotFoundError", err)
+	}
+
+	keys, err := repos.APIKeys.FetchAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("FetchAPIKeys: %v", err)
+	}
+	listed := false
+	for _, k := range keys {
+		listed = listed || (k.ID == key.ID && k.RevokedAt != nil)
+	}
+	if !listed {
+		t.Errorf("FetchAPIKeys does not list the revoked key %d", key.ID)
+	}
+}
+
+func testAdvisorClients(t *testing.T, repos Repositories) {
+	advisor, first, second := newUser(), newUser(), newUser()
+	for i, client := range []string{first, second, first} {
+		link, err := repos.Advisors.LinkClient(ctx, &domain.AdvisorClient{AdvisorID: advisor, ClientID: client, CreatedAt: day(i)})
+		if err != nil {
+			t.Fatalf("LinkClient(%s): %v", client, err)
+		}
+		// linking again keeps the first link
+		if client == first && !link.CreatedAt.Equal(day(0)) {
+			t.Errorf("link of %s created at %v, want %v", client, link.CreatedAt, day(0))
+		}
+	}
+
+	clients, err := repos.Advisors.FetchClients(ctx, advisor)
+	if err != nil {
+		t.Fatalf("FetchClients: %v", err)
+	}
+	if len(clients) != 2 || clients[0].ClientID != first || clients[1].ClientID != second {
+		t.Fatalf("FetchClients: got %d clients, want %s and %s", len(clients), first, second)
+	}
+	if linked, err := repos.Advisors.IsClient(ctx, advisor, second); err != nil || !linked {
+		t.Errorf("IsClient of a linked client: got %v, %v", linked, err)
+	}
+	// links go one way
+	if linked, err := repos.Advisors.IsClient(ctx, second, advisor); err != nil || linked {
+		t.Errorf("IsClient with advisor and client swapped: got %v, %v", linked, err)
+	}
+
+	if err := repos.Advisors.UnlinkClient(ctx, advisor, second); err != nil {
+		t.Fatalf("UnlinkClient: %v", err)
+	}
+	if linked, err := repos.Advisors.IsClient(ctx, advisor, second); err != nil || linked {
+		t.Errorf("IsClient after unlinking: got %v, %v", linked, err)
+	}
+	var notFoundErr *domain.NotFoundError
+	if err := repos.Advisors.UnlinkClient(ctx, advisor, second); !errors.As(err, &notFoundErr) {
+		t.Errorf("unlinking twice: got %v, want NotFoundError", err)
+	}
+	if clients, err := repos.Advisors.FetchClients(ctx, newUser()); err != nil || len(clients) != 0 {
+		t.Errorf("FetchClients of an advisor without clients: got %d, %v", len(clients), err)
+	}
+}
+
+func mustCreateAccount(t *testing.T, repos Repositories, user, name string) *domain.Account {
+	t.Helper()
+	account := &domain.Account{UserID: user, Name: name, CreatedAt: base}
+	if err := repos.Accounts.CreateAccount(ctx, account); err != nil {
+		t.Fatalf("CreateAccount(%s): %v", name, err)
+	}
+	if account.ID == 0 {
+		t.Fatalf("CreateAccount did not assign an id")
+	}
+	return account
+}
+
+func inAccount(tr *domain.Trade, account *domain.Account) *domain.Trade {
+	tr.AccountID = account.ID
+	return tr
+}
+
+func testTradesBelongToAccounts(t *testing.T, repos Repositories) {
+	user := newUser()
+	// the first trade of a user opens its default account
+	first := trade(user, "TCS", domain.Buy, "10", "200", day(0))
+	mustAdd(t, repos, first)
+	accounts, err := repos.Accounts.FetchAccounts(ctx, user)
+	if err != nil {
+		t.Fatalf("FetchAccounts: %v", err)
+	}
+	if len(accounts) != 1 || !accounts[0].Default || accounts[0].ID != first.AccountID {
+		t.Fatalf("after the first trade: got %d accounts, want the default account %d", len(accounts), first.AccountID)
+	}
+	main := accounts[0]
+
+	broker := mustCreateAccount(t, repos, user, "Broker")
+	if broker.Default {
+		t.Errorf("second account of a user became the default")
+	}
+	mustAdd(t, repos, inAccount(trade(user, "TCS", domain.Buy, "10", "100", day(1)), broker))
+
+	// lots are matched within one account, the other account's holding does not count
+	var quantityErr *domain.InsufficientQuantityError
+	err = repos.Trades.AddTrade(ctx, inAccount(trade(user, "TCS", domain.Sell, "15", "150", day(2)), broker))
+	if !errors.As(err, &quantityErr) {
+		t.Fatalf("selling more than the account holds: got %v, want InsufficientQuantityError", err)
+	}
+
+	positions, err := repos.Portfolios.FetchPortfolio(ctx, user)
+	if err != nil {
+		t.Fatalf("FetchPortfolio: %v", err)
+	}
+	if len(positions) != 2 || positions[0].AccountID != main.ID || positions[1].AccountID != broker.ID {
+		t.Fatalf("FetchPortfolio: got %d positions, want one in account %d and one in %d", len(positions), main.ID, broker.ID)
+	}
+	held, err := repos.Portfolios.FetchAccountPortfolio(ctx, broker.ID)
+	if err != nil {
+		t.Fatalf("FetchAccountPortfolio: %v", err)
+	}
+	if len(held) != 1 {
+		t.Fatalf("FetchAccountPortfolio: got %d positions, want 1", len(held))
+	}
+	assertDecimal(t, "account quantity", held[0].Quantity, "10")
+	assertDecimal(t, "account averageBuyPrice", held[0].AverageBuyPrice, "100")
+
+	aggregated := domain.AggregatePortfolio(user, positions)
+	if len(aggregated) != 1 {
+		t.Fatalf("AggregatePortfolio: got %d positions, want 1", len(aggregated))
+	}
+	assertDecimal(t, "total quantity", aggregated[0].Quantity, "20")
+	assertDecimal(t, "total averageBuyPrice", aggregated[0].AverageBuyPrice, "150")
+
+	// moving a trade rebuilds both accounts
+	if _, err := repos.Trades.UpdateTrade(ctx, first.Id, &domain.Trade{AccountID: broker.ID}, nil); err != nil {
+		t.Fatalf("UpdateTrade to another account: %v", err)
+	}
+	if held, _ := repos.Portfolios.FetchAccountPortfolio(ctx, main.ID); len(held) != 0 {
+		t.Errorf("account the trade moved out of still has %d positions", len(held))
+	}
+	if held, _ := repos.Portfolios.FetchAccountPortfolio(ctx, broker.ID); len(held) != 1 || !held[0].Quantity.Equal(decimal.NewFromInt(20)) {
+		t.Errorf("account the trade moved into: got %+v, want 20 TCS", held)
+	}
+
+	var validationErr *domain.ValidationError
+	if err := repos.Trades.AddTrade(ctx, inAccount(trade(user, "TCS", domain.Buy, "1", "100", day(3)), &domain.Account{ID: 1 << 40})); !errors.As(err, &validationErr) {
+		t.Errorf("trade in an unknown account: got %v, want ValidationError", err)
+	}
+	other := newUser()
+	if err := repos.Trades.AddTrade(ctx, inAccount(trade(other, "TCS", domain.Buy, "1", "100", day(3)), broker)); !errors.As(err, &validationErr) {
+		t.Errorf("trade in the account of another user: got %v, want ValidationError", err)
+	}
+	if _, err := repos.Trades.UpdateTrade(ctx, first.Id, &domain.Trade{UserID: other, AccountID: broker.ID}, nil); !errors.As(err, &validationErr) {
+		t.Errorf("moving a trade to another user with an account it does not own: got %v, want ValidationError", err)
+	}
+	_, err = repos.Trades.ImportTrades(ctx, []*domain.Trade{
+		trade(other, "INFY", domain.Buy, "1", "100", day(3)),
+		inAccount(trade(other, "INFY", domain.Buy, "1", "100", day(3)), broker),
+	}, false)
+	var importErr *domain.ImportError
+	if !errors.As(err, &importErr) || len(importErr.Errors) != 1 || importErr.Errors[0].Row != 2 {
+		t.Fatalf("import into the account of another user: got %v, want an error on row 2", err)
+	}
+	if accounts, _ := repos.Accounts.FetchAccounts(ctx, other); len(accounts) != 0 {
+		t.Errorf("rejected writes opened %d accounts", len(accounts))
+	}
+
+	var conflictErr *domain.ConflictError
+	if err := repos.Accounts.RemoveAccount(ctx, broker.ID); !errors.As(err, &conflictErr) {
+		t.Errorf("removing an account with trades: got %v, want ConflictError", err)
+	}
+	// removed trades keep the account too, they could be restored into it
+	if err := repos.Trades.RemoveTrade(ctx, first.Id); err != nil {
+		t.Fatalf("RemoveTrade: %v", err)
+	}
+	if err := repos.Accounts.RemoveAccount(ctx, broker.ID); !errors.As(err, &conflictErr) {
+		t.Errorf("removing an account with removed trades: got %v, want ConflictError", err)
+	}
+}
+
+func testAccountsKeepOneDefault(t *testing.T, repos Repositories) {
+	user := newUser()
+	first := mustCreateAccount(t, repos, user, "First")
+	second := mustCreateAccount(t, repos, user, "Second")
+	if !first.Default || second.Default {
+		t.Fatalf("defaults after creating two accounts: %v and %v, want only the first", first.Default, second.Default)
+	}
+
+	updated, err := repos.Accounts.UpdateAccount(ctx, second.ID, &domain.Account{Name: "Renamed", Default: true})
+	if err != nil {
+		t.Fatalf("UpdateAccount: %v", err)
+	}
+	if !updated.Default || updated.Name != "Renamed" {
+		t.Fatalf("UpdateAccount: got %+v, want the renamed default", updated)
+	}
+	if account, err := repos.Accounts.FetchAccount(ctx, first.ID); err != nil || account.Default {
+		t.Fatalf("previous default after moving it: got %+v, %v", account, err)
+	}
+	// trades without an account follow the default
+	tr := trade(user, "TCS", domain.Buy, "1", "100", day(0))
+	mustAdd(t, repos, tr)
+	if tr.AccountID != second.ID {
+		t.Errorf("trade without an account went to %d, want the default %d", tr.AccountID, second.ID)
+	}
+
+	third := mustCreateAccount(t, repos, user, "Third")
+	if _, err := repos.Trades.UpdateTrade(ctx, tr.Id, &domain.Trade{AccountID: third.ID}, nil); err != nil {
+		t.Fatalf("UpdateTrade: %v", err)
+	}
+	if err := repos.Accounts.RemoveAccount(ctx, second.ID); err != nil {
+		t.Fatalf("RemoveAccount of the emptied default: %v", err)
+	}
+	accounts, err := repos.Accounts.FetchAccounts(ctx, user)
+	if err != nil {
+		t.Fatalf("FetchAccounts: %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].ID != first.ID || !accounts[0].Default || accounts[1].Default {
+		t.Fatalf("after removing the default: got %+v, want the oldest account as default", accounts)
+	}
+
+	var notFoundErr *domain.NotFoundError
+	if _, err := repos.Accounts.FetchAccount(ctx, second.ID); !errors.As(err, &notFoundErr) {
+		t.Errorf("FetchAccount of a removed account: got %v, want NotFoundError", err)
+	}
+	if err := repos.Accounts.RemoveAccount(ctx, second.ID); !errors.As(err, &notFoundErr) {
+		t.Errorf("removing twice: got %v, want NotFoundError", err)
+	}
+	if _, err := repos.Accounts.UpdateAccount(ctx, second.ID, &domain.Account{Name: "Gone"}); !errors.As(err, &notFoundErr) {
+		t.Errorf("UpdateAccount of a removed account: got %v, want NotFoundError", err)
+	}
+}
@@ -1,198 +1,999 @@
 package repositories
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
 	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
 )
 
-type pgRepository struct {
+// Trades read per query when streaming
+const streamPageSize = 500
+
+type sqlRepository struct {
 	db *gorm.DB
 }
 
-// Creates and initializes new Repositories
-func NewpgRepository(dbUrl string) (ports.TradeRepository, ports.PortfolioRepository, error) {
-	db, err := gorm.Open(postgres.Open(dbUrl), &gorm.Config{})
+// Set of repositories sharing one database
+type Repositories struct {
+	Trades      ports.TradeRepository
+	Portfolios  ports.PortfolioRepository
+	Quotes      ports.QuoteRepository
+	Idempotency ports.IdempotencyRepository
+	APIKeys     ports.APIKeyRepository
+	Advisors    ports.AdvisorRepository
+	Accounts    ports.AccountRepository
+}
+
+// Creates and initializes new Repositories on Postgres, autoMigrate applies pending migrations first
+func NewpgRepository(dbUrl string, autoMigrate bool) (*Repositories, error) {
+	db, err := openPostgres(dbUrl)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	return newSQLRepository(db, "postgres", autoMigrate)
+}
+
+func openPostgres(dbUrl string) (*gorm.DB, error) {
+	return gorm.Open(postgres.Open(dbUrl), &gorm.Config{NowFunc: utcNow})
+}
 
-	repo := &pgRepository{db: db}
+// Checks the schema is migrated and wraps db, shared by every SQL dialect
+func newSQLRepository(db *gorm.DB, dialect string, autoMigrate bool) (*Repositories, error) {
+	migrator, err := newMigrator(db, dialect)
+	if err != nil {
+		return nil, err
+	}
+	if autoMigrate {
+		if _, err := migrator.Up(); err != nil {
+			return nil, err
+		}
+	}
+	if err := migrator.Check(); err != nil {
+		return nil, err
+	}
 
-	db.AutoMigrate(&domain.Trade{}, &domain.Portfolio{})
+	repo := &sqlRepository{db: db}
 	// at the tables are in same db but created isolated repos for scalablity
-	return repo, repo, nil
+	return &Repositories{Trades: repo, Portfolios: repo, Quotes: repo, Idempotency: repo, APIKeys: repo, Advisors: repo, Accounts: repo}, nil
 }
 
 // Adds a new Trade (with all validations)
-func (r *pgRepository) AddTrade(trade *domain.Trade) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		// Fetch current portfolio item
-		var portfolio domain.Portfolio
-		if err := tx.Where("user_id = ? AND ticker = ?", trade.UserID, trade.Ticker).First(&portfolio).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				portfolio = domain.Portfolio{
-					UserID:          trade.UserID,
-					Ticker:          trade.Ticker,
-					Quantity:        0,
-					AverageBuyPrice: 0,
-				}
-				er := tx.Create(portfolio).Error
-				if er != nil {
-					return er
-				}
-			} else {
+func (r *sqlRepository) AddTrade(ctx context.Context, trade *domain.Trade) error {
+	normalizeTimestamp(trade)
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := resolveAccount(tx, trade); err != nil {
+			return err
+		}
+		if err := lockPositions(tx, tradePosition(trade)); err != nil {
+			return err
+		}
+		if trade.Type == domain.Sell {
+			if err := resolveCostBasis(tx, trade); err != nil {
 				return err
 			}
 		}
 
-		// Update portfolio based on trade type
-		switch trade.Type {
-		case domain.Buy:
-			newQuantity := portfolio.Quantity + trade.Quantity
-			newTotalValue := (portfolio.AverageBuyPrice * float64(portfolio.Quantity)) + (trade.Price * float64(trade.Quantity))
-			portfolio.Quantity = newQuantity
-			if newQuantity > 0 {
-				portfolio.AverageBuyPrice = newTotalValue / float64(newQuantity)
-			} else {
-				portfolio.AverageBuyPrice = 0
+		if err := tx.Create(trade).Error; err != nil {
+			return err
+		}
+		if err := replayPosition(tx, tradePosition(trade)); err != nil {
+			return err
+		}
+		return tx.Create(domain.NewTradeAudit(ctx, trade.Id, domain.AuditCreate, nil, copyTrade(trade), utcNow())).Error
+	})
+}
+
+// Updates a existing Trade (with all validations) and returns it as stored
+func (r *sqlRepository) UpdateTrade(ctx context.Context, id int64, updatedTrade *domain.Trade, check func(*domain.Trade) error) (*domain.Trade, error) {
+	var stored *domain.Trade
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Fetch original trade, locked so concurrent edits of it queue up. A deleted trade is not found until restored
+		var originalTrade domain.Trade
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("deleted_at IS NULL").First(&originalTrade, id).Error; err != nil {
+			return tradeNotFound(id, err)
+		}
+
+		stored = mergeTrade(&originalTrade, updatedTrade)
+		normalizeTimestamp(stored)
+		if check != nil {
+			if err := check(stored); err != nil {
+				return err
 			}
-		case domain.Sell:
-			if portfolio.Quantity < trade.Quantity {
-				return fmt.Errorf("insufficient quantity for sell trade")
+		}
+
+		target := updatedPosition(&originalTrade, updatedTrade)
+		if err := resolveAccount(tx, target); err != nil {
+			return err
+		}
+		stored.AccountID = target.AccountID
+		if err := lockPositions(tx, tradePosition(&originalTrade), tradePosition(target)); err != nil {
+			return err
+		}
+
+		if stored.Type == domain.Sell && len(updatedTrade.LotIDs) > 0 {
+			stored.CostBasis = domain.SpecificLot
+		}
+		// A sell edited in from a buy picks up the users current method
+		if stored.Type == domain.Sell && stored.CostBasis == "" {
+			if err := resolveCostBasis(tx, stored); err != nil {
+				return err
 			}
-			portfolio.Quantity -= trade.Quantity
-			// no change to AverageBuyPrice when selling
+		}
+		if err := tx.Save(stored).Error; err != nil {
+			return err
 		}
 
-		portfolio.LastUpdated = trade.Timestamp
+		// The edit may move the trade to another account or ticker, both positions are rebuilt
+		if err := replayPosition(tx, tradePosition(&originalTrade)); err != nil {
+			return err
+		}
+		if tradePosition(stored) != tradePosition(&originalTrade) {
+			if err := replayPosition(tx, tradePosition(stored)); err != nil {
+				return err
+			}
+		}
+		return tx.Create(domain.NewTradeAudit(ctx, id, domain.AuditUpdate, &originalTrade, copyTrade(stored), utcNow())).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
 
-		// Save or update portfolio
-		if err := tx.Where("user_id = ? AND ticker = ?", trade.UserID, trade.Ticker).Save(&portfolio).Error; err != nil {
+// Removes a Trade (with all validations), the row is kept with deleted_at set so it can be restored
+func (r *sqlRepository) RemoveTrade(ctx context.Context, id int64) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Fetch the trade to be removed, a deleted trade is gone as far as callers are concerned
+		var trade domain.Trade
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("deleted_at IS NULL").First(&trade, id).Error; err != nil {
+			return tradeNotFound(id, err)
+		}
+		if err := lockPositions(tx, tradePosition(&trade)); err != nil {
 			return err
 		}
 
-		// Add trade
-		return tx.Create(trade).Error
+		now := utcNow()
+		if err := tx.Model(&trade).Update("deleted_at", now).Error; err != nil {
+			return err
+		}
+		if err := replayPosition(tx, tradePosition(&trade)); err != nil {
+			return err
+		}
+		trade.DeletedAt = nil
+		return tx.Create(domain.NewTradeAudit(ctx, id, domain.AuditDelete, &trade, nil, now)).Error
 	})
 }
 
-// Updates a existing Trade (with all validations)
-func (r *pgRepository) UpdateTrade(id int64, updatedTrade *domain.Trade) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		// Fetch original trade
-		var originalTrade domain.Trade
-		if err := tx.First(&originalTrade, id).Error; err != nil {
+// Brings back a deleted trade, rejected like an add when the position can't take it at its point in history
+func (r *sqlRepository) RestoreTrade(ctx context.Context, id int64) (*domain.Trade, error) {
+	var trade domain.Trade
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&trade, id).Error; err != nil {
+			return tradeNotFound(id, err)
+		}
+		if trade.DeletedAt == nil {
+			return &domain.ConflictError{Message: fmt.Sprintf("trade %d is not deleted", id)}
+		}
+		if err := lockPositions(tx, tradePosition(&trade)); err != nil {
 			return err
 		}
 
-		// Fetch current portfolio item
-		var portfolio domain.Portfolio
-		if err := tx.Where("user_id = ? AND ticker = ?", originalTrade.UserID, originalTrade.Ticker).First(&portfolio).Error; err != nil {
+		before := copyTrade(&trade)
+		if err := tx.Model(&trade).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		trade.DeletedAt = nil
+		if err := replayPosition(tx, tradePosition(&trade)); err != nil {
 			return err
 		}
+		return tx.Create(domain.NewTradeAudit(ctx, id, domain.AuditRestore, before, copyTrade(&trade), utcNow())).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &trade, nil
+}
 
-		// Revert the effect of the original trade
-		switch originalTrade.Type {
-		case domain.Buy:
-			portfolio.Quantity -= originalTrade.Quantity
-			if portfolio.Quantity > 0 {
-				portfolio.AverageBuyPrice = (portfolio.AverageBuyPrice*float64(portfolio.Quantity+originalTrade.Quantity) - float64(originalTrade.Quantity)*originalTrade.Price) / float64(portfolio.Quantity)
-			} else {
-				portfolio.AverageBuyPrice = 0
-			}
-		case domain.Sell:
-			portfolio.Quantity += originalTrade.Quantity
+// Trade with id, a removed trade is returned with DeletedAt set
+func (r *sqlRepository) FetchTrade(ctx context.Context, id int64) (*domain.Trade, error) {
+	var trade domain.Trade
+	if err := r.db.WithContext(ctx).First(&trade, id).Error; err != nil {
+		return nil, tradeNotFound(id, err)
+	}
+	return &trade, nil
+}
+
+// Audit entries of a trade, oldest first. Trades stored before auditing began have an empty history.
+func (r *sqlRepository) FetchTradeHistory(ctx context.Context, id int64) ([]*domain.TradeAudit, error) {
+	history := []*domain.TradeAudit{}
+	if err := r.db.WithContext(ctx).Where("trade_id = ?", id).Order("id").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		var trade domain.Trade
+		if err := r.db.WithContext(ctx).Select("id").First(&trade, id).Error; err != nil {
+			return nil, tradeNotFound(id, err)
 		}
+	}
+	return history, nil
+}
 
-		// Apply the updated trade
-		switch updatedTrade.Type {
-		case domain.Buy:
-			newQuantity := portfolio.Quantity + updatedTrade.Quantity
-			newTotalValue := (portfolio.AverageBuyPrice * float64(portfolio.Quantity)) + (updatedTrade.Price * float64(updatedTrade.Quantity))
-			portfolio.Quantity = newQuantity
-			if newQuantity > 0 {
-				portfolio.AverageBuyPrice = newTotalValue / float64(newQuantity)
-			} else {
-				portfolio.AverageBuyPrice = 0
+// Rolls back a dry-run import once the resulting portfolio is read
+var errDryRun = errors.New("dry run")
+
+// Adds a batch of trades in one transaction, dryRun rolls back after computing the resulting positions
+func (r *sqlRepository) ImportTrades(ctx context.Context, trades []*domain.Trade, dryRun bool) ([]*domain.Portfolio, error) {
+	var portfolio []*domain.Portfolio
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// rows of the same user and account share one lookup
+		resolved := make(map[accountRef]int64)
+		importErr := &domain.ImportError{}
+		for i, trade := range trades {
+			ref := accountRef{UserID: trade.UserID, AccountID: trade.AccountID}
+			if id, ok := resolved[ref]; ok {
+				trade.AccountID = id
+				continue
 			}
-		case domain.Sell:
-			if portfolio.Quantity < updatedTrade.Quantity {
-				return fmt.Errorf("insufficient quantity for updated sell trade")
+			if err := resolveAccount(tx, trade); err != nil {
+				var validationErr *domain.ValidationError
+				if !errors.As(err, &validationErr) {
+					return err
+				}
+				importErr.Errors = append(importErr.Errors, &domain.RowError{Row: i + 1, Error: err.Error()})
+				continue
 			}
-			portfolio.Quantity -= updatedTrade.Quantity
+			resolved[ref] = trade.AccountID
+		}
+		if len(importErr.Errors) > 0 {
+			return importErr
 		}
 
-		portfolio.LastUpdated = updatedTrade.Timestamp
+		touched := make([]positionKey, 0, len(trades))
+		for _, trade := range trades {
+			touched = append(touched, tradePosition(trade))
+		}
+		if err := lockPositions(tx, touched...); err != nil {
+			return err
+		}
 
-		// Update portfolio
-		if err := tx.Where("user_id = ? AND ticker = ?", originalTrade.UserID, originalTrade.Ticker).Save(&portfolio).Error; err != nil {
+		for _, trade := range trades {
+			normalizeTimestamp(trade)
+			if trade.Type == domain.Sell {
+				if err := resolveCostBasis(tx, trade); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.CreateInBatches(trades, 500).Error; err != nil {
+			return err
+		}
+		now := utcNow()
+		audits := make([]*domain.TradeAudit, len(trades))
+		for i, trade := range trades {
+			audits[i] = domain.NewTradeAudit(ctx, trade.Id, domain.AuditCreate, nil, copyTrade(trade), now)
+		}
+		if err := tx.CreateInBatches(audits, 500).Error; err != nil {
 			return err
 		}
 
-		// Update trade
-		return tx.Model(&domain.Trade{}).Where("id = ?", updatedTrade.Id).Updates(updatedTrade).Error
+		rows := make(map[int64]int, len(trades))
+		keys := []positionKey{}
+		seen := make(map[positionKey]bool)
+		for i, trade := range trades {
+			rows[trade.Id] = i + 1
+			key := tradePosition(trade)
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		// Replay every touched position so all failing rows are reported at once
+		for _, key := range keys {
+			if err := replayPosition(tx, key); err != nil {
+				var tradeErr *domain.TradeError
+				if !errors.As(err, &tradeErr) {
+					return err
+				}
+				// row 0 means an already stored trade broke because of the import
+				importErr.Errors = append(importErr.Errors, &domain.RowError{Row: rows[tradeErr.TradeID], Error: err.Error()})
+				continue
+			}
+
+			var position domain.Portfolio
+			if err := tx.Where("account_id = ? AND ticker = ?", key.AccountID, key.Ticker).First(&position).Error; err != nil {
+				return err
+			}
+			portfolio = append(portfolio, &position)
+		}
+		if len(importErr.Errors) > 0 {
+			sort.SliceStable(importErr.Errors, func(i, j int) bool { return importErr.Errors[i].Row < importErr.Errors[j].Row })
+			return importErr
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
 	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return nil, err
+	}
+	return portfolio, nil
 }
 
-// Removes a Trade (with all validations)
-func (r *pgRepository) RemoveTrade(id int64) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		// Fetch the trade to be removed
-		var trade domain.Trade
-		if err := tx.First(&trade, id).Error; err != nil {
-			return err
+// Rebuilds every portfolio and its lots from the trades, each position in its own transaction
+func (r *sqlRepository) RebuildPortfolios(ctx context.Context) (*domain.RebuildReport, error) {
+	var keys []positionKey
+	err := r.db.WithContext(ctx).Raw("SELECT user_id, account_id, ticker FROM trades WHERE deleted_at IS NULL UNION SELECT user_id, account_id, ticker FROM portfolios").Scan(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := &domain.RebuildReport{Failed: []*domain.RebuildFailure{}}
+	for _, key := range keys {
+		// a cancelled request stops the rebuild instead of failing every remaining position
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := lockPositions(tx, key); err != nil {
+				return err
+			}
+			return replayPosition(tx, key)
+		})
+		if err != nil {
+			report.Failed = append(report.Failed, &domain.RebuildFailure{UserID: key.UserID, AccountID: key.AccountID, Ticker: key.Ticker, Error: err.Error()})
+			continue
 		}
+		report.Rebuilt++
+	}
+	return report, nil
+}
 
-		// Fetch current portfolio item
-		var portfolio domain.Portfolio
-		if err := tx.Where("user_id = ? AND ticker = ?", trade.UserID, trade.Ticker).First(&portfolio).Error; err != nil {
+// Fetch a page of trades for a user, ordered by timestamp then id
+func (r *sqlRepository) FetchTrades(ctx context.Context, userID string, filter domain.TradeFilter) (*domain.TradePage, error) {
+	page := &domain.TradePage{Trades: []*domain.Trade{}}
+	if err := filterTrades(r.db.WithContext(ctx).Model(&domain.Trade{}), userID, filter).Count(&page.Total).Error; err != nil {
+		return nil, err
+	}
+
+	query := filterTrades(r.db.WithContext(ctx), userID, filter)
+	if filter.Cursor != "" {
+		timestamp, id, err := domain.DecodeTradeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = afterTrade(query, timestamp, id, filter.Sort)
+	}
+
+	// One extra row tells whether another page follows
+	if err := orderTrades(query, filter.Sort).Limit(filter.Limit + 1).Find(&page.Trades).Error; err != nil {
+		return nil, err
+	}
+	if len(page.Trades) > filter.Limit {
+		page.Trades = page.Trades[:filter.Limit]
+		page.NextCursor = domain.EncodeTradeCursor(page.Trades[filter.Limit-1])
+	}
+	return page, nil
+}
+
+// Streams trades of a user matching filter a page at a time. Every page is its own query, so no connection
+// is held while fn writes to a slow client, SQLite only has the one. Trades changed while streaming may be
+// left out or show up as they were when their page was read.
+func (r *sqlRepository) StreamTrades(ctx context.Context, userID string, filter domain.TradeFilter, fn func(*domain.Trade) error) error {
+	var last *domain.Trade
+	for {
+		query := filterTrades(r.db.WithContext(ctx), userID, filter)
+		if last != nil {
+			query = afterTrade(query, last.Timestamp.UTC(), last.Id, filter.Sort)
+		}
+		var trades []*domain.Trade
+		if err := orderTrades(query, filter.Sort).Limit(streamPageSize).Find(&trades).Error; err != nil {
 			return err
 		}
+		for _, trade := range trades {
+			if err := fn(trade); err != nil {
+				return err
+			}
+		}
+		if len(trades) < streamPageSize {
+			return nil
+		}
+		last = trades[len(trades)-1]
+	}
+}
 
-		// Revert the effect of the trade
-		switch trade.Type {
-		case domain.Buy:
-			portfolio.Quantity -= trade.Quantity
-			if portfolio.Quantity > 0 {
-				portfolio.AverageBuyPrice = (portfolio.AverageBuyPrice*float64(portfolio.Quantity+trade.Quantity) - float64(trade.Quantity)*trade.Price) / float64(portfolio.Quantity)
-			} else {
-				portfolio.AverageBuyPrice = 0
+// Fetch Portfolio, one row per account and ticker
+func (r *sqlRepository) FetchPortfolio(ctx context.Context, userID string) ([]*domain.Portfolio, error) {
+	var portfolio []*domain.Portfolio
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("ticker, account_id").Find(&portfolio).Error
+	return portfolio, err
+}
+
+// Fetch the positions of one account
+func (r *sqlRepository) FetchAccountPortfolio(ctx context.Context, accountID int64) ([]*domain.Portfolio, error) {
+	var portfolio []*domain.Portfolio
+	err := r.db.WithContext(ctx).Where("account_id = ?", accountID).Order("ticker").Find(&portfolio).Error
+	return portfolio, err
+}
+
+// Fetch all lots of a user, optionally narrowed to one ticker
+func (r *sqlRepository) FetchLots(ctx context.Context, userID, ticker string) ([]*domain.Lot, error) {
+	var lots []*domain.Lot
+	query := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if ticker != "" {
+		query = query.Where("ticker = ?", ticker)
+	}
+	err := query.Order("ticker, acquired_at, id").Find(&lots).Error
+	return lots, err
+}
+
+// Fetch the cost basis method of a user, DefaultCostBasisMethod when none was set
+func (r *sqlRepository) FetchCostBasisMethod(ctx context.Context, userID string) (domain.CostBasisMethod, error) {
+	return costBasisMethod(r.db.WithContext(ctx), userID)
+}
+
+// Set the cost basis method used for future sells of a user
+func (r *sqlRepository) SetCostBasisMethod(ctx context.Context, userID string, method domain.CostBasisMethod) error {
+	settings := &domain.UserSettings{UserID: userID, CostBasisMethod: method}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(settings).Error
+}
+
+// Fetch distinct tickers with an open position, a ticker held in two currencies is listed for both.
+// SQLite stores decimals as text so they are compared as numbers.
+func (r *sqlRepository) FetchQuoteKeys(ctx context.Context) ([]domain.QuoteKey, error) {
+	keys := []domain.QuoteKey{}
+	err := r.db.WithContext(ctx).Model(&domain.Portfolio{}).Where("CAST(quantity AS NUMERIC) > 0").
+		Distinct("ticker", "currency").Order("ticker").Order("currency").Find(&keys).Error
+	return keys, err
+}
+
+// Save quotes, replacing any previously cached quote for the same ticker and currency
+func (r *sqlRepository) SaveQuotes(ctx context.Context, quotes []*domain.Quote) error {
+	if len(quotes) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(quotes).Error
+}
+
+// Fetch cached quotes, keys without a quote are absent from the map
+func (r *sqlRepository) FetchQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error) {
+	result := make(map[domain.QuoteKey]*domain.Quote, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+	requested := make(map[domain.QuoteKey]bool, len(keys))
+	tickers := make([]string, 0, len(keys))
+	for _, key := range keys {
+		requested[key] = true
+		tickers = append(tickers, key.Ticker)
+	}
+
+	var quotes []*domain.Quote
+	if err := r.db.WithContext(ctx).Where("ticker IN ?", tickers).Find(&quotes).Error; err != nil {
+		return nil, err
+	}
+	for _, quote := range quotes {
+		if requested[quote.Key()] {
+			result[quote.Key()] = quote
+		}
+	}
+	return result, nil
+}
+
+// Stores record unless its user and key are taken, the record already stored is returned in that case
+func (r *sqlRepository) ReserveIdempotencyKey(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return nil, result.Error
+	}
+	var existing domain.IdempotencyRecord
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND idempotency_key = ?", record.UserID, record.Key).Take(&existing).Error; err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// Stores the response of a reserved key
+func (r *sqlRepository) CompleteIdempotencyKey(ctx context.Context, userID, key string, status int, response string) error {
+	return r.db.WithContext(ctx).Model(&domain.IdempotencyRecord{}).
+		Where("user_id = ? AND idempotency_key = ?", userID, key).
+		Updates(map[string]any{"status": status, "response": response}).Error
+}
+
+// Frees a key so the request can be made again
+func (r *sqlRepository) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	return r.db.WithContext(ctx).Where("user_id = ? AND idempotency_key = ?", userID, key).Delete(&domain.IdempotencyRecord{}).Error
+}
+
+// Deletes records created before cutoff
+func (r *sqlRepository) PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("created_at < ?", cutoff.UTC()).Delete(&domain.IdempotencyRecord{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *sqlRepository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+// Every key, revoked ones included, oldest first
+func (r *sqlRepository) FetchAPIKeys(ctx context.Context) ([]*domain.APIKey, error) {
+	keys := []*domain.APIKey{}
+	err := r.db.WithContext(ctx).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// Key with the given hash
+func (r *sqlRepository) FetchAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	var key domain.APIKey
+	if err := r.db.WithContext(ctx).Where("key_hash = ?", hash).Take(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &domain.NotFoundError{Resource: "api key"}
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Marks a key revoked, revoking it again keeps the first time
+func (r *sqlRepository) RevokeAPIKey(ctx context.Context, id int64, at time.Time) (*domain.APIKey, error) {
+	var key domain.APIKey
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", at.UTC()).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&key, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return domain.APIKeyNotFound(id)
 			}
-		case domain.Sell:
-			portfolio.Quantity += trade.Quantity
+			return err
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Records that a key was used
+func (r *sqlRepository) TouchAPIKey(ctx context.Context, id int64, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&domain.APIKey{}).Where("id = ?", id).Update("last_used_at", at.UTC()).Error
+}
+
+// Links a client to an advisor, linking them again keeps the first link
+func (r *sqlRepository) LinkClient(ctx context.Context, link *domain.AdvisorClient) (*domain.AdvisorClient, error) {
+	db := r.db.WithContext(ctx)
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(link).Error; err != nil {
+		return nil, err
+	}
+	var stored domain.AdvisorClient
+	if err := db.Where("advisor_id = ? AND client_id = ?", link.AdvisorID, link.ClientID).Take(&stored).Error; err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Removes a link between an advisor and a client
+func (r *sqlRepository) UnlinkClient(ctx context.Context, advisorID, clientID string) error {
+	result := r.db.WithContext(ctx).Where("advisor_id = ? AND client_id = ?", advisorID, clientID).Delete(&domain.AdvisorClient{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &domain.NotFoundError{Resource: "client", ID: clientID}
+	}
+	return nil
+}
+
+// Clients of an advisor in the order they were linked
+func (r *sqlRepository) FetchClients(ctx context.Context, advisorID string) ([]*domain.AdvisorClient, error) {
+	clients := []*domain.AdvisorClient{}
+	err := r.db.WithContext(ctx).Where("advisor_id = ?", advisorID).Order("created_at, client_id").Find(&clients).Error
+	return clients, err
+}
+
+func (r *sqlRepository) IsClient(ctx context.Context, advisorID, clientID string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&domain.AdvisorClient{}).Where("advisor_id = ? AND client_id = ?", advisorID, clientID).Count(&count).Error
+	return count > 0, err
+}
 
-		if portfolio.Quantity < 0 {
-			return fmt.Errorf("removing this trade would result in negative quantity")
+// Opens an account, the first account of a user becomes its default
+func (r *sqlRepository) CreateAccount(ctx context.Context, account *domain.Account) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockUser(tx, account.UserID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&domain.Account{}).Where("user_id = ? AND is_default", account.UserID).Count(&count).Error; err != nil {
+			return err
 		}
+		if count == 0 {
+			account.Default = true
+		} else if account.Default {
+			if err := clearDefaultAccount(tx, account.UserID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(account).Error
+	})
+}
 
-		portfolio.LastUpdated = time.Now()
+// Account with id
+func (r *sqlRepository) FetchAccount(ctx context.Context, id int64) (*domain.Account, error) {
+	var account domain.Account
+	if err := r.db.WithContext(ctx).First(&account, id).Error; err != nil {
+		return nil, accountNotFound(id, err)
+	}
+	return &account, nil
+}
 
-		if err := tx.Where("user_id = ? AND ticker = ?", trade.UserID, trade.Ticker).Save(&portfolio).Error; err != nil {
+// Accounts of a user, oldest first
+func (r *sqlRepository) FetchAccounts(ctx context.Context, userID string) ([]*domain.Account, error) {
+	accounts := []*domain.Account{}
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&accounts).Error
+	return accounts, err
+}
+
+// Renames an account or makes it the default of its user
+func (r *sqlRepository) UpdateAccount(ctx context.Context, id int64, update *domain.Account) (*domain.Account, error) {
+	var account domain.Account
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&account, id).Error; err != nil {
+			return accountNotFound(id, err)
+		}
+		if err := lockUser(tx, account.UserID); err != nil {
+			return err
+		}
+		if update.Default && !account.Default {
+			if err := clearDefaultAccount(tx, account.UserID); err != nil {
+				return err
+			}
+		}
+		changes := &domain.Account{Name: update.Name, Broker: update.Broker, Default: update.Default}
+		if err := tx.Model(&domain.Account{}).Where("id = ?", id).Updates(changes).Error; err != nil {
 			return err
 		}
+		return tx.First(&account, id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
 
-		// Remove trade
-		return tx.Delete(&trade).Error
+// Deletes an account without trades, removed trades included as they could be restored into it
+func (r *sqlRepository) RemoveAccount(ctx context.Context, id int64) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var account domain.Account
+		if err := tx.First(&account, id).Error; err != nil {
+			return accountNotFound(id, err)
+		}
+		if err := lockUser(tx, account.UserID); err != nil {
+			return err
+		}
+		// waits for writers still adding trades to the account, see resolveAccount
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, id).Error; err != nil {
+			return accountNotFound(id, err)
+		}
+		var trades int64
+		if err := tx.Model(&domain.Trade{}).Where("account_id = ?", id).Count(&trades).Error; err != nil {
+			return err
+		}
+		if trades > 0 {
+			return errAccountHasTrades(id)
+		}
+
+		if err := tx.Delete(&account).Error; err != nil {
+			return err
+		}
+		if !account.Default {
+			return nil
+		}
+		var next domain.Account
+		if err := tx.Where("user_id = ?", account.UserID).Order("id").Limit(1).Find(&next).Error; err != nil || next.ID == 0 {
+			return err
+		}
+		return tx.Model(&next).Update("is_default", true).Error
 	})
 }
 
-// Fetch all trades for a user
-func (r *pgRepository) FetchTrades(userID string) ([]*domain.Trade, error) {
+// Takes the default flag off the accounts of a user, its user must be locked
+func clearDefaultAccount(tx *gorm.DB, userID string) error {
+	return tx.Model(&domain.Account{}).Where("user_id = ? AND is_default", userID).Update("is_default", false).Error
+}
+
+func accountNotFound(id int64, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.AccountNotFound(id)
+	}
+	return err
+}
+
+func errAccountHasTrades(id int64) error {
+	return &domain.ConflictError{Message: fmt.Sprintf("account %d still has trades, move them to another account first", id)}
+}
+
+// Applies the ticker, type and date filters of a trade query
+func filterTrades(query *gorm.DB, userID string, filter domain.TradeFilter) *gorm.DB {
+	query = query.Where("user_id = ? AND deleted_at IS NULL", userID)
+	if filter.Ticker != "" {
+		query = query.Where("ticker = ?", filter.Ticker)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.AccountID != 0 {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("timestamp >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("timestamp < ?", filter.To.UTC())
+	}
+	return query
+}
+
+// Trades past the one at timestamp and id in the sort order, the same order orderTrades gives
+func afterTrade(query *gorm.DB, timestamp time.Time, id int64, sort domain.SortDirection) *gorm.DB {
+	op := ">"
+	if sort == domain.Desc {
+		op = "<"
+	}
+	return query.Where("(timestamp "+op+" ?) OR (timestamp = ? AND id "+op+" ?)", timestamp, timestamp, id)
+}
+
+func orderTrades(query *gorm.DB, sort domain.SortDirection) *gorm.DB {
+	if sort == domain.Desc {
+		return query.Order("timestamp DESC, id DESC")
+	}
+	return query.Order("timestamp, id")
+}
+
+// Maps gorm's missing row error to the domain error shared by all repositories
+func tradeNotFound(id int64, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.TradeNotFound(id)
+	}
+	return err
+}
+
+// Timestamps are stored in UTC, SQLite compares them as text so a mix of offsets would sort wrongly
+func normalizeTimestamp(trade *domain.Trade) {
+	if !trade.Timestamp.IsZero() {
+		trade.Timestamp = trade.Timestamp.UTC()
+	}
+}
+
+func utcNow() time.Time {
+	return time.Now().UTC()
+}
+
+// Position of a ticker in one account, UserID is the owner of the account
+type positionKey struct {
+	UserID    string
+	AccountID int64
+	Ticker    string
+}
+
+func (k positionKey) before(other positionKey) bool {
+	if k.UserID != other.UserID {
+		return k.UserID < other.UserID
+	}
+	if k.AccountID != other.AccountID {
+		return k.AccountID < other.AccountID
+	}
+	return k.Ticker < other.Ticker
+}
+
+func tradePosition(trade *domain.Trade) positionKey {
+	return positionKey{UserID: trade.UserID, AccountID: trade.AccountID, Ticker: trade.Ticker}
+}
+
+// Account a trade names, AccountID 0 stands for the default account of the user
+type accountRef struct {
+	UserID    string
+	AccountID int64
+}
+
+// User, account and ticker a trade ends up in once update is applied. Moving it to another user
+// without naming an account moves it to the default account of that user.
+func updatedPosition(stored, update *domain.Trade) *domain.Trade {
+	target := &domain.Trade{UserID: stored.UserID, AccountID: stored.AccountID, Ticker: stored.Ticker}
+	if update.UserID != "" && update.UserID != stored.UserID {
+		target.UserID, target.AccountID = update.UserID, 0
+	}
+	if update.AccountID != 0 {
+		target.AccountID = update.AccountID
+	}
+	if update.Ticker != "" {
+		target.Ticker = update.Ticker
+	}
+	return target
+}
+
+// Checks the account of a trade belongs to its user, a trade without one goes to the default account
+// of its user. The account row stays locked so it can't be deleted before the trade is stored.
+func resolveAccount(tx *gorm.DB, trade *domain.Trade) error {
+	if trade.AccountID == 0 {
+		account, err := defaultAccount(tx, trade.UserID)
+		if err != nil {
+			return err
+		}
+		trade.AccountID = account.ID
+		return nil
+	}
+
+	var account domain.Account
+	err := tx.Clauses(clause.Locking{Strength: "SHARE"}).Where("id = ?", trade.AccountID).Limit(1).Find(&account).Error
+	if err != nil {
+		return err
+	}
+	if account.ID == 0 {
+		return errUnknownAccount(trade.AccountID)
+	}
+	if account.UserID != trade.UserID {
+		return errForeignAccount(trade.AccountID, trade.UserID)
+	}
+	return nil
+}
+
+// Default account of a user, opened along with the user when it has no account yet
+func defaultAccount(tx *gorm.DB, userID string) (*domain.Account, error) {
+	var account domain.Account
+	find := func() error {
+		return tx.Clauses(clause.Locking{Strength: "SHARE"}).Where("user_id = ? AND is_default", userID).Limit(1).Find(&account).Error
+	}
+	if err := find(); err != nil || account.ID != 0 {
+		return &account, err
+	}
+
+	if err := lockUser(tx, userID); err != nil {
+		return nil, err
+	}
+	// another writer may have opened it while the user was not locked yet
+	if err := find(); err != nil || account.ID != 0 {
+		return &account, err
+	}
+	account = domain.Account{UserID: userID, Name: domain.DefaultAccountName, Default: true, CreatedAt: utcNow()}
+	if err := tx.Create(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// Adds the user when it is new and locks it, serializing changes to the accounts of one user
+func lockUser(tx *gorm.DB, userID string) error {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&domain.User{ID: userID, CreatedAt: utcNow()}).Error; err != nil {
+		return err
+	}
+	var user domain.User
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userID).Take(&user).Error
+}
+
+func errUnknownAccount(id int64) error {
+	return domain.NewValidationError("accountId", fmt.Sprintf("Account %d not found", id))
+}
+
+func errForeignAccount(id int64, userID string) error {
+	return domain.NewValidationError("accountId", fmt.Sprintf("Account %d does not belong to user %q", id, userID))
+}
+
+func costBasisMethod(tx *gorm.DB, userID string) (domain.CostBasisMethod, error) {
+	var settings domain.UserSettings
+	if err := tx.Where("user_id = ?", userID).Limit(1).Find(&settings).Error; err != nil {
+		return "", err
+	}
+	if settings.CostBasisMethod == "" {
+		return domain.DefaultCostBasisMethod, nil
+	}
+	return settings.CostBasisMethod, nil
+}
+
+// Records the cost basis method a SELL is matched with, explicit lot ids always win
+func resolveCostBasis(tx *gorm.DB, trade *domain.Trade) error {
+	if len(trade.LotIDs) > 0 {
+		trade.CostBasis = domain.SpecificLot
+		return nil
+	}
+	method, err := costBasisMethod(tx, trade.UserID)
+	if err != nil {
+		return err
+	}
+	trade.CostBasis = method
+	return nil
+}
+
+// Recomputes the portfolio row and lots of an account and ticker by replaying its trades in order.
+// Any error leaves the stored position untouched once the transaction rolls back.
+func replayPosition(tx *gorm.DB, key positionKey) error {
 	var trades []*domain.Trade
-	err := r.db.Where("user_id = ?", userID).Find(&trades).Error
-	return trades, err
+	if err := tx.Where("account_id = ? AND ticker = ? AND deleted_at IS NULL", key.AccountID, key.Ticker).Order("timestamp, id").Find(&trades).Error; err != nil {
+		return err
+	}
+
+	portfolio, lots, err := domain.Replay(key.UserID, key.AccountID, key.Ticker, trades)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Where("account_id = ? AND ticker = ?", key.AccountID, key.Ticker).Delete(&domain.Lot{}).Error; err != nil {
+		return err
+	}
+	if len(trades) == 0 {
+		return tx.Where("account_id = ? AND ticker = ?", key.AccountID, key.Ticker).Delete(&domain.Portfolio{}).Error
+	}
+
+	if len(lots) > 0 {
+		if err := tx.Create(&lots).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(portfolio).Error
 }
 
-// Fetch Portfolio
-func (r *pgRepository) FetchPortfolio(userID string) ([]*domain.Portfolio, error) {
-	var portfolio []*domain.Portfolio
-	err := r.db.Where("user_id = ?", userID).Find(&portfolio).Error
-	return portfolio, err
+// Serializes writers of the same positions until the transaction ends. A missing portfolio row is
+// inserted first so there is a row to lock, replayPosition fills it in or deletes it again.
+// Keys are locked in a fixed order so two transactions never wait on each other.
+func lockPositions(tx *gorm.DB, keys ...positionKey) error {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].before(keys[j]) })
+
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		placeholder := &domain.Portfolio{UserID: key.UserID, AccountID: key.AccountID, Ticker: key.Ticker, Currency: domain.DefaultCurrency}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(placeholder).Error; err != nil {
+			return err
+		}
+		var locked domain.Portfolio
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("account_id = ? AND ticker = ?", key.AccountID, key.Ticker).
+			Take(&locked).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Builds lots for trades recorded before lots were tracked, run once as migration 12. A position whose
+// history can't be replayed is logged and left without lots, the rest are still built.
+func backfillLots(tx *gorm.DB) error {
+	var keys []positionKey
+	err := tx.Model(&domain.Trade{}).
+		Distinct("user_id", "account_id", "ticker").
+		Where("deleted_at IS NULL").
+		Where("NOT EXISTS (SELECT 1 FROM lots WHERE lots.account_id = trades.account_id AND lots.ticker = trades.ticker)").
+		Find(&keys).Error
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		// nested in a savepoint so a broken position doesn't abort the migration
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			if err := lockPositions(tx, key); err != nil {
+				return err
+			}
+			return replayPosition(tx, key)
+		})
+		if err != nil {
+			log.Printf("failed to build lots for %s/%d/%s: %v", key.UserID, key.AccountID, key.Ticker, err)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,43 @@
+package repositories
+
+import (
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// Creates and initializes new Repositories on an embedded SQLite database, autoMigrate applies pending migrations first.
+// dbUrl is sqlite://path/to/file.db, or sqlite://:memory: for a throwaway database.
+func NewSQLiteRepository(dbUrl string, autoMigrate bool) (*Repositories, error) {
+	db, err := openSQLite(dbUrl)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLRepository(db, "sqlite", autoMigrate)
+}
+
+func openSQLite(dbUrl string) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(sqliteDSN(dbUrl)), &gorm.Config{NowFunc: utcNow})
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, one connection serializes transactions instead of failing them with SQLITE_BUSY
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// Turns a sqlite:// URL into the file name the driver expects, keeping any query parameters
+func sqliteDSN(dbUrl string) string {
+	dsn := strings.TrimPrefix(dbUrl, "sqlite://")
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
@@ -1,14 +1,62 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	DatabaseURL string
-	Port        string
+	// Storage backend, "postgres", "sqlite" or "memory". STORAGE_DRIVER when set, else picked from the DATABASE_URL scheme
+	StorageDriver string
+	DatabaseURL   string
+	// Apply pending migrations on startup instead of refusing to start
+	DBAutoMigrate bool
+	Port          string
+	// Deadline for a single request, exports are exempt as they stream
+	RequestTimeout time.Duration
+	// How long responses to requests with an Idempotency-Key are replayed
+	IdempotencyRetention time.Duration
+
+	// Every request acts as an anonymous admin when set, for local development only
+	AuthDisabled bool
+	// HS256 secret and JWKS file with RS256 keys, tokens signed with either are accepted
+	JWTSecret   string
+	JWTJWKSFile string
+	// Expected iss and aud claims, not checked when empty
+	JWTIssuer   string
+	JWTAudience string
+	// Token subjects allowed to act for every user and run admin routes
+	AdminSubjects []string
+
+	// Requests each client, API key, user or else IP, may make at once per kind, refilled evenly over
+	// RateLimitPeriod. GET requests count as reads and everything else as writes, a zero limit is unlimited.
+	RateLimitEnabled bool
+	RateLimitRead    int
+	RateLimitWrite   int
+	RateLimitPeriod  time.Duration
+	// Requests an IP address may make per RateLimitPeriod whoever makes them, counted before authentication
+	// so requests with bad credentials count too. Zero is unlimited.
+	RateLimitAddress int
+	// Take the client IP from X-Forwarded-For and X-Real-IP, only safe behind a proxy setting them
+	TrustProxyHeaders bool
+	// Redis holding the buckets so instances share them, each instance keeps its own in memory when empty
+	RateLimitRedisURL string
+
+	// Market price source, "file" or "http"
+	PriceProvider string
+	PriceFile     string
+	PriceAPIURL   string
+	PriceTimeout  time.Duration
+
+	// Background quote refresh, quotes older than PriceMaxAge are reported stale
+	PriceRefreshEnabled  bool
+	PriceRefreshInterval time.Duration
+	PriceMaxAge          time.Duration
 }
 
 // Loads variables form Environment and returns Config struct
@@ -16,10 +64,87 @@ func LoadConfig() *Config {
 	// Load .env file if it exists
 	_ = godotenv.Load()
 
+	databaseURL := getEnv("DATABASE_URL", "")
+
 	return &Config{
-		DatabaseURL: getEnv("DATABASE_URL", ""),
-		Port:        ":" + getEnv("PORT", "8080"),
+		StorageDriver:  storageDriver(strings.ToLower(strings.TrimSpace(getEnv("STORAGE_DRIVER", ""))), databaseURL),
+		DatabaseURL:    databaseURL,
+		DBAutoMigrate:  getBool("DB_AUTO_MIGRATE", false),
+		Port:           ":" + getEnv("PORT", "8080"),
+		RequestTimeout: getDuration("REQUEST_TIMEOUT", 30*time.Second),
+
+		IdempotencyRetention: getDuration("IDEMPOTENCY_RETENTION", 24*time.Hour),
+
+		AuthDisabled:  getBool("AUTH_DISABLED", false),
+		JWTSecret:     getEnv("JWT_SECRET", ""),
+		JWTJWKSFile:   getEnv("JWT_JWKS_FILE", ""),
+		JWTIssuer:     getEnv("JWT_ISSUER", ""),
+		JWTAudience:   getEnv("JWT_AUDIENCE", ""),
+		AdminSubjects: getList("AUTH_ADMIN_SUBJECTS"),
+
+		RateLimitEnabled:  getBool("RATE_LIMIT_ENABLED", true),
+		RateLimitRead:     getInt("RATE_LIMIT_READ", 300),
+		RateLimitWrite:    getInt("RATE_LIMIT_WRITE", 60),
+		RateLimitPeriod:   getDuration("RATE_LIMIT_PERIOD", time.Minute),
+		RateLimitAddress:  getInt("RATE_LIMIT_IP", 600),
+		TrustProxyHeaders: getBool("TRUST_PROXY_HEADERS", false),
+		RateLimitRedisURL: getEnv("RATE_LIMIT_REDIS_URL", ""),
+
+		PriceProvider: getEnv("PRICE_PROVIDER", "file"),
+		PriceFile:     getEnv("PRICE_FILE", "data/prices.csv"),
+		PriceAPIURL:   getEnv("PRICE_API_URL", "http://localhost:9090"),
+		PriceTimeout:  getDuration("PRICE_TIMEOUT", 5*time.Second),
+
+		PriceRefreshEnabled:  getBool("PRICE_REFRESH_ENABLED", false),
+		PriceRefreshInterval: getDuration("PRICE_REFRESH_INTERVAL", time.Minute),
+		PriceMaxAge:          getDuration("PRICE_MAX_AGE", 5*time.Minute),
+	}
+}
+
+// Rejects settings the server can't run with, so they fail at startup instead of in a background job
+func (c *Config) Validate() error {
+	switch c.StorageDriver {
+	case "postgres", "sqlite", "memory":
+	default:
+		return fmt.Errorf("STORAGE_DRIVER must be postgres, sqlite or memory, got %q", c.StorageDriver)
+	}
+	// the memory backend has no database, any URL is left unused
+	if driver := urlDriver(c.DatabaseURL); driver != "" && driver != c.StorageDriver && c.StorageDriver != "memory" {
+		return fmt.Errorf("STORAGE_DRIVER=%s conflicts with the %s DATABASE_URL", c.StorageDriver, driver)
+	}
+	if c.IdempotencyRetention <= 0 {
+		return fmt.Errorf("IDEMPOTENCY_RETENTION must be a positive duration, got %s", c.IdempotencyRetention)
+	}
+	if c.PriceRefreshEnabled && c.PriceRefreshInterval <= 0 {
+		return fmt.Errorf("PRICE_REFRESH_INTERVAL must be a positive duration when PRICE_REFRESH_ENABLED is set, got %s", c.PriceRefreshInterval)
+	}
+	return nil
+}
+
+// The given driver when set, else the backend the URL names, else postgres, which also covers key=value DSNs
+func storageDriver(driver, databaseURL string) string {
+	if driver != "" {
+		return driver
+	}
+	if driver = urlDriver(databaseURL); driver != "" {
+		return driver
+	}
+	return "postgres"
+}
+
+// Backend named by the URL scheme, "" for URLs without one like DSNs and SQLite file paths
+func urlDriver(databaseURL string) string {
+	scheme, _, found := strings.Cut(databaseURL, "://")
+	if !found {
+		return ""
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		return "postgres"
+	case "sqlite", "memory":
+		return scheme
 	}
+	return ""
 }
 
 func getEnv(key, fallback string) string {
@@ -28,3 +153,44 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// Reads a duration like "30s" or "5m", falls back on missing or invalid values
+func getDuration(key string, fallback time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// Reads a whole number, falls back on missing or invalid values
+func getInt(key string, fallback int) int {
+	if value, exists := os.LookupEnv(key); exists {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+// Reads a boolean like "true" or "0", falls back on missing or invalid values
+func getBool(key string, fallback bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// Reads a comma separated list, blank entries are dropped
+func getList(key string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
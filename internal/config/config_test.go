@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{StorageDriver: "postgres", DatabaseURL: "postgres://localhost/portfolio", IdempotencyRetention: 24 * time.Hour, PriceRefreshEnabled: true, PriceRefreshInterval: time.Minute}
+	}
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr bool
+	}{
+		{"Defaults", func(c *Config) {}, false},
+		{"ZeroIdempotencyRetention", func(c *Config) { c.IdempotencyRetention = 0 }, true},
+		{"NegativeIdempotencyRetention", func(c *Config) { c.IdempotencyRetention = -time.Hour }, true},
+		{"ZeroPriceRefreshInterval", func(c *Config) { c.PriceRefreshInterval = 0 }, true},
+		{"NegativePriceRefreshInterval", func(c *Config) { c.PriceRefreshInterval = -time.Minute }, true},
+		// the interval isn't used without the refresher
+		{"ZeroPriceRefreshIntervalWhenDisabled", func(c *Config) { c.PriceRefreshEnabled, c.PriceRefreshInterval = false, 0 }, false},
+		{"UnknownStorageDriver", func(c *Config) { c.StorageDriver = "mysql" }, true},
+		{"StorageDriverConflictsWithURL", func(c *Config) { c.StorageDriver, c.DatabaseURL = "postgres", "sqlite://data/portfolio.db" }, true},
+		{"SQLiteDriverWithPostgresURL", func(c *Config) { c.StorageDriver = "sqlite" }, true},
+		{"SQLiteDriverWithPath", func(c *Config) { c.StorageDriver, c.DatabaseURL = "sqlite", "data/portfolio.db" }, false},
+		{"MemoryDriverIgnoresURL", func(c *Config) { c.StorageDriver = "memory" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.change(cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStorageDriver(t *testing.T) {
+	tests := []struct {
+		name, driver, databaseURL string
+		want                      string
+	}{
+		{"PostgresURL", "", "postgres://user:pw@localhost/portfolio", "postgres"},
+		{"PostgresqlURL", "", "postgresql://localhost/portfolio", "postgres"},
+		{"DSN", "", "host=localhost user=portfolio", "postgres"},
+		{"Empty", "", "", "postgres"},
+		{"SQLiteURL", "", "sqlite://data/portfolio.db", "sqlite"},
+		{"MemoryURL", "", "memory://", "memory"},
+		// an explicit driver wins, Validate rejects it when the URL names another backend
+		{"Override", "sqlite", "data/portfolio.db", "sqlite"},
+		{"OverrideMemory", "memory", "postgres://localhost/portfolio", "memory"},
+		{"UnknownOverride", "mysql", "postgres://localhost/portfolio", "mysql"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := storageDriver(tt.driver, tt.databaseURL); got != tt.want {
+				t.Errorf("storageDriver(%q, %q) = %q, want %q", tt.driver, tt.databaseURL, got, tt.want)
+			}
+		})
+	}
+}
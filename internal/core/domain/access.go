@@ -0,0 +1,71 @@
+package domain
+
+import "time"
+
+type Role string
+
+// Role enum, Service is the role of API keys
+const (
+	Admin    Role = "admin"
+	Advisor  Role = "advisor"
+	Investor Role = "investor"
+	Service  Role = "service"
+)
+
+// Role of a token without a role claim
+const DefaultRole = Investor
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+type Permission string
+
+// Permission enum, one per group of routes
+const (
+	ReadTrades     Permission = "trades:read"
+	WriteTrades    Permission = "trades:write"
+	ImportTrades   Permission = "trades:import"
+	ReadPortfolio  Permission = "portfolio:read"
+	WriteSettings  Permission = "settings:write"
+	ManageAccounts Permission = "accounts:write"
+	RebuildLedger  Permission = "admin:rebuild"
+	ManageAPIKeys  Permission = "admin:api-keys"
+	ManageAdvisors Permission = "admin:advisors"
+)
+
+// Reports whether the permission changes data, read-only callers never hold those
+func (p Permission) Writes() bool {
+	switch p {
+	case ReadTrades, ReadPortfolio:
+		return false
+	}
+	return true
+}
+
+// What each role may do. Investors act on their own data, advisors on theirs and their linked clients',
+// services on the users their API key allows and admins on everyone's.
+var rolePermissions = map[Role][]Permission{
+	Investor: {ReadTrades, WriteTrades, ReadPortfolio, WriteSettings, ManageAccounts},
+	Advisor:  {ReadTrades, WriteTrades, ReadPortfolio, WriteSettings, ManageAccounts},
+	Service:  {ReadTrades, WriteTrades, ImportTrades, ReadPortfolio, WriteSettings, ManageAccounts},
+	Admin:    {ReadTrades, WriteTrades, ImportTrades, ReadPortfolio, WriteSettings, ManageAccounts, RebuildLedger, ManageAPIKeys, ManageAdvisors},
+}
+
+// Reports whether the role grants the permission
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Client whose portfolio an advisor manages
+type AdvisorClient struct {
+	AdvisorID string    `gorm:"primaryKey" json:"advisorId"`
+	ClientID  string    `gorm:"primaryKey" json:"clientId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
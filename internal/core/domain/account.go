@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// Investor owning trading accounts, added the first time one of its accounts is created
+type User struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Demat or broker account of a user, every trade belongs to one.
+// Trades sent without an account go to the default account of their user.
+type Account struct {
+	ID        int64     `json:"id"`
+	UserID    string    `gorm:"index" json:"userId"`
+	Name      string    `json:"name"`
+	Broker    string    `json:"broker,omitempty"`
+	Default   bool      `gorm:"column:is_default" json:"default"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Name of the account created for trades of a user without any account
+const DefaultAccountName = "Default"
@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"strconv"
+	"time"
+)
+
+type APIKeyScope string
+
+// API key scope enum
+const (
+	ReadOnly  APIKeyScope = "read-only"
+	ReadWrite APIKeyScope = "read-write"
+)
+
+func (s APIKeyScope) Valid() bool {
+	return s == ReadOnly || s == ReadWrite
+}
+
+// Long-lived credential of a service client, the key itself is only shown once when it is created.
+// UserIDs limits the key to those users, an empty list allows every user. A key without ExpiresAt never expires.
+type APIKey struct {
+	ID         int64       `json:"id"`
+	Name       string      `json:"name"`
+	Prefix     string      `json:"prefix"`
+	KeyHash    string      `json:"-"`
+	Scope      APIKeyScope `json:"scope"`
+	UserIDs    []string    `gorm:"serializer:json" json:"userIds,omitempty"`
+	CreatedBy  string      `json:"createdBy,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	LastUsedAt *time.Time  `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time  `json:"revokedAt,omitempty"`
+	ExpiresAt  *time.Time  `json:"expiresAt,omitempty"`
+}
+
+// Reports whether the key has expired by now
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Caller acting with this key, recorded as the actor of its changes
+func (k *APIKey) Principal() *Principal {
+	return &Principal{
+		Subject:  "api-key:" + strconv.FormatInt(k.ID, 10),
+		Role:     Service,
+		Users:    k.UserIDs,
+		AllUsers: len(k.UserIDs) == 0,
+		ReadOnly: k.Scope != ReadWrite,
+	}
+}
+
+// API key as returned once on creation, Key is the secret to send in the X-API-Key header
+type NewAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
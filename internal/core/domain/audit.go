@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+type AuditAction string
+
+// Audit action enum
+const (
+	AuditCreate  AuditAction = "CREATE"
+	AuditUpdate  AuditAction = "UPDATE"
+	AuditDelete  AuditAction = "DELETE"
+	AuditRestore AuditAction = "RESTORE"
+)
+
+// Actor recorded when a change carries none
+const AnonymousActor = "anonymous"
+
+// Entry of the append-only trade audit log, Before is nil on create and After is nil on delete
+type TradeAudit struct {
+	ID        int64       `json:"id"`
+	TradeID   int64       `gorm:"index" json:"tradeId"`
+	Action    AuditAction `json:"action"`
+	Actor     string      `json:"actor"`
+	Reason    string      `json:"reason,omitempty"`
+	Before    *Trade      `gorm:"column:before_data;serializer:json" json:"before,omitempty"`
+	After     *Trade      `gorm:"column:after_data;serializer:json" json:"after,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Who makes a change and why, carried on the context down to the repositories
+type Change struct {
+	Actor  string
+	Reason string
+}
+
+type changeKey struct{}
+
+// Attaches change to ctx so the writes made with it are audited under that actor and reason
+func WithChange(ctx context.Context, change Change) context.Context {
+	return context.WithValue(ctx, changeKey{}, change)
+}
+
+// Change attached to ctx, the actor defaults to AnonymousActor
+func ChangeFrom(ctx context.Context) Change {
+	change, _ := ctx.Value(changeKey{}).(Change)
+	if change.Actor == "" {
+		change.Actor = AnonymousActor
+	}
+	return change
+}
+
+// Audit entry for action on a trade, made by the change attached to ctx
+func NewTradeAudit(ctx context.Context, tradeID int64, action AuditAction, before, after *Trade, at time.Time) *TradeAudit {
+	change := ChangeFrom(ctx)
+	return &TradeAudit{
+		TradeID:   tradeID,
+		Action:    action,
+		Actor:     change.Actor,
+		Reason:    change.Reason,
+		Before:    before,
+		After:     after,
+		Timestamp: at,
+	}
+}
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"context"
+	"slices"
+)
+
+// Caller of a request as established by authentication
+type Principal struct {
+	// Who the caller is, recorded as the actor of its changes
+	Subject string
+	// User the caller is, empty for service clients
+	UserID string
+	Role   Role
+	// Other users a service client may access, AllUsers lifts the limit
+	Users    []string
+	AllUsers bool
+	// Limited to permissions that don't change data
+	ReadOnly bool
+}
+
+// Reports whether the principal holds the permission
+func (p *Principal) Can(permission Permission) bool {
+	if p.ReadOnly && permission.Writes() {
+		return false
+	}
+	return p.Role.Can(permission)
+}
+
+// Reports whether the principal may access the data of userID without looking at advisor links
+func (p *Principal) CanAccess(userID string) bool {
+	if p.Role == Admin || p.AllUsers {
+		return true
+	}
+	return userID != "" && (userID == p.UserID || slices.Contains(p.Users, userID))
+}
+
+type principalKey struct{}
+
+// Attaches the authenticated caller to ctx
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Authenticated caller attached to ctx, false when the request was not authenticated
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
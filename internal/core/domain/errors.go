@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Matches any trade that does not exist, compare with errors.Is
+var ErrTradeNotFound = &NotFoundError{Resource: "trade"}
+
+// Requested record does not exist
+type NotFoundError struct {
+	Resource string
+	ID       string
+}
+
+// Not found error for the trade with id
+func TradeNotFound(id int64) *NotFoundError {
+	return &NotFoundError{Resource: "trade", ID: fmt.Sprint(id)}
+}
+
+// Not found error for the API key with id
+func APIKeyNotFound(id int64) *NotFoundError {
+	return &NotFoundError{Resource: "api key", ID: fmt.Sprint(id)}
+}
+
+// Not found error for the account with id
+func AccountNotFound(id int64) *NotFoundError {
+	return &NotFoundError{Resource: "account", ID: fmt.Sprint(id)}
+}
+
+func (e *NotFoundError) Error() string {
+	if e.ID == "" {
+		return e.Resource + " not found"
+	}
+	return fmt.Sprintf("%s %s not found", e.Resource, e.ID)
+}
+
+// A not found error without an ID matches every ID of the same resource
+func (e *NotFoundError) Is(target error) bool {
+	t, ok := target.(*NotFoundError)
+	return ok && t.Resource == e.Resource && (t.ID == "" || t.ID == e.ID)
+}
+
+// A sell asks for more than is held at its point in history, Held covers only the chosen lots when SelectedLots is set
+type InsufficientQuantityError struct {
+	TradeID      int64
+	Timestamp    time.Time
+	Held         decimal.Decimal
+	Requested    decimal.Decimal
+	SelectedLots bool
+}
+
+func (e *InsufficientQuantityError) Error() string {
+	held := "holding"
+	if e.SelectedLots {
+		held = "selected lots hold"
+	}
+	return fmt.Sprintf("insufficient quantity for sell trade %d on %s: %s %s, selling %s",
+		e.TradeID, e.Timestamp.Format(time.RFC3339), held, e.Held, e.Requested)
+}
+
+// Input rejected before anything is stored, one entry per offending field
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validation error for a single field
+func NewValidationError(field, message string) *ValidationError {
+	return &ValidationError{Errors: []*FieldError{{Field: field, Message: message}}}
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Request clashes with data already stored
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// Request carries no valid credentials
+type UnauthorizedError struct {
+	Message string
+}
+
+func (e *UnauthorizedError) Error() string {
+	return e.Message
+}
+
+// Caller is authenticated but may not act on the requested data
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+// Caller used up its request quota and may retry after RetryAfter
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit exceeded, retry in %d seconds", CeilSeconds(e.RetryAfter))
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// Outcome of a request made with an Idempotency-Key, Status stays 0 while the first request is still running
+type IdempotencyRecord struct {
+	UserID      string `gorm:"primaryKey"`
+	Key         string `gorm:"column:idempotency_key;primaryKey"`
+	RequestHash string
+	Status      int
+	Response    string
+	CreatedAt   time.Time
+}
+
+// Reports whether the first request finished and its response can be replayed
+func (r *IdempotencyRecord) Completed() bool {
+	return r.Status != 0
+}
@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"fmt"
+)
+
+// Outcome of a bulk trade import, Portfolio holds the resulting positions touched by the import
+type ImportResult struct {
+	DryRun    bool         `json:"dryRun"`
+	Imported  int          `json:"imported"`
+	Portfolio []*Portfolio `json:"portfolio"`
+}
+
+// Problem with a single row of an import. Row counts data lines from 1 for the first line after the
+// header, Row 0 is an already stored trade that the import would break, like a sell left uncovered
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// Returned when any row of an import is rejected, nothing is stored in that case
+type ImportError struct {
+	Errors []*RowError `json:"errors"`
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("import rejected: %d invalid rows", len(e.Errors))
+}
@@ -0,0 +1,147 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// Outcome of rebuilding every portfolio from the trade ledger
+type RebuildReport struct {
+	Rebuilt int               `json:"rebuilt"`
+	Failed  []*RebuildFailure `json:"failed"`
+}
+
+type RebuildFailure struct {
+	UserID    string `json:"userId"`
+	AccountID int64  `json:"accountId"`
+	Ticker    string `json:"ticker"`
+	Error     string `json:"error"`
+}
+
+// Failure to apply a trade at its point in history
+type TradeError struct {
+	TradeID int64
+	Err     error
+}
+
+func (e *TradeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TradeError) Unwrap() error {
+	return e.Err
+}
+
+// Replays the trades of one account and ticker, ordered by timestamp then id, from an empty position.
+// Fails as soon as a sell exceeds the quantity held at that point in history.
+func Replay(userID string, accountID int64, ticker string, trades []*Trade) (*Portfolio, []*Lot, error) {
+	portfolio := &Portfolio{UserID: userID, AccountID: accountID, Ticker: ticker, Currency: DefaultCurrency}
+	lots := []*Lot{}
+
+	for i, trade := range trades {
+		currency := trade.Currency
+		if currency == "" {
+			currency = DefaultCurrency
+		}
+		if i == 0 {
+			portfolio.Currency = currency
+		} else if currency != portfolio.Currency {
+			return nil, nil, &TradeError{TradeID: trade.Id, Err: &ConflictError{Message: fmt.Sprintf("trade %d is in %s but the position is in %s",
+				trade.Id, currency, portfolio.Currency)}}
+		}
+
+		switch trade.Type {
+		case Buy:
+			newQuantity := portfolio.Quantity.Add(trade.Quantity)
+			newTotalValue := portfolio.AverageBuyPrice.Mul(portfolio.Quantity).Add(trade.Price.Mul(trade.Quantity))
+			portfolio.Quantity = newQuantity
+			if newQuantity.IsPositive() {
+				portfolio.AverageBuyPrice = currency.RoundPrice(newTotalValue.Div(newQuantity))
+			} else {
+				portfolio.AverageBuyPrice = decimal.Zero
+			}
+			lots = append(lots, NewLot(trade))
+		case Sell:
+			if portfolio.Quantity.LessThan(trade.Quantity) {
+				return nil, nil, &TradeError{TradeID: trade.Id, Err: &InsufficientQuantityError{
+					TradeID: trade.Id, Timestamp: trade.Timestamp, Held: portfolio.Quantity, Requested: trade.Quantity}}
+			}
+			portfolio.Quantity = portfolio.Quantity.Sub(trade.Quantity)
+			// no change to AverageBuyPrice when selling, the gain is booked as realized
+			realized := trade.Price.Sub(portfolio.AverageBuyPrice).Mul(trade.Quantity)
+			portfolio.RealizedPnL = portfolio.RealizedPnL.Add(currency.RoundAmount(realized))
+
+			method := trade.CostBasis
+			if method == "" {
+				method = DefaultCostBasisMethod
+			}
+			if err := AllocateLots(lots, method, trade.LotIDs, trade.Quantity); err != nil {
+				var quantityErr *InsufficientQuantityError
+				if errors.As(err, &quantityErr) {
+					quantityErr.TradeID, quantityErr.Timestamp = trade.Id, trade.Timestamp
+					return nil, nil, &TradeError{TradeID: trade.Id, Err: quantityErr}
+				}
+				return nil, nil, &TradeError{TradeID: trade.Id, Err: fmt.Errorf("sell trade %d: %w", trade.Id, err)}
+			}
+		default:
+			return nil, nil, &TradeError{TradeID: trade.Id, Err: NewValidationError("type", fmt.Sprintf("trade %d has invalid type %q", trade.Id, trade.Type))}
+		}
+		portfolio.LastUpdated = trade.Timestamp
+	}
+
+	return portfolio, lots, nil
+}
+
+// Adds up the positions a user holds in each of its accounts into one position per ticker and currency.
+// Quantities and realized P&L are summed and the average buy price is weighted by the quantity held.
+func AggregatePortfolio(userID string, positions []*Portfolio) []*Portfolio {
+	type holding struct {
+		ticker   string
+		currency Currency
+	}
+	byHolding := make(map[holding]*Portfolio)
+	var cost map[holding]decimal.Decimal
+	portfolio := []*Portfolio{}
+	for _, position := range positions {
+		key := holding{position.Ticker, position.Currency}
+		total, ok := byHolding[key]
+		if !ok {
+			total = &Portfolio{UserID: userID, Ticker: position.Ticker, Currency: position.Currency,
+				Quantity: position.Quantity, AverageBuyPrice: position.AverageBuyPrice,
+				RealizedPnL: position.RealizedPnL, LastUpdated: position.LastUpdated}
+			byHolding[key] = total
+			portfolio = append(portfolio, total)
+			continue
+		}
+		if cost == nil {
+			cost = make(map[holding]decimal.Decimal)
+		}
+		if _, ok := cost[key]; !ok {
+			cost[key] = total.AverageBuyPrice.Mul(total.Quantity)
+		}
+		cost[key] = cost[key].Add(position.AverageBuyPrice.Mul(position.Quantity))
+		total.Quantity = total.Quantity.Add(position.Quantity)
+		total.RealizedPnL = total.RealizedPnL.Add(position.RealizedPnL)
+		if position.LastUpdated.After(total.LastUpdated) {
+			total.LastUpdated = position.LastUpdated
+		}
+	}
+	// a single account keeps its own average, even once it is sold out
+	for key, value := range cost {
+		total := byHolding[key]
+		total.AverageBuyPrice = decimal.Zero
+		if total.Quantity.IsPositive() {
+			total.AverageBuyPrice = key.currency.RoundPrice(value.Div(total.Quantity))
+		}
+	}
+	sort.SliceStable(portfolio, func(i, j int) bool {
+		if portfolio[i].Ticker != portfolio[j].Ticker {
+			return portfolio[i].Ticker < portfolio[j].Ticker
+		}
+		return portfolio[i].Currency < portfolio[j].Currency
+	})
+	return portfolio
+}
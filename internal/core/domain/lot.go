@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type CostBasisMethod string
+
+// Lot selection methods for sells
+const (
+	FIFO        CostBasisMethod = "FIFO"
+	LIFO        CostBasisMethod = "LIFO"
+	HighestCost CostBasisMethod = "HIFO"
+	SpecificLot CostBasisMethod = "SPECIFIC"
+)
+
+// Method used when a user has not picked one
+const DefaultCostBasisMethod = FIFO
+
+// Holding acquired by a single BUY, the lot id is the id of that trade
+type Lot struct {
+	ID                int64           `gorm:"primaryKey;autoIncrement:false" json:"id"`
+	UserID            string          `gorm:"index:idx_lots_user_ticker" json:"userId"`
+	AccountID         int64           `gorm:"index:idx_lots_account_ticker" json:"accountId"`
+	Ticker            string          `gorm:"index:idx_lots_user_ticker" json:"ticker"`
+	Quantity          decimal.Decimal `gorm:"type:numeric(24,8)" json:"quantity" swaggertype:"number"`
+	RemainingQuantity decimal.Decimal `gorm:"type:numeric(24,8)" json:"remainingQuantity" swaggertype:"number"`
+	Price             decimal.Decimal `gorm:"type:numeric(24,8)" json:"price" swaggertype:"number"`
+	AcquiredAt        time.Time       `json:"acquiredAt"`
+}
+
+// Per user preferences
+type UserSettings struct {
+	UserID          string          `gorm:"primaryKey" json:"userId"`
+	CostBasisMethod CostBasisMethod `json:"costBasisMethod"`
+}
+
+func (m CostBasisMethod) Valid() bool {
+	switch m {
+	case FIFO, LIFO, HighestCost, SpecificLot:
+		return true
+	}
+	return false
+}
+
+// Opens a new lot for a BUY trade
+func NewLot(trade *Trade) *Lot {
+	return &Lot{
+		ID:                trade.Id,
+		UserID:            trade.UserID,
+		AccountID:         trade.AccountID,
+		Ticker:            trade.Ticker,
+		Quantity:          trade.Quantity,
+		RemainingQuantity: trade.Quantity,
+		Price:             trade.Price,
+		AcquiredAt:        trade.Timestamp,
+	}
+}
+
+// Consumes quantity from the open lots following method, lotIDs are taken in order for SpecificLot.
+// RemainingQuantity of the consumed lots is decreased in place.
+func AllocateLots(lots []*Lot, method CostBasisMethod, lotIDs []int64, quantity decimal.Decimal) error {
+	var ordered []*Lot
+	switch method {
+	case SpecificLot:
+		byID := make(map[int64]*Lot, len(lots))
+		for _, lot := range lots {
+			byID[lot.ID] = lot
+		}
+		for _, id := range lotIDs {
+			lot, ok := byID[id]
+			if !ok {
+				return NewValidationError("lotIds", fmt.Sprintf("lot %d not found", id))
+			}
+			// drop duplicates so a lot is not counted twice
+			delete(byID, id)
+			ordered = append(ordered, lot)
+		}
+	case FIFO, LIFO, HighestCost:
+		ordered = append(ordered, lots...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			a, b := ordered[i], ordered[j]
+			switch method {
+			case LIFO:
+				if !a.AcquiredAt.Equal(b.AcquiredAt) {
+					return a.AcquiredAt.After(b.AcquiredAt)
+				}
+				return a.ID > b.ID
+			case HighestCost:
+				if !a.Price.Equal(b.Price) {
+					return a.Price.GreaterThan(b.Price)
+				}
+			}
+			if !a.AcquiredAt.Equal(b.AcquiredAt) {
+				return a.AcquiredAt.Before(b.AcquiredAt)
+			}
+			return a.ID < b.ID
+		})
+	default:
+		return NewValidationError("costBasis", fmt.Sprintf("unknown cost basis method %q", method))
+	}
+
+	available := decimal.Zero
+	for _, lot := range ordered {
+		available = available.Add(lot.RemainingQuantity)
+	}
+	if available.LessThan(quantity) {
+		return &InsufficientQuantityError{Held: available, Requested: quantity, SelectedLots: method == SpecificLot}
+	}
+
+	for _, lot := range ordered {
+		if !quantity.IsPositive() {
+			break
+		}
+		take := decimal.Min(lot.RemainingQuantity, quantity)
+		lot.RemainingQuantity = lot.RemainingQuantity.Sub(take)
+		quantity = quantity.Sub(take)
+	}
+	return nil
+}
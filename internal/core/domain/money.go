@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// ISO 4217 currency code
+type Currency string
+
+const DefaultCurrency Currency = "INR"
+
+// Digits after the decimal point of an amount in each supported currency
+var minorUnits = map[Currency]int32{
+	"INR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"KWD": 3,
+}
+
+// Unit prices keep this many digits beyond the minor unit, so average prices don't drift
+const extraPriceDigits = 4
+
+// Digits after the decimal point allowed in a quantity (fractional shares)
+const QuantityScale = 8
+
+func init() {
+	// Keep money as JSON numbers so existing clients keep working
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+func (c Currency) Valid() bool {
+	_, ok := minorUnits[c]
+	return ok
+}
+
+// Digits after the decimal point of an amount (P&L, position value)
+func (c Currency) AmountScale() int32 {
+	if units, ok := minorUnits[c]; ok {
+		return units
+	}
+	return minorUnits[DefaultCurrency]
+}
+
+// Digits after the decimal point of a unit price (trade, average and market prices)
+func (c Currency) PriceScale() int32 {
+	return c.AmountScale() + extraPriceDigits
+}
+
+// Rounds an amount half to even to the minor unit
+func (c Currency) RoundAmount(d decimal.Decimal) decimal.Decimal {
+	return d.RoundBank(c.AmountScale())
+}
+
+// Rounds a unit price half to even to PriceScale
+func (c Currency) RoundPrice(d decimal.Decimal) decimal.Decimal {
+	return d.RoundBank(c.PriceScale())
+}
+
+// Reports whether d has no more than scale digits after the decimal point
+func FitsScale(d decimal.Decimal, scale int32) bool {
+	return d.Equal(d.Truncate(scale))
+}
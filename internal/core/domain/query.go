@@ -0,0 +1,80 @@
+package domain
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SortDirection string
+
+// Sort directions, trades are ordered by timestamp then id
+const (
+	Asc  SortDirection = "asc"
+	Desc SortDirection = "desc"
+)
+
+// Orders when the request asks for none: listings are newest first, exports keep the timestamp order
+// they always had, oldest first
+const (
+	DefaultSort       = Desc
+	DefaultExportSort = Asc
+)
+
+// Page size limits for trade history
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var ErrInvalidCursor = NewValidationError("cursor", "Invalid cursor")
+
+// Narrows down trade history, zero values leave a filter off. To is exclusive.
+type TradeFilter struct {
+	Ticker    string
+	Type      TradeType
+	AccountID int64
+	From      time.Time
+	To        time.Time
+
+	// Position after which the page starts, as returned in TradePage.NextCursor
+	Cursor string
+	Limit  int
+	Sort   SortDirection
+}
+
+// One page of trade history, Total counts all trades matching the filter
+type TradePage struct {
+	Trades     []*Trade `json:"trades"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+	Total      int64    `json:"total"`
+}
+
+// Opaque cursor pointing just after trade
+func EncodeTradeCursor(trade *Trade) string {
+	raw := fmt.Sprintf("%d:%d", trade.Timestamp.UnixNano(), trade.Id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Decodes a cursor into the timestamp and id it points after
+func DecodeTradeCursor(cursor string) (time.Time, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	tradeID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return time.Unix(0, n).UTC(), tradeID, nil
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Returned by price providers when a ticker has no known quote
+var ErrNoQuote = errors.New("no quote available")
+
+// Price of a ticker in one currency, a ticker listed on several exchanges has a quote per currency
+type Quote struct {
+	Ticker    string          `gorm:"primaryKey" json:"ticker"`
+	Currency  Currency        `gorm:"primaryKey;size:3;default:INR" json:"currency"`
+	Price     decimal.Decimal `gorm:"type:numeric(24,8)" json:"price" swaggertype:"number"`
+	FetchedAt time.Time       `json:"fetchedAt"`
+}
+
+// Identifies the quote of a holding, only a quote in the currency of the holding prices it
+type QuoteKey struct {
+	Ticker   string
+	Currency Currency
+}
+
+func (k QuoteKey) String() string {
+	return k.Ticker + "/" + string(k.Currency)
+}
+
+func (q *Quote) Key() QuoteKey {
+	return QuoteKey{Ticker: q.Ticker, Currency: q.Currency}
+}
+
+// Price of a holding along with how old the underlying quote is
+type PriceInfo struct {
+	Price      decimal.Decimal `json:"price" swaggertype:"number"`
+	FetchedAt  time.Time       `json:"fetchedAt"`
+	AgeSeconds float64         `json:"ageSeconds"`
+	Stale      bool            `json:"stale"`
+}
+
+// Builds the PriceInfo of a quote as seen at now, quotes older than maxAge are stale
+func (q *Quote) Info(now time.Time, maxAge time.Duration) *PriceInfo {
+	age := now.Sub(q.FetchedAt)
+	if age < 0 {
+		age = 0
+	}
+	return &PriceInfo{
+		Price:      q.Price,
+		FetchedAt:  q.FetchedAt,
+		AgeSeconds: age.Seconds(),
+		Stale:      maxAge > 0 && age > maxAge,
+	}
+}
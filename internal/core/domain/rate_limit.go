@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"math"
+	"time"
+)
+
+// Token bucket of a client holding up to Limit requests, refilled evenly so an empty bucket is full again after Period
+type Quota struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Reports whether the quota limits anything, a zero limit lets every request through
+func (q Quota) Enabled() bool {
+	return q.Limit > 0 && q.Period > 0
+}
+
+// Tokens the bucket gains over elapsed
+func (q Quota) Refill(elapsed time.Duration) float64 {
+	return float64(q.Limit) * float64(elapsed) / float64(q.Period)
+}
+
+// Time until the bucket holds tokens more than it does
+func (q Quota) Until(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens * float64(q.Period) / float64(q.Limit)))
+}
+
+// Outcome of a request against the quota, tokens is what the bucket holds after it
+func (q Quota) Result(allowed bool, tokens float64) *RateLimitResult {
+	result := &RateLimitResult{
+		Allowed:   allowed,
+		Limit:     q.Limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     q.Until(float64(q.Limit) - tokens),
+	}
+	if !allowed {
+		result.RetryAfter = q.Until(1 - tokens)
+	}
+	return result
+}
+
+// Outcome of a request against its quota, Reset is when the bucket is full again and
+// RetryAfter when the next request is let through, zero for allowed requests
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Whole seconds covering d, at least one for any wait
+func CeilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -2,6 +2,8 @@ package domain
 
 import (
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 type TradeType string
@@ -13,24 +15,67 @@ const (
 )
 
 type Trade struct {
-	Id        int64     `json:"id"`
-	UserID    string    `gorm:"index" json:"userId"`
-	Ticker    string    `json:"ticker"`
-	Type      TradeType `json:"type"`
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
-	Timestamp time.Time `json:"timestamp"`
+	Id        int64           `json:"id"`
+	UserID    string          `gorm:"index" json:"userId"`
+	AccountID int64           `gorm:"index" json:"accountId,omitempty"`
+	Ticker    string          `json:"ticker"`
+	Type      TradeType       `json:"type"`
+	Quantity  decimal.Decimal `gorm:"type:numeric(24,8)" json:"quantity" swaggertype:"number"`
+	Price     decimal.Decimal `gorm:"type:numeric(24,8)" json:"price" swaggertype:"number"`
+	Currency  Currency        `gorm:"size:3;default:INR" json:"currency"`
+	Timestamp time.Time       `json:"timestamp"`
+
+	// Lots a SELL is matched against, explicit LotIDs select specific lots
+	LotIDs    []int64         `gorm:"serializer:json" json:"lotIds,omitempty"`
+	CostBasis CostBasisMethod `json:"costBasis,omitempty"`
+
+	// Set when the trade is deleted, deleted trades stay in the ledger for audit but are left out of positions
+	DeletedAt *time.Time `gorm:"index" json:"deletedAt,omitempty"`
 }
 
+// Holding of a ticker in one account. Portfolios of a user add up the holdings of all its accounts and
+// leave AccountID out.
 type Portfolio struct {
-	UserID          string    `gorm:"index" json:"userId`
-	Ticker          string    `json:"ticker"`
-	Quantity        int       `json:"quantity"`
-	AverageBuyPrice float64   `json:"averageBuyPrice"`
-	LastUpdated     time.Time `json:"lastUpdated"`
+	UserID          string          `gorm:"index" json:"userId"`
+	AccountID       int64           `gorm:"primaryKey;autoIncrement:false" json:"accountId,omitempty"`
+	Ticker          string          `gorm:"primaryKey" json:"ticker"`
+	Quantity        decimal.Decimal `gorm:"type:numeric(24,8)" json:"quantity" swaggertype:"number"`
+	AverageBuyPrice decimal.Decimal `gorm:"type:numeric(24,8)" json:"averageBuyPrice" swaggertype:"number"`
+	RealizedPnL     decimal.Decimal `gorm:"column:realized_pnl;type:numeric(24,8)" json:"realizedPnl" swaggertype:"number"`
+	Currency        Currency        `gorm:"size:3;default:INR" json:"currency"`
+	LastUpdated     time.Time       `json:"lastUpdated"`
+
+	// Latest market price, filled in on read and never persisted
+	Price *PriceInfo `gorm:"-" json:"price,omitempty"`
 }
 
+// P&L of a user. Amounts in different currencies are never added up, Totals holds them per currency
+// and the top level totals are only given when every position is in the same currency.
 type Returns struct {
-	UserID            string  `json:"userId"`
-	CumulativeReturns float64 `json:"cumulativeReturns"`
+	UserID   string   `json:"userId"`
+	Currency Currency `json:"currency,omitempty"`
+	*ReturnTotals
+	Totals          map[Currency]*ReturnTotals `json:"totals"`
+	Positions       []*PositionReturn          `json:"positions"`
+	UnpricedTickers []string                   `json:"unpricedTickers,omitempty"`
+}
+
+// P&L of the positions in one currency, CumulativeReturns is the total of realized and unrealized P&L
+type ReturnTotals struct {
+	RealizedPnL       decimal.Decimal `json:"realizedPnl" swaggertype:"number"`
+	UnrealizedPnL     decimal.Decimal `json:"unrealizedPnl" swaggertype:"number"`
+	CumulativeReturns decimal.Decimal `json:"cumulativeReturns" swaggertype:"number"`
+}
+
+// P&L of a single ticker, Priced is false when an open holding has no quote
+type PositionReturn struct {
+	Ticker          string          `json:"ticker"`
+	Currency        Currency        `json:"currency"`
+	Quantity        decimal.Decimal `json:"quantity" swaggertype:"number"`
+	AverageBuyPrice decimal.Decimal `json:"averageBuyPrice" swaggertype:"number"`
+	Price           *PriceInfo      `json:"price,omitempty"`
+	RealizedPnL     decimal.Decimal `json:"realizedPnl" swaggertype:"number"`
+	UnrealizedPnL   decimal.Decimal `json:"unrealizedPnl" swaggertype:"number"`
+	TotalPnL        decimal.Decimal `json:"totalPnl" swaggertype:"number"`
+	Priced          bool            `json:"priced"`
 }
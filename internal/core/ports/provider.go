@@ -0,0 +1,26 @@
+package ports
+
+import (
+	"context"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+type PriceProvider interface {
+	// Latest quote for a ticker in a currency, wraps domain.ErrNoQuote when there is none
+	LatestQuote(ctx context.Context, key domain.QuoteKey) (*domain.Quote, error)
+	// Latest quotes for a batch of tickers and currencies, keys without a quote are absent from the map
+	LatestQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error)
+}
+
+type Authenticator interface {
+	// Verifies a bearer token and returns its caller, an UnauthorizedError when the token is not valid
+	Authenticate(ctx context.Context, token string) (*domain.Principal, error)
+}
+
+type RateLimitStore interface {
+	// Takes one token at now from the bucket under key, a missing bucket starts full.
+	// A request finding the bucket empty takes nothing and is reported not allowed.
+	Take(ctx context.Context, key string, quota domain.Quota, now time.Time) (*domain.RateLimitResult, error)
+}
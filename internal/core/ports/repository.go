@@ -1,16 +1,94 @@
 package ports
 
 import (
+	"context"
+	"time"
+
 	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
 )
 
 type TradeRepository interface {
-	AddTrade(trade *domain.Trade) error
-	UpdateTrade(id int64, trade *domain.Trade) error
-	RemoveTrade(id int64) error
-	FetchTrades(userID string) ([]*domain.Trade, error)
+	// Adds a trade to its account, a trade without an account goes to the default account of its user which
+	// is opened when the user has none. A ValidationError when the account is unknown or of another user.
+	AddTrade(ctx context.Context, trade *domain.Trade) error
+	// Applies the fields set on trade to the stored trade and returns the trade as stored. check sees the
+	// merged trade inside the write, an error from it leaves the stored trade unchanged.
+	UpdateTrade(ctx context.Context, id int64, trade *domain.Trade, check func(*domain.Trade) error) (*domain.Trade, error)
+	// Soft deletes a trade, it drops out of positions and history queries but stays restorable
+	RemoveTrade(ctx context.Context, id int64) error
+	RestoreTrade(ctx context.Context, id int64) (*domain.Trade, error)
+	// Trade with id, removed trades included with DeletedAt set
+	FetchTrade(ctx context.Context, id int64) (*domain.Trade, error)
+	// Audit entries of a trade, oldest first
+	FetchTradeHistory(ctx context.Context, id int64) ([]*domain.TradeAudit, error)
+	FetchTrades(ctx context.Context, userID string, filter domain.TradeFilter) (*domain.TradePage, error)
+	// Calls fn for each trade matching filter without loading them all, cursor and limit are ignored
+	StreamTrades(ctx context.Context, userID string, filter domain.TradeFilter, fn func(*domain.Trade) error) error
+	// Adds all trades atomically and returns the resulting positions, dryRun stores nothing
+	ImportTrades(ctx context.Context, trades []*domain.Trade, dryRun bool) ([]*domain.Portfolio, error)
 }
 
 type PortfolioRepository interface {
-	FetchPortfolio(userID string) ([]*domain.Portfolio, error)
+	// Positions of a user, one per account and ticker
+	FetchPortfolio(ctx context.Context, userID string) ([]*domain.Portfolio, error)
+	FetchAccountPortfolio(ctx context.Context, accountID int64) ([]*domain.Portfolio, error)
+	// Distinct tickers currently held by any user, along with the currency they are held in
+	FetchQuoteKeys(ctx context.Context) ([]domain.QuoteKey, error)
+	FetchLots(ctx context.Context, userID, ticker string) ([]*domain.Lot, error)
+	FetchCostBasisMethod(ctx context.Context, userID string) (domain.CostBasisMethod, error)
+	SetCostBasisMethod(ctx context.Context, userID string, method domain.CostBasisMethod) error
+	// Recomputes every portfolio by replaying the trade ledger
+	RebuildPortfolios(ctx context.Context) (*domain.RebuildReport, error)
+}
+
+type AccountRepository interface {
+	// Opens an account and adds its user when it is new, the first account of a user becomes its default
+	CreateAccount(ctx context.Context, account *domain.Account) error
+	// Account with id, a NotFoundError when there is none
+	FetchAccount(ctx context.Context, id int64) (*domain.Account, error)
+	// Accounts of a user, oldest first
+	FetchAccounts(ctx context.Context, userID string) ([]*domain.Account, error)
+	// Changes the name and broker set on update, Default makes the account the default of its user
+	UpdateAccount(ctx context.Context, id int64, update *domain.Account) (*domain.Account, error)
+	// Deletes an account, a ConflictError while trades belong to it. The oldest remaining account of
+	// the user takes over as default.
+	RemoveAccount(ctx context.Context, id int64) error
+}
+
+type IdempotencyRepository interface {
+	// Stores record unless its user and key are taken, the record already stored is returned in that case
+	ReserveIdempotencyKey(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error)
+	// Stores the response of a reserved key
+	CompleteIdempotencyKey(ctx context.Context, userID, key string, status int, response string) error
+	// Frees a key so the request can be made again
+	ReleaseIdempotencyKey(ctx context.Context, userID, key string) error
+	// Deletes records created before cutoff and returns how many were deleted
+	PurgeIdempotencyKeys(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) error
+	// Every key, revoked ones included, oldest first
+	FetchAPIKeys(ctx context.Context) ([]*domain.APIKey, error)
+	// Key with the given hash, a NotFoundError when there is none
+	FetchAPIKeyByHash(ctx context.Context, hash string) (*domain.APIKey, error)
+	// Marks a key revoked at the given time, revoking it again keeps the first time
+	RevokeAPIKey(ctx context.Context, id int64, at time.Time) (*domain.APIKey, error)
+	// Records that a key was used at the given time
+	TouchAPIKey(ctx context.Context, id int64, at time.Time) error
+}
+
+type AdvisorRepository interface {
+	// Links a client to an advisor, linking them again keeps the first link
+	LinkClient(ctx context.Context, link *domain.AdvisorClient) (*domain.AdvisorClient, error)
+	// Removes a link, a NotFoundError when there is none
+	UnlinkClient(ctx context.Context, advisorID, clientID string) error
+	// Clients of an advisor in the order they were linked
+	FetchClients(ctx context.Context, advisorID string) ([]*domain.AdvisorClient, error)
+	IsClient(ctx context.Context, advisorID, clientID string) (bool, error)
+}
+
+type QuoteRepository interface {
+	SaveQuotes(ctx context.Context, quotes []*domain.Quote) error
+	FetchQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error)
 }
@@ -1,17 +1,86 @@
 package ports
 
 import (
+	"context"
+
 	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
 )
 
 type TradeService interface {
-	AddTrade(trade *domain.Trade) error
-	UpdateTrade(id int64, trade *domain.Trade) error
-	RemoveTrade(id int64) error
-	FetchTrades(userID string) ([]*domain.Trade, error)
+	AddTrade(ctx context.Context, trade *domain.Trade) error
+	UpdateTrade(ctx context.Context, id int64, trade *domain.Trade) (*domain.Trade, error)
+	RemoveTrade(ctx context.Context, id int64) error
+	RestoreTrade(ctx context.Context, id int64) (*domain.Trade, error)
+	FetchTrade(ctx context.Context, id int64) (*domain.Trade, error)
+	FetchTradeHistory(ctx context.Context, id int64) ([]*domain.TradeAudit, error)
+	FetchTrades(ctx context.Context, userID string, filter domain.TradeFilter) (*domain.TradePage, error)
+	StreamTrades(ctx context.Context, userID string, filter domain.TradeFilter, fn func(*domain.Trade) error) error
+	ImportTrades(ctx context.Context, trades []*domain.Trade, dryRun bool) (*domain.ImportResult, error)
 }
 
 type PortfolioService interface {
-	FetchPortfolio(userID string) ([]*domain.Portfolio, error)
-	FetchReturns(userID string) (*domain.Returns, error)
+	// Holdings of a user added up across its accounts
+	FetchPortfolio(ctx context.Context, userID string) ([]*domain.Portfolio, error)
+	FetchAccountPortfolio(ctx context.Context, accountID int64) ([]*domain.Portfolio, error)
+	FetchReturns(ctx context.Context, userID string) (*domain.Returns, error)
+	FetchLots(ctx context.Context, userID, ticker string) ([]*domain.Lot, error)
+	FetchCostBasisMethod(ctx context.Context, userID string) (domain.CostBasisMethod, error)
+	SetCostBasisMethod(ctx context.Context, userID string, method domain.CostBasisMethod) error
+	RebuildPortfolios(ctx context.Context) (*domain.RebuildReport, error)
+}
+
+type AccountService interface {
+	// Opens an account for the user named on account, the first one becomes the default
+	CreateAccount(ctx context.Context, account *domain.Account) error
+	FetchAccount(ctx context.Context, id int64) (*domain.Account, error)
+	FetchAccounts(ctx context.Context, userID string) ([]*domain.Account, error)
+	UpdateAccount(ctx context.Context, id int64, update *domain.Account) (*domain.Account, error)
+	// Deletes an account, accounts still holding trades can't be deleted
+	RemoveAccount(ctx context.Context, id int64) error
+}
+
+type IdempotencyService interface {
+	// Claims key for userID. Returns the stored record when the request already completed,
+	// a ConflictError when it is still running or the key was used with another payload.
+	Begin(ctx context.Context, userID, key, requestHash string) (*domain.IdempotencyRecord, error)
+	// Stores the response replayed to retries of the request
+	Complete(ctx context.Context, userID, key string, status int, response string) error
+	// Frees the key of a failed request so it can be retried
+	Abort(ctx context.Context, userID, key string) error
+	// Starts purging expired keys in the background until ctx is cancelled
+	Start(ctx context.Context)
+}
+
+type APIKeyService interface {
+	// Creates a key from the name, scope and user ids of key, the returned secret is not stored
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) (*domain.NewAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]*domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) (*domain.APIKey, error)
+	// Verifies a key sent by a client, revoked and unknown keys are an UnauthorizedError
+	Authenticate(ctx context.Context, key string) (*domain.Principal, error)
+}
+
+type AccessService interface {
+	// Checks the caller on ctx holds permission, and may act for userID unless it is empty.
+	// Returns an UnauthorizedError without a caller and a ForbiddenError when access is denied.
+	Authorize(ctx context.Context, permission domain.Permission, userID string) error
+	LinkClient(ctx context.Context, advisorID, clientID string) (*domain.AdvisorClient, error)
+	UnlinkClient(ctx context.Context, advisorID, clientID string) error
+	FetchClients(ctx context.Context, advisorID string) ([]*domain.AdvisorClient, error)
+}
+
+type PriceRefresher interface {
+	// Starts refreshing in the background until ctx is cancelled or Stop is called
+	Start(ctx context.Context)
+	// Stops the background loop and waits for an in-flight refresh to finish
+	Stop()
+	// Fetches and caches quotes for every held ticker once
+	Refresh(ctx context.Context) error
+}
+
+type RateLimiter interface {
+	// Counts a request of client against its read or write quota, nil when that quota is unlimited
+	Allow(ctx context.Context, client string, write bool) (*domain.RateLimitResult, error)
+	// Counts any request from ip against the quota of its address, nil when that quota is unlimited
+	AllowAddress(ctx context.Context, ip string) (*domain.RateLimitResult, error)
 }
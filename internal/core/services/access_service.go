@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+type accessService struct {
+	advisors ports.AdvisorRepository
+}
+
+// Creates a new Access Service, the single place deciding who may do what to whose data
+func NewAccessService(advisors ports.AdvisorRepository) ports.AccessService {
+	return &accessService{advisors: advisors}
+}
+
+// Checks the role of the caller grants permission, then that userID is the caller's own,
+// one of its linked clients when it is an advisor, or one its API key allows
+func (s *accessService) Authorize(ctx context.Context, permission domain.Permission, userID string) error {
+	principal, ok := domain.PrincipalFrom(ctx)
+	if !ok {
+		return &domain.UnauthorizedError{Message: "Authentication required"}
+	}
+	if principal.ReadOnly && permission.Writes() {
+		return &domain.ForbiddenError{Message: "Read-only credentials cannot make changes"}
+	}
+	if !principal.Can(permission) {
+		return &domain.ForbiddenError{Message: fmt.Sprintf("Role %s does not allow %s", principal.Role, permission)}
+	}
+	if userID == "" || principal.CanAccess(userID) {
+		return nil
+	}
+	if principal.Role == domain.Advisor {
+		linked, err := s.advisors.IsClient(ctx, principal.UserID, userID)
+		if err != nil || linked {
+			return err
+		}
+	}
+	return &domain.ForbiddenError{Message: fmt.Sprintf("Not allowed to access the data of user %q", userID)}
+}
+
+// Lets the advisor act for the client
+func (s *accessService) LinkClient(ctx context.Context, advisorID, clientID string) (*domain.AdvisorClient, error) {
+	advisorID, clientID = strings.TrimSpace(advisorID), strings.TrimSpace(clientID)
+	if advisorID == clientID {
+		return nil, domain.NewValidationError("clientId", "An advisor cannot be their own client")
+	}
+	return s.advisors.LinkClient(ctx, &domain.AdvisorClient{AdvisorID: advisorID, ClientID: clientID, CreatedAt: time.Now().UTC()})
+}
+
+func (s *accessService) UnlinkClient(ctx context.Context, advisorID, clientID string) error {
+	return s.advisors.UnlinkClient(ctx, strings.TrimSpace(advisorID), strings.TrimSpace(clientID))
+}
+
+func (s *accessService) FetchClients(ctx context.Context, advisorID string) ([]*domain.AdvisorClient, error) {
+	return s.advisors.FetchClients(ctx, strings.TrimSpace(advisorID))
+}
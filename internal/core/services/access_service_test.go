@@ -0,0 +1,118 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+// Outcome of an authorization check
+type access int
+
+const (
+	allowed access = iota
+	forbidden
+	unauthenticated
+)
+
+func TestAuthorize(t *testing.T) {
+	repos := repositories.NewMemoryRepository()
+	service := services.NewAccessService(repos.Advisors)
+	// a1 manages c1, a2 manages nobody
+	if _, err := service.LinkClient(context.Background(), "a1", "c1"); err != nil {
+		t.Fatalf("LinkClient: %v", err)
+	}
+
+	var (
+		admin     = &domain.Principal{Subject: "root", UserID: "root", Role: domain.Admin}
+		advisor   = &domain.Principal{Subject: "a1", UserID: "a1", Role: domain.Advisor}
+		unlinked  = &domain.Principal{Subject: "a2", UserID: "a2", Role: domain.Advisor}
+		investor  = &domain.Principal{Subject: "c1", UserID: "c1", Role: domain.Investor}
+		scopedKey = &domain.Principal{Subject: "key:1", Role: domain.Service, Users: []string{"c1"}}
+		globalKey = &domain.Principal{Subject: "key:2", Role: domain.Service, AllUsers: true}
+		readKey   = &domain.Principal{Subject: "key:3", Role: domain.Service, AllUsers: true, ReadOnly: true}
+		readToken = &domain.Principal{Subject: "c1", UserID: "c1", Role: domain.Investor, ReadOnly: true}
+	)
+	// Permissions checked against a user, and the admin only ones checked without
+	userPermissions := []domain.Permission{domain.ReadTrades, domain.WriteTrades, domain.ReadPortfolio, domain.WriteSettings, domain.ManageAccounts}
+	adminPermissions := []domain.Permission{domain.RebuildLedger, domain.ManageAPIKeys, domain.ManageAdvisors}
+
+	tests := []struct {
+		name      string
+		principal *domain.Principal
+		// access to the callers own data, to c1 and to someone else
+		own, client, other access
+		// access to ImportTrades and the admin permissions
+		imports, admins access
+	}{
+		{"Admin", admin, allowed, allowed, allowed, allowed, allowed},
+		{"LinkedAdvisor", advisor, allowed, allowed, forbidden, forbidden, forbidden},
+		{"UnlinkedAdvisor", unlinked, allowed, forbidden, forbidden, forbidden, forbidden},
+		{"Investor", investor, allowed, allowed, forbidden, forbidden, forbidden},
+		// service clients have no data of their own
+		{"ScopedAPIKey", scopedKey, forbidden, allowed, forbidden, allowed, forbidden},
+		{"GlobalAPIKey", globalKey, allowed, allowed, allowed, allowed, forbidden},
+		{"Anonymous", nil, unauthenticated, unauthenticated, unauthenticated, unauthenticated, unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			own := ""
+			if tt.principal != nil {
+				ctx = domain.WithPrincipal(ctx, tt.principal)
+				own = tt.principal.UserID
+			}
+			for _, permission := range userPermissions {
+				if own != "" {
+					assertAccess(t, service.Authorize(ctx, permission, own), tt.own, permission, own)
+				}
+				assertAccess(t, service.Authorize(ctx, permission, "c1"), tt.client, permission, "c1")
+				assertAccess(t, service.Authorize(ctx, permission, "stranger"), tt.other, permission, "stranger")
+			}
+			assertAccess(t, service.Authorize(ctx, domain.ImportTrades, ""), tt.imports, domain.ImportTrades, "")
+			for _, permission := range adminPermissions {
+				assertAccess(t, service.Authorize(ctx, permission, ""), tt.admins, permission, "")
+			}
+		})
+	}
+
+	// Read-only credentials read everything they could otherwise, and change nothing
+	for _, principal := range []*domain.Principal{readKey, readToken} {
+		t.Run("ReadOnly/"+principal.Subject, func(t *testing.T) {
+			ctx := domain.WithPrincipal(context.Background(), principal)
+			for _, permission := range []domain.Permission{domain.ReadTrades, domain.ReadPortfolio} {
+				assertAccess(t, service.Authorize(ctx, permission, "c1"), allowed, permission, "c1")
+			}
+			for _, permission := range []domain.Permission{domain.WriteTrades, domain.WriteSettings, domain.ManageAccounts, domain.ImportTrades} {
+				assertAccess(t, service.Authorize(ctx, permission, "c1"), forbidden, permission, "c1")
+			}
+		})
+	}
+
+	// Unlinking takes the access away again
+	t.Run("UnlinkedClient", func(t *testing.T) {
+		if err := service.UnlinkClient(context.Background(), "a1", "c1"); err != nil {
+			t.Fatalf("UnlinkClient: %v", err)
+		}
+		ctx := domain.WithPrincipal(context.Background(), advisor)
+		assertAccess(t, service.Authorize(ctx, domain.ReadTrades, "c1"), forbidden, domain.ReadTrades, "c1")
+	})
+}
+
+func assertAccess(t *testing.T, err error, want access, permission domain.Permission, userID string) {
+	t.Helper()
+	var forbiddenErr *domain.ForbiddenError
+	var unauthErr *domain.UnauthorizedError
+	switch {
+	case want == allowed && err != nil:
+		t.Errorf("%s on %q: got %v, want allowed", permission, userID, err)
+	case want == forbidden && !errors.As(err, &forbiddenErr):
+		t.Errorf("%s on %q: got %v, want ForbiddenError", permission, userID, err)
+	case want == unauthenticated && !errors.As(err, &unauthErr):
+		t.Errorf("%s on %q: got %v, want UnauthorizedError", permission, userID, err)
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+const maxAccountFieldLength = 100
+
+type accountService struct {
+	repo ports.AccountRepository
+}
+
+// Creates a new Account Service
+func NewAccountService(repo ports.AccountRepository) ports.AccountService {
+	return &accountService{repo: repo}
+}
+
+// Opens an account for a user, the first account of a user becomes its default
+func (s *accountService) CreateAccount(ctx context.Context, account *domain.Account) error {
+	account.UserID = strings.TrimSpace(account.UserID)
+	normalizeAccount(account)
+	if err := validateAccount(account, false); err != nil {
+		return err
+	}
+	account.ID = 0
+	account.CreatedAt = time.Now().UTC()
+	return s.repo.CreateAccount(ctx, account)
+}
+
+func (s *accountService) FetchAccount(ctx context.Context, id int64) (*domain.Account, error) {
+	return s.repo.FetchAccount(ctx, id)
+}
+
+// Fetches the accounts of a user, oldest first
+func (s *accountService) FetchAccounts(ctx context.Context, userID string) ([]*domain.Account, error) {
+	return s.repo.FetchAccounts(ctx, strings.TrimSpace(userID))
+}
+
+// Renames an account or makes it the default, only the fields set on update are changed
+func (s *accountService) UpdateAccount(ctx context.Context, id int64, update *domain.Account) (*domain.Account, error) {
+	normalizeAccount(update)
+	if err := validateAccount(update, true); err != nil {
+		return nil, err
+	}
+	return s.repo.UpdateAccount(ctx, id, update)
+}
+
+// Deletes an account that holds no trades
+func (s *accountService) RemoveAccount(ctx context.Context, id int64) error {
+	return s.repo.RemoveAccount(ctx, id)
+}
+
+func normalizeAccount(account *domain.Account) {
+	account.Name = strings.TrimSpace(account.Name)
+	account.Broker = strings.TrimSpace(account.Broker)
+}
+
+// Checks the user, name and broker of an account and returns all field errors at once, partial (updates) allows an empty name
+func validateAccount(account *domain.Account, partial bool) error {
+	var fieldErrors []*domain.FieldError
+	if !partial {
+		if account.UserID == "" {
+			fieldErrors = append(fieldErrors, &domain.FieldError{Field: "userId", Message: "User ID is required"})
+		} else if len(account.UserID) > maxUserIDLength {
+			fieldErrors = append(fieldErrors, &domain.FieldError{Field: "userId", Message: fmt.Sprintf("User ID cannot be longer than %d characters", maxUserIDLength)})
+		}
+		if account.Name == "" {
+			fieldErrors = append(fieldErrors, &domain.FieldError{Field: "name", Message: "Name is required"})
+		}
+	}
+	if len(account.Name) > maxAccountFieldLength {
+		fieldErrors = append(fieldErrors, &domain.FieldError{Field: "name", Message: fmt.Sprintf("Name cannot be longer than %d characters", maxAccountFieldLength)})
+	}
+	if len(account.Broker) > maxAccountFieldLength {
+		fieldErrors = append(fieldErrors, &domain.FieldError{Field: "broker", Message: fmt.Sprintf("Broker cannot be longer than %d characters", maxAccountFieldLength)})
+	}
+	if len(fieldErrors) > 0 {
+		return &domain.ValidationError{Errors: fieldErrors}
+	}
+	return nil
+}
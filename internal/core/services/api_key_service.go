@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+const (
+	// Keys look like pk_<43 random characters>, the prefix and the first characters are kept to tell keys apart
+	apiKeyPrefix        = "pk_"
+	apiKeyBytes         = 32
+	apiKeyShownLength   = len(apiKeyPrefix) + 8
+	maxAPIKeyNameLength = 100
+	// Uses closer together than this are recorded once, so busy clients don't write on every request
+	apiKeyLastUsedResolution = time.Minute
+)
+
+type apiKeyService struct {
+	repo ports.APIKeyRepository
+}
+
+// Creates a new API Key Service
+func NewAPIKeyService(repo ports.APIKeyRepository) ports.APIKeyService {
+	return &apiKeyService{repo: repo}
+}
+
+// Creates a key, its secret is returned here only and stored as a SHA-256 hash
+func (s *apiKeyService) CreateAPIKey(ctx context.Context, key *domain.APIKey) (*domain.NewAPIKey, error) {
+	key.Name = strings.TrimSpace(key.Name)
+	userIDs := key.UserIDs
+	key.UserIDs = nil
+	for _, userID := range userIDs {
+		if userID = strings.TrimSpace(userID); userID != "" {
+			key.UserIDs = append(key.UserIDs, userID)
+		}
+	}
+	if key.Scope == "" {
+		key.Scope = domain.ReadOnly
+	}
+	now := time.Now().UTC()
+	if key.ExpiresAt != nil {
+		expiresAt := key.ExpiresAt.UTC()
+		key.ExpiresAt = &expiresAt
+	}
+	if err := validateAPIKey(key, now); err != nil {
+		return nil, err
+	}
+
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	key.ID = 0
+	key.Prefix = secret[:apiKeyShownLength]
+	key.KeyHash = hashAPIKey(secret)
+	key.CreatedBy = domain.ChangeFrom(ctx).Actor
+	key.CreatedAt = now
+	key.LastUsedAt, key.RevokedAt = nil, nil
+	if err := s.repo.CreateAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+	return &domain.NewAPIKey{APIKey: key, Key: secret}, nil
+}
+
+func (s *apiKeyService) ListAPIKeys(ctx context.Context) ([]*domain.APIKey, error) {
+	return s.repo.FetchAPIKeys(ctx)
+}
+
+// Revokes a key, requests made with it are rejected from then on
+func (s *apiKeyService) RevokeAPIKey(ctx context.Context, id int64) (*domain.APIKey, error) {
+	return s.repo.RevokeAPIKey(ctx, id, time.Now().UTC())
+}
+
+// Looks the key up by its hash and records the use
+func (s *apiKeyService) Authenticate(ctx context.Context, secret string) (*domain.Principal, error) {
+	key, err := s.repo.FetchAPIKeyByHash(ctx, hashAPIKey(secret))
+	var notFoundErr *domain.NotFoundError
+	if errors.As(err, &notFoundErr) {
+		return nil, &domain.UnauthorizedError{Message: "Invalid API key"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, &domain.UnauthorizedError{Message: "API key has been revoked"}
+	}
+	now := time.Now().UTC()
+	if key.Expired(now) {
+		return nil, &domain.UnauthorizedError{Message: "API key has expired"}
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyLastUsedResolution {
+		if err := s.repo.TouchAPIKey(ctx, key.ID, now); err != nil {
+			log.Printf("failed to record use of api key %d: %v", key.ID, err)
+		}
+	}
+	return key.Principal(), nil
+}
+
+// Checks the name, scope, user ids and expiry of a new key and returns all field errors at once
+func validateAPIKey(key *domain.APIKey, now time.Time) error {
+	var fieldErrors []*domain.FieldError
+	if key.Name == "" {
+		fieldErrors = append(fieldErrors, &domain.FieldError{Field: "name", Message: "Name is required"})
+	} else if len(key.Name) > maxAPIKeyNameLength {
+		fieldErrors = append(fieldErrors, &domain.FieldError{Field: "name", Message: fmt.Sprintf("Name cannot be longer than %d characters", maxAPIKeyNameLength)})
+	}
+	if !key.Scope.Valid() {
+		fieldErrors = append(fieldErrors, &domain.FieldError{Field: "scope", Message: "Scope must be read-only or read-write"})
+	}
+	for _, userID := range key.UserIDs {
+		if len(userID) > maxUserIDLength {
+			fieldErrors = append(fieldErrors, &domain.FieldError{Field: "userIds", Message: fmt.Sprintf("User ID cannot be longer than %d characters", maxUserIDLength)})
+			break
+		}
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		fieldErrors = append(fieldErrors, &domain.FieldError{Field: "expiresAt", Message: "Expiry must be in the future"})
+	}
+	if len(fieldErrors) > 0 {
+		return &domain.ValidationError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+// Hex SHA-256 of a key, keys are random enough that a fast hash can't be brute forced
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,188 @@
+package services_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+func sha256Hex(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	repos := repositories.NewMemoryRepository()
+	service := services.NewAPIKeyService(repos.APIKeys)
+	ctx := context.Background()
+
+	created, err := service.CreateAPIKey(ctx, &domain.APIKey{Name: " nightly import ", UserIDs: []string{" u1 ", ""}})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, "pk_") || len(created.Key) != len("pk_")+43 {
+		t.Errorf("key %q is not pk_ and 43 random characters", created.Key)
+	}
+	if created.Name != "nightly import" || created.Scope != domain.ReadOnly || len(created.UserIDs) != 1 || created.UserIDs[0] != "u1" {
+		t.Errorf("created %+v, want the trimmed name and users and the read-only default", created.APIKey)
+	}
+
+	// only the hash and a prefix too short to authenticate with are kept
+	stored, err := repos.APIKeys.FetchAPIKeyByHash(ctx, sha256Hex(created.Key))
+	if err != nil {
+		t.Fatalf("key is not stored under the SHA-256 of the secret: %v", err)
+	}
+	if stored.KeyHash == created.Key || stored.Prefix != created.Key[:len("pk_")+8] {
+		t.Errorf("stored hash %q prefix %q for key %q", stored.KeyHash, stored.Prefix, created.Key)
+	}
+	if _, err := service.Authenticate(ctx, stored.Prefix); err == nil {
+		t.Error("the prefix authenticated")
+	}
+
+	other, err := service.CreateAPIKey(ctx, &domain.APIKey{Name: "nightly import"})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if other.Key == created.Key || other.ID == created.ID {
+		t.Errorf("two keys share the secret or id: %+v %+v", created, other)
+	}
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	service := services.NewAPIKeyService(repositories.NewMemoryRepository().APIKeys)
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		key  *domain.APIKey
+		// every field reported, in order
+		fields []string
+	}{
+		{"NoName", &domain.APIKey{Name: "  "}, []string{"name"}},
+		{"LongName", &domain.APIKey{Name: strings.Repeat("k", 101)}, []string{"name"}},
+		{"UnknownScope", &domain.APIKey{Name: "k", Scope: "admin"}, []string{"scope"}},
+		{"LongUserID", &domain.APIKey{Name: "k", UserIDs: []string{strings.Repeat("u", 300)}}, []string{"userIds"}},
+		{"ExpiredAlready", &domain.APIKey{Name: "k", ExpiresAt: &past}, []string{"expiresAt"}},
+		{"Everything", &domain.APIKey{Scope: "admin", ExpiresAt: &past}, []string{"name", "scope", "expiresAt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreateAPIKey(context.Background(), tt.key)
+			var validationErr *domain.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("got %v, want a ValidationError", err)
+			}
+			var fields []string
+			for _, fieldErr := range validationErr.Errors {
+				fields = append(fields, fieldErr.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("fields %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	repos := repositories.NewMemoryRepository()
+	service := services.NewAPIKeyService(repos.APIKeys)
+	access := services.NewAccessService(repos.Advisors)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	// keys stored directly, the service can't create one that expired already
+	store := func(secret string, key *domain.APIKey) {
+		key.Name, key.Prefix, key.KeyHash, key.CreatedAt = secret, secret, sha256Hex(secret), now.Add(-time.Hour)
+		if key.Scope == "" {
+			key.Scope = domain.ReadOnly
+		}
+		if err := repos.APIKeys.CreateAPIKey(ctx, key); err != nil {
+			t.Fatalf("CreateAPIKey: %v", err)
+		}
+	}
+	store("pk_read", &domain.APIKey{})
+	store("pk_write", &domain.APIKey{Scope: domain.ReadWrite, UserIDs: []string{"u1"}})
+	store("pk_expiring", &domain.APIKey{ExpiresAt: &future})
+	store("pk_expired", &domain.APIKey{ExpiresAt: &past})
+	store("pk_revoked", &domain.APIKey{})
+	revoked, err := repos.APIKeys.FetchAPIKeyByHash(ctx, sha256Hex("pk_revoked"))
+	if err != nil {
+		t.Fatalf("FetchAPIKeyByHash: %v", err)
+	}
+	if _, err := service.RevokeAPIKey(ctx, revoked.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+
+	type check struct {
+		permission domain.Permission
+		userID     string
+		allowed    bool
+	}
+	tests := []struct {
+		name   string
+		secret string
+		// empty when the key must be rejected
+		checks []check
+	}{
+		{"ReadOnly", "pk_read", []check{
+			{domain.ReadTrades, "anyone", true},
+			{domain.ReadPortfolio, "anyone", true},
+			{domain.WriteTrades, "anyone", false},
+			{domain.ImportTrades, "", false},
+		}},
+		{"ReadWriteForOneUser", "pk_write", []check{
+			{domain.ReadTrades, "u1", true},
+			{domain.WriteTrades, "u1", true},
+			{domain.ImportTrades, "", true},
+			{domain.ReadTrades, "u2", false},
+			{domain.ManageAPIKeys, "", false},
+		}},
+		{"NotExpiredYet", "pk_expiring", []check{{domain.ReadTrades, "u1", true}}},
+		{"Expired", "pk_expired", nil},
+		{"Revoked", "pk_revoked", nil},
+		{"Unknown", "pk_unknown", nil},
+		{"Empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := service.Authenticate(ctx, tt.secret)
+			if tt.checks == nil {
+				var unauthErr *domain.UnauthorizedError
+				if !errors.As(err, &unauthErr) {
+					t.Fatalf("got %+v, %v, want an UnauthorizedError", principal, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if principal.Role != domain.Service || principal.UserID != "" {
+				t.Errorf("principal %+v, want a service client without a user", principal)
+			}
+			ctx := domain.WithPrincipal(ctx, principal)
+			for _, c := range tt.checks {
+				if err := access.Authorize(ctx, c.permission, c.userID); (err == nil) != c.allowed {
+					t.Errorf("%s on %q: got %v, want allowed %t", c.permission, c.userID, err, c.allowed)
+				}
+			}
+		})
+	}
+
+	// uses are recorded
+	used, err := repos.APIKeys.FetchAPIKeyByHash(ctx, sha256Hex("pk_read"))
+	if err != nil {
+		t.Fatalf("FetchAPIKeyByHash: %v", err)
+	}
+	if used.LastUsedAt == nil || used.LastUsedAt.Before(now) {
+		t.Errorf("lastUsedAt %v, want the time of the request", used.LastUsedAt)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+// A key still running after this long is taken to be abandoned by a crashed request
+const idempotencyLockTimeout = 5 * time.Minute
+
+type idempotencyService struct {
+	repo      ports.IdempotencyRepository
+	retention time.Duration
+}
+
+// Creates a new Idempotency Service keeping responses for retention
+func NewIdempotencyService(repo ports.IdempotencyRepository, retention time.Duration) ports.IdempotencyService {
+	return &idempotencyService{repo: repo, retention: retention}
+}
+
+// Claims key for userID, or returns what the first request with it left behind
+func (s *idempotencyService) Begin(ctx context.Context, userID, key, requestHash string) (*domain.IdempotencyRecord, error) {
+	record := &domain.IdempotencyRecord{UserID: userID, Key: key, RequestHash: requestHash, CreatedAt: time.Now().UTC()}
+	existing, err := s.repo.ReserveIdempotencyKey(ctx, record)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+
+	// An expired or abandoned key is free again, one retry of the claim is enough
+	if s.expired(existing) {
+		if err := s.repo.ReleaseIdempotencyKey(ctx, userID, key); err != nil {
+			return nil, err
+		}
+		if existing, err = s.repo.ReserveIdempotencyKey(ctx, record); err != nil || existing == nil {
+			return nil, err
+		}
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, &domain.ConflictError{Message: "Idempotency-Key was already used with a different request"}
+	}
+	if !existing.Completed() {
+		return nil, &domain.ConflictError{Message: "A request with this Idempotency-Key is still in progress"}
+	}
+	return existing, nil
+}
+
+// Stores the response replayed to retries
+func (s *idempotencyService) Complete(ctx context.Context, userID, key string, status int, response string) error {
+	return s.repo.CompleteIdempotencyKey(ctx, userID, key, status, response)
+}
+
+// Frees the key of a request that failed, so nothing is replayed for it
+func (s *idempotencyService) Abort(ctx context.Context, userID, key string) error {
+	return s.repo.ReleaseIdempotencyKey(ctx, userID, key)
+}
+
+// Purges expired keys every hour, or every retention period when that is shorter
+func (s *idempotencyService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(min(s.retention, time.Hour))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.repo.PurgeIdempotencyKeys(ctx, time.Now().UTC().Add(-s.retention)); err != nil {
+				log.Printf("idempotency key purge failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *idempotencyService) expired(record *domain.IdempotencyRecord) bool {
+	age := time.Since(record.CreatedAt)
+	return age > s.retention || (!record.Completed() && age > idempotencyLockTimeout)
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
 	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
@@ -9,35 +11,160 @@ import (
 
 type portfolioService struct {
 	portfolioRepo ports.PortfolioRepository
+	priceProvider ports.PriceProvider
+	maxPriceAge   time.Duration
 }
 
-// Creates a new Portfolio Service
-func NewPortfolioService(portfolioRepo ports.PortfolioRepository) ports.PortfolioService {
-	return &portfolioService{portfolioRepo: portfolioRepo}
+// Creates a new Portfolio Service, prices older than maxPriceAge are reported as stale
+func NewPortfolioService(portfolioRepo ports.PortfolioRepository, priceProvider ports.PriceProvider, maxPriceAge time.Duration) ports.PortfolioService {
+	return &portfolioService{portfolioRepo: portfolioRepo, priceProvider: priceProvider, maxPriceAge: maxPriceAge}
 }
 
-// Fetches a user portfolio
-func (s *portfolioService) FetchPortfolio(userID string) ([]*domain.Portfolio, error) {
-	return s.portfolioRepo.FetchPortfolio(userID)
+// Fetches a user portfolio, holdings added up across its accounts, along with the latest known price of every holding
+func (s *portfolioService) FetchPortfolio(ctx context.Context, userID string) ([]*domain.Portfolio, error) {
+	positions, err := s.portfolioRepo.FetchPortfolio(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.withPrices(ctx, domain.AggregatePortfolio(userID, positions))
+}
+
+// Fetches the holdings of one account along with their latest known prices
+func (s *portfolioService) FetchAccountPortfolio(ctx context.Context, accountID int64) ([]*domain.Portfolio, error) {
+	portfolio, err := s.portfolioRepo.FetchAccountPortfolio(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return s.withPrices(ctx, portfolio)
 }
 
-// Fetches users returns (Based on given Logic)
-func (s *portfolioService) FetchReturns(userID string) (*domain.Returns, error) {
-	portfolio, err := s.portfolioRepo.FetchPortfolio(userID)
+// Fills in the latest known price of every holding
+func (s *portfolioService) withPrices(ctx context.Context, portfolio []*domain.Portfolio) ([]*domain.Portfolio, error) {
+	quotes, err := s.latestQuotes(ctx, portfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, security := range portfolio {
+		if quote, ok := quotes[quoteKey(security)]; ok {
+			security.Price = quote.Info(now, s.maxPriceAge)
+		}
+	}
+	return portfolio, nil
+}
+
+// Fetches users realized and unrealized P&L across all its accounts against the latest market prices, totalled per
+// currency. Holdings are priced by a quote in their own currency only, open holdings without one are reported as
+// unpriced and carry no unrealized P&L.
+func (s *portfolioService) FetchReturns(ctx context.Context, userID string) (*domain.Returns, error) {
+	positions, err := s.portfolioRepo.FetchPortfolio(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch portfolio: %w", err)
 	}
+	portfolio := domain.AggregatePortfolio(userID, positions)
 
+	result := &domain.Returns{
+		UserID:       userID,
+		ReturnTotals: &domain.ReturnTotals{},
+		Totals:       map[domain.Currency]*domain.ReturnTotals{},
+		Positions:    []*domain.PositionReturn{},
+	}
 	if len(portfolio) == 0 {
-		return &domain.Returns{UserID: userID, CumulativeReturns: 0}, nil
+		return result, nil
+	}
+
+	quotes, err := s.latestQuotes(ctx, portfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, security := range portfolio {
+		position := &domain.PositionReturn{
+			Ticker:          security.Ticker,
+			Currency:        security.Currency,
+			Quantity:        security.Quantity,
+			AverageBuyPrice: security.AverageBuyPrice,
+			RealizedPnL:     security.RealizedPnL,
+			Priced:          security.Quantity.IsZero(),
+		}
+		if quote, ok := quotes[quoteKey(security)]; ok {
+			position.Price = quote.Info(now, s.maxPriceAge)
+			unrealized := quote.Price.Sub(security.AverageBuyPrice).Mul(security.Quantity)
+			position.UnrealizedPnL = security.Currency.RoundAmount(unrealized)
+			position.Priced = true
+		}
+		if !position.Priced {
+			result.UnpricedTickers = append(result.UnpricedTickers, security.Ticker)
+		}
+		position.TotalPnL = position.RealizedPnL.Add(position.UnrealizedPnL)
+
+		totals, ok := result.Totals[position.Currency]
+		if !ok {
+			totals = &domain.ReturnTotals{}
+			result.Totals[position.Currency] = totals
+		}
+		totals.RealizedPnL = totals.RealizedPnL.Add(position.RealizedPnL)
+		totals.UnrealizedPnL = totals.UnrealizedPnL.Add(position.UnrealizedPnL)
+		totals.CumulativeReturns = totals.RealizedPnL.Add(totals.UnrealizedPnL)
+		result.Positions = append(result.Positions, position)
 	}
 
-	var cumulativeReturns float64
+	// 1000 USD and 1000 INR don't add up to anything, mixed currencies only get their own totals
+	result.ReturnTotals = nil
+	for currency, totals := range result.Totals {
+		if len(result.Totals) == 1 {
+			result.Currency = currency
+			copied := *totals
+			result.ReturnTotals = &copied
+		}
+	}
+	return result, nil
+}
+
+// Fetches the tax lots of a user, ticker is optional
+func (s *portfolioService) FetchLots(ctx context.Context, userID, ticker string) ([]*domain.Lot, error) {
+	return s.portfolioRepo.FetchLots(ctx, userID, ticker)
+}
+
+// Fetches the cost basis method applied to a users sells
+func (s *portfolioService) FetchCostBasisMethod(ctx context.Context, userID string) (domain.CostBasisMethod, error) {
+	return s.portfolioRepo.FetchCostBasisMethod(ctx, userID)
+}
+
+// Sets the cost basis method for future sells, specific lots are chosen per sell and can't be a default
+func (s *portfolioService) SetCostBasisMethod(ctx context.Context, userID string, method domain.CostBasisMethod) error {
+	if !method.Valid() || method == domain.SpecificLot {
+		return domain.NewValidationError("costBasisMethod", "Cost basis method must be FIFO, LIFO or HIFO")
+	}
+	return s.portfolioRepo.SetCostBasisMethod(ctx, userID, method)
+}
+
+// Rebuilds all portfolios from the trade ledger
+func (s *portfolioService) RebuildPortfolios(ctx context.Context) (*domain.RebuildReport, error) {
+	return s.portfolioRepo.RebuildPortfolios(ctx)
+}
+
+// Looks up quotes for every open holding in the portfolio, in the currency it is held in
+func (s *portfolioService) latestQuotes(ctx context.Context, portfolio []*domain.Portfolio) (map[domain.QuoteKey]*domain.Quote, error) {
+	keys := make([]domain.QuoteKey, 0, len(portfolio))
 	for _, security := range portfolio {
-		currentPrice := 100.0 // Assuming current price is always 100
-		returns := (currentPrice - security.AverageBuyPrice) * float64(security.Quantity)
-		cumulativeReturns += returns
+		if security.Quantity.IsPositive() {
+			keys = append(keys, quoteKey(security))
+		}
+	}
+	if len(keys) == 0 {
+		return map[domain.QuoteKey]*domain.Quote{}, nil
 	}
 
-	return &domain.Returns{UserID: userID, CumulativeReturns: cumulativeReturns}, nil
+	quotes, err := s.priceProvider.LatestQuotes(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch prices: %w", err)
+	}
+	return quotes, nil
+}
+
+func quoteKey(security *domain.Portfolio) domain.QuoteKey {
+	return domain.QuoteKey{Ticker: security.Ticker, Currency: security.Currency}
 }
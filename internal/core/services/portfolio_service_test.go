@@ -0,0 +1,143 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+// Serves fixed quotes
+type stubPrices map[domain.QuoteKey]*domain.Quote
+
+func (p stubPrices) LatestQuote(ctx context.Context, key domain.QuoteKey) (*domain.Quote, error) {
+	if quote, ok := p[key]; ok {
+		return quote, nil
+	}
+	return nil, domain.ErrNoQuote
+}
+
+func (p stubPrices) LatestQuotes(ctx context.Context, keys []domain.QuoteKey) (map[domain.QuoteKey]*domain.Quote, error) {
+	result := make(map[domain.QuoteKey]*domain.Quote)
+	for _, key := range keys {
+		if quote, ok := p[key]; ok {
+			result[key] = quote
+		}
+	}
+	return result, nil
+}
+
+func prices(quotes ...*domain.Quote) stubPrices {
+	p := make(stubPrices)
+	for _, quote := range quotes {
+		p[quote.Key()] = quote
+	}
+	return p
+}
+
+func quote(ticker string, currency domain.Currency, price string) *domain.Quote {
+	return &domain.Quote{Ticker: ticker, Currency: currency, Price: decimal.RequireFromString(price), FetchedAt: time.Now()}
+}
+
+func TestFetchReturnsTotalsPerCurrency(t *testing.T) {
+	at := time.Date(2024, 1, 2, 9, 15, 0, 0, time.UTC)
+	buy := func(user, ticker string, currency domain.Currency, price string) *domain.Trade {
+		return &domain.Trade{UserID: user, Ticker: ticker, Type: domain.Buy, Quantity: decimal.NewFromInt(10),
+			Price: decimal.RequireFromString(price), Currency: currency, Timestamp: at}
+	}
+
+	tests := []struct {
+		name       string
+		trades     []*domain.Trade
+		prices     stubPrices
+		currency   domain.Currency
+		cumulative string
+		totals     map[domain.Currency]string
+		unpriced   []string
+	}{
+		{
+			name:       "SingleCurrency",
+			trades:     []*domain.Trade{buy("u1", "TCS", "INR", "100"), buy("u1", "INFY", "INR", "50")},
+			prices:     prices(quote("TCS", "INR", "110"), quote("INFY", "INR", "40")),
+			currency:   "INR",
+			cumulative: "0",
+			totals:     map[domain.Currency]string{"INR": "0"},
+		},
+		{
+			// 1000 USD and 998.77 INR have no meaningful sum
+			name:   "MixedCurrencies",
+			trades: []*domain.Trade{buy("u1", "AAPL", "USD", "100"), buy("u1", "TCS", "INR", "100")},
+			prices: prices(quote("AAPL", "USD", "200"), quote("TCS", "INR", "199.877")),
+			totals: map[domain.Currency]string{"USD": "1000", "INR": "998.77"},
+		},
+		{
+			// a quote in another currency doesn't price the holding
+			name:       "QuoteInOtherCurrency",
+			trades:     []*domain.Trade{buy("u1", "TCS", "USD", "50")},
+			prices:     prices(quote("TCS", "INR", "4000")),
+			currency:   "USD",
+			cumulative: "0",
+			totals:     map[domain.Currency]string{"USD": "0"},
+			unpriced:   []string{"TCS"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := repositories.NewMemoryRepository()
+			for _, trade := range tt.trades {
+				if err := repos.Trades.AddTrade(context.Background(), trade); err != nil {
+					t.Fatalf("AddTrade: %v", err)
+				}
+			}
+			service := services.NewPortfolioService(repos.Portfolios, tt.prices, time.Hour)
+
+			returns, err := service.FetchReturns(context.Background(), "u1")
+			if err != nil {
+				t.Fatalf("FetchReturns: %v", err)
+			}
+			if returns.Currency != tt.currency {
+				t.Errorf("currency = %q, want %q", returns.Currency, tt.currency)
+			}
+			if tt.cumulative == "" && returns.ReturnTotals != nil {
+				t.Errorf("got top level totals %+v for mixed currencies", returns.ReturnTotals)
+			}
+			if tt.cumulative != "" && (returns.ReturnTotals == nil || !returns.CumulativeReturns.Equal(decimal.RequireFromString(tt.cumulative))) {
+				t.Errorf("top level totals = %+v, want cumulative returns %s", returns.ReturnTotals, tt.cumulative)
+			}
+			if len(returns.Totals) != len(tt.totals) {
+				t.Errorf("totals = %v, want %v", returns.Totals, tt.totals)
+			}
+			for currency, want := range tt.totals {
+				got, ok := returns.Totals[currency]
+				if !ok || !got.CumulativeReturns.Equal(decimal.RequireFromString(want)) {
+					t.Errorf("%s totals = %+v, want cumulative returns %s", currency, got, want)
+				}
+			}
+			if len(returns.UnpricedTickers) != len(tt.unpriced) {
+				t.Errorf("unpriced = %v, want %v", returns.UnpricedTickers, tt.unpriced)
+			}
+		})
+	}
+}
+
+func TestSetCostBasisMethodValidation(t *testing.T) {
+	repos := repositories.NewMemoryRepository()
+	service := services.NewPortfolioService(repos.Portfolios, prices(), time.Hour)
+
+	// specific lots are chosen per sell, they can't be a user's default
+	for _, method := range []domain.CostBasisMethod{"", "LIFO-ISH", domain.SpecificLot} {
+		err := service.SetCostBasisMethod(context.Background(), "u1", method)
+		if got := fieldErrors(t, err); strings.Join(got, "\n") != "costBasisMethod: Cost basis method must be FIFO, LIFO or HIFO" {
+			t.Errorf("%q: field errors %v", method, got)
+		}
+	}
+	if err := service.SetCostBasisMethod(context.Background(), "u1", domain.HighestCost); err != nil {
+		t.Errorf("SetCostBasisMethod(HIFO): %v", err)
+	}
+}
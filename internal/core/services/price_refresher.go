@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+	"github.com/sarthak0714/backend-task-sc/pkg/utils"
+)
+
+type priceRefresher struct {
+	portfolioRepo ports.PortfolioRepository
+	quoteRepo     ports.QuoteRepository
+	priceProvider ports.PriceProvider
+	interval      time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Creates a new Price Refresher pulling quotes from priceProvider every interval
+func NewPriceRefresher(portfolioRepo ports.PortfolioRepository, quoteRepo ports.QuoteRepository, priceProvider ports.PriceProvider, interval time.Duration) ports.PriceRefresher {
+	return &priceRefresher{
+		portfolioRepo: portfolioRepo,
+		quoteRepo:     quoteRepo,
+		priceProvider: priceProvider,
+		interval:      interval,
+	}
+}
+
+// Starts the refresh loop, an initial refresh runs right away
+func (r *priceRefresher) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done != nil {
+		return
+	}
+
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := r.Refresh(ctx); err != nil {
+				log.Printf("price refresh failed: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}(r.done)
+}
+
+// Stops the refresh loop and waits for it to exit
+func (r *priceRefresher) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil {
+		return
+	}
+
+	r.cancel()
+	<-r.done
+	r.cancel, r.done = nil, nil
+}
+
+// Fetches quotes for all held tickers, in each currency they are held in, and stores them in the quote cache
+func (r *priceRefresher) Refresh(ctx context.Context) error {
+	keys, err := r.portfolioRepo.FetchQuoteKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tickers: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	quotes, err := r.priceProvider.LatestQuotes(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to fetch prices: %w", err)
+	}
+
+	// quotes keep the time the provider priced them at, so staleness reflects the price rather than the refresh
+	fetchedAt := time.Now()
+	fetched := make([]*domain.Quote, 0, len(quotes))
+	for _, quote := range quotes {
+		if quote.FetchedAt.IsZero() {
+			quote.FetchedAt = fetchedAt
+		}
+		fetched = append(fetched, quote)
+	}
+	if err := r.quoteRepo.SaveQuotes(ctx, fetched); err != nil {
+		return fmt.Errorf("failed to save quotes: %w", err)
+	}
+
+	if missing := len(keys) - len(fetched); missing > 0 {
+		log.Printf("price refresh: no quote for %d of %d tickers", missing, len(keys))
+	}
+	utils.FetchLogger()
+	return nil
+}
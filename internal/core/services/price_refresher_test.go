@@ -0,0 +1,45 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+func TestRefreshKeepsFetchedAt(t *testing.T) {
+	ctx := context.Background()
+	repos := repositories.NewMemoryRepository()
+	for _, ticker := range []string{"TCS", "INFY"} {
+		trade := &domain.Trade{UserID: "u1", Ticker: ticker, Type: domain.Buy, Quantity: decimal.NewFromInt(10),
+			Price: decimal.NewFromInt(100), Currency: domain.DefaultCurrency, Timestamp: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+		if err := repos.Trades.AddTrade(ctx, trade); err != nil {
+			t.Fatalf("AddTrade: %v", err)
+		}
+	}
+
+	// the provider priced TCS an hour ago and has no time for INFY
+	pricedAt := time.Now().Add(-time.Hour).UTC()
+	tcs, infy := quote("TCS", domain.DefaultCurrency, "110"), quote("INFY", domain.DefaultCurrency, "40")
+	tcs.FetchedAt, infy.FetchedAt = pricedAt, time.Time{}
+	refreshedAt := time.Now()
+	if err := services.NewPriceRefresher(repos.Portfolios, repos.Quotes, prices(tcs, infy), time.Hour).Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	cached, err := repos.Quotes.FetchQuotes(ctx, []domain.QuoteKey{tcs.Key(), infy.Key()})
+	if err != nil {
+		t.Fatalf("FetchQuotes: %v", err)
+	}
+	if got := cached[tcs.Key()]; got == nil || !got.FetchedAt.Equal(pricedAt) {
+		t.Errorf("TCS quote %+v, want the fetchedAt of the provider %v", got, pricedAt)
+	}
+	if got := cached[infy.Key()]; got == nil || got.FetchedAt.Before(refreshedAt) {
+		t.Errorf("INFY quote %+v, want the time of the refresh", got)
+	}
+}
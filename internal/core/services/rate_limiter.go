@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
+)
+
+type rateLimiter struct {
+	store   ports.RateLimitStore
+	read    domain.Quota
+	write   domain.Quota
+	address domain.Quota
+}
+
+// Creates a new Rate Limiter giving every client one bucket for reads and one for writes,
+// and every IP address one bucket for all its requests whoever makes them
+func NewRateLimiter(store ports.RateLimitStore, read, write, address domain.Quota) ports.RateLimiter {
+	return &rateLimiter{store: store, read: read, write: write, address: address}
+}
+
+func (l *rateLimiter) Allow(ctx context.Context, client string, write bool) (*domain.RateLimitResult, error) {
+	quota, key := l.read, "read:"+client
+	if write {
+		quota, key = l.write, "write:"+client
+	}
+	if !quota.Enabled() {
+		return nil, nil
+	}
+	return l.store.Take(ctx, key, quota, time.Now())
+}
+
+func (l *rateLimiter) AllowAddress(ctx context.Context, ip string) (*domain.RateLimitResult, error) {
+	if !l.address.Enabled() {
+		return nil, nil
+	}
+	return l.store.Take(ctx, "address:"+ip, l.address, time.Now())
+}
@@ -1,6 +1,9 @@
 package services
 
 import (
+	"context"
+	"time"
+
 	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
 	"github.com/sarthak0714/backend-task-sc/internal/core/ports"
 )
@@ -15,23 +18,85 @@ func NewTradeService(tradeRepo ports.TradeRepository, portfolioRepo ports.Portfo
 	return &tradeService{tradeRepo: tradeRepo, portfolioRepo: portfolioRepo}
 }
 
-// Adds new Trade
-func (s *tradeService) AddTrade(trade *domain.Trade) error {
-	return s.tradeRepo.AddTrade(trade)
+// Adds new Trade, the timestamp defaults to now and the currency to INR
+func (s *tradeService) AddTrade(ctx context.Context, trade *domain.Trade) error {
+	now := time.Now()
+	normalizeTrade(trade)
+	setTradeDefaults(trade, now)
+	if err := validateTrade(trade, now); err != nil {
+		return err
+	}
+	return s.tradeRepo.AddTrade(ctx, trade)
 
 }
 
-// Updates a existing trade
-func (s *tradeService) UpdateTrade(id int64, trade *domain.Trade) error {
-	return s.tradeRepo.UpdateTrade(id, trade)
+// Updates a existing trade, only the fields set on trade are changed. The trade they make up with the
+// stored fields is validated like a new trade.
+func (s *tradeService) UpdateTrade(ctx context.Context, id int64, trade *domain.Trade) (*domain.Trade, error) {
+	normalizeTrade(trade)
+	now := time.Now()
+	return s.tradeRepo.UpdateTrade(ctx, id, trade, func(merged *domain.Trade) error {
+		return validateTrade(merged, now)
+	})
 }
 
 // Removes a trade based on ID
-func (s *tradeService) RemoveTrade(id int64) error {
-	return s.tradeRepo.RemoveTrade(id)
+func (s *tradeService) RemoveTrade(ctx context.Context, id int64) error {
+	return s.tradeRepo.RemoveTrade(ctx, id)
+}
+
+// Restores a removed trade
+func (s *tradeService) RestoreTrade(ctx context.Context, id int64) (*domain.Trade, error) {
+	return s.tradeRepo.RestoreTrade(ctx, id)
+}
+
+// Fetches a trade by ID, removed trades included
+func (s *tradeService) FetchTrade(ctx context.Context, id int64) (*domain.Trade, error) {
+	return s.tradeRepo.FetchTrade(ctx, id)
+}
+
+// Fetches every recorded change of a trade
+func (s *tradeService) FetchTradeHistory(ctx context.Context, id int64) ([]*domain.TradeAudit, error) {
+	return s.tradeRepo.FetchTradeHistory(ctx, id)
 }
 
-// Fetches all trades for a User
-func (s *tradeService) FetchTrades(userID string) ([]*domain.Trade, error) {
-	return s.tradeRepo.FetchTrades(userID)
+// Fetches a page of trades for a User
+func (s *tradeService) FetchTrades(ctx context.Context, userID string, filter domain.TradeFilter) (*domain.TradePage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = domain.DefaultPageSize
+	}
+	filter.Limit = min(filter.Limit, domain.MaxPageSize)
+	return s.tradeRepo.FetchTrades(ctx, userID, filter)
+}
+
+// Streams the trades of a User matching filter
+func (s *tradeService) StreamTrades(ctx context.Context, userID string, filter domain.TradeFilter, fn func(*domain.Trade) error) error {
+	return s.tradeRepo.StreamTrades(ctx, userID, filter, fn)
+}
+
+// Imports a batch of trades atomically, dryRun only previews the resulting portfolio
+func (s *tradeService) ImportTrades(ctx context.Context, trades []*domain.Trade, dryRun bool) (*domain.ImportResult, error) {
+	now := time.Now()
+	var rowErrors []*domain.RowError
+	for i, trade := range trades {
+		normalizeTrade(trade)
+		setTradeDefaults(trade, now)
+		if err := validateTrade(trade, now); err != nil {
+			rowErrors = append(rowErrors, &domain.RowError{Row: i + 1, Error: err.Error()})
+		}
+	}
+	if len(rowErrors) > 0 {
+		return nil, &domain.ImportError{Errors: rowErrors}
+	}
+
+	portfolio, err := s.tradeRepo.ImportTrades(ctx, trades, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &domain.ImportResult{DryRun: dryRun, Portfolio: portfolio}
+	if !dryRun {
+		result.Imported = len(trades)
+	}
+	return result, nil
 }
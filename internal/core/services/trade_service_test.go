@@ -0,0 +1,170 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/adapters/repositories"
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+	"github.com/sarthak0714/backend-task-sc/internal/core/services"
+)
+
+// Buy of 10 TCS at 100 INR on Jan 1st 2024 by u1, changed by the test cases
+func validTrade() *domain.Trade {
+	return &domain.Trade{
+		UserID:    "u1",
+		Ticker:    "TCS",
+		Type:      domain.Buy,
+		Quantity:  decimal.NewFromInt(10),
+		Price:     decimal.NewFromInt(100),
+		Currency:  domain.DefaultCurrency,
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func changed(change func(*domain.Trade)) *domain.Trade {
+	trade := validTrade()
+	change(trade)
+	return trade
+}
+
+// Field errors of err as "field: message" lines, failing the test when err is no validation error
+func fieldErrors(t *testing.T, err error) []string {
+	t.Helper()
+	var validationErr *domain.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got %v, want a ValidationError", err)
+	}
+	var lines []string
+	for _, fieldErr := range validationErr.Errors {
+		lines = append(lines, fieldErr.Field+": "+fieldErr.Message)
+	}
+	return lines
+}
+
+func TestAddTradeValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		trade *domain.Trade
+		// every field error in the order they are reported, nil for a valid trade
+		want []string
+	}{
+		{"Valid", validTrade(), nil},
+		{"LowerCaseTicker", changed(func(tr *domain.Trade) { tr.Ticker = " tcs " }), nil},
+		{"Sell", changed(func(tr *domain.Trade) { tr.Type, tr.Quantity = domain.Sell, decimal.NewFromInt(5) }), nil},
+		{"NoUser", changed(func(tr *domain.Trade) { tr.UserID = "  " }), []string{"userId: User ID is required"}},
+		{"LongUser", changed(func(tr *domain.Trade) { tr.UserID = strings.Repeat("u", 65) }), []string{"userId: User ID cannot be longer than 64 characters"}},
+		{"NegativeAccount", changed(func(tr *domain.Trade) { tr.AccountID = -1 }), []string{"accountId: Account ID must be positive"}},
+		{"BadTicker", changed(func(tr *domain.Trade) { tr.Ticker = "TCS$" }), []string{`ticker: Ticker "TCS$" must be 1 to 20 letters, digits, '.', '-' or '&'`}},
+		{"UnknownType", changed(func(tr *domain.Trade) { tr.Type = "HOLD" }), []string{"type: Type must be BUY or SELL"}},
+		{"NegativeQuantity", changed(func(tr *domain.Trade) { tr.Quantity = decimal.NewFromInt(-1) }), []string{"quantity: Quantity must be positive"}},
+		{"HugeQuantity", changed(func(tr *domain.Trade) { tr.Quantity = decimal.NewFromInt(2_000_000_000) }), []string{"quantity: Quantity cannot exceed 1000000000"}},
+		{"FineQuantity", changed(func(tr *domain.Trade) { tr.Quantity = decimal.RequireFromString("0.000000001") }), []string{"quantity: Quantity cannot have more than 8 decimal places"}},
+		{"UnknownCurrency", changed(func(tr *domain.Trade) { tr.Currency = "XYZ" }), []string{`currency: Unsupported currency "XYZ"`}},
+		{"FinePrice", changed(func(tr *domain.Trade) { tr.Price = decimal.RequireFromString("100.0000001") }), []string{"price: Price cannot have more than 6 decimal places"}},
+		{"FutureTimestamp", changed(func(tr *domain.Trade) { tr.Timestamp = time.Now().Add(time.Hour) }), []string{"timestamp: Timestamp cannot be in the future"}},
+		{"AncientTimestamp", changed(func(tr *domain.Trade) { tr.Timestamp = time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC) }), []string{"timestamp: Timestamp cannot be before 1970"}},
+		{"UnknownCostBasis", changed(func(tr *domain.Trade) { tr.Type, tr.CostBasis = domain.Sell, "LIFO-ISH" }), []string{`costBasis: Unknown cost basis method "LIFO-ISH"`}},
+		{"LotsOnBuy", changed(func(tr *domain.Trade) { tr.LotIDs = []int64{1} }), []string{"lotIds: Lot IDs are only allowed on sell trades"}},
+		{
+			// every field is reported at once, in the order of the request body
+			"EveryField",
+			&domain.Trade{Ticker: "??", Type: "HOLD", Quantity: decimal.NewFromInt(-1), Price: decimal.NewFromInt(-1), Currency: "XYZ", LotIDs: []int64{1}},
+			[]string{
+				"userId: User ID is required",
+				`ticker: Ticker "??" must be 1 to 20 letters, digits, '.', '-' or '&'`,
+				"type: Type must be BUY or SELL",
+				"quantity: Quantity must be positive",
+				`currency: Unsupported currency "XYZ"`,
+				"price: Price must be positive",
+			},
+		},
+		{
+			"MissingFields",
+			&domain.Trade{UserID: "u1"},
+			[]string{"ticker: Ticker is required", "type: Type must be BUY or SELL", "quantity: Quantity must be positive", "price: Price must be positive"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := repositories.NewMemoryRepository()
+			service := services.NewTradeService(repos.Trades, repos.Portfolios)
+			ctx := context.Background()
+			// sells need a holding to sell from
+			if err := service.AddTrade(ctx, changed(func(tr *domain.Trade) { tr.Timestamp = tr.Timestamp.Add(-time.Hour) })); err != nil {
+				t.Fatalf("AddTrade: %v", err)
+			}
+
+			err := service.AddTrade(ctx, tt.trade)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("AddTrade: %v", err)
+				}
+				return
+			}
+			if got := fieldErrors(t, err); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("field errors\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestUpdateTradeValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		update *domain.Trade
+		want   []string
+	}{
+		{"PriceOnly", &domain.Trade{Price: decimal.RequireFromString("101.5")}, nil},
+		// fields left out keep their stored value and are checked with the new ones
+		{"FinePriceForStoredCurrency", &domain.Trade{Price: decimal.RequireFromString("101.5555555")}, []string{"price: Price cannot have more than 6 decimal places"}},
+		{"LotsOnStoredBuy", &domain.Trade{LotIDs: []int64{1}}, []string{"lotIds: Lot IDs are only allowed on sell trades"}},
+		{
+			"SeveralFields",
+			&domain.Trade{Ticker: "??", Type: "HOLD", Timestamp: time.Now().Add(time.Hour)},
+			[]string{
+				`ticker: Ticker "??" must be 1 to 20 letters, digits, '.', '-' or '&'`,
+				"type: Type must be BUY or SELL",
+				"timestamp: Timestamp cannot be in the future",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := repositories.NewMemoryRepository()
+			service := services.NewTradeService(repos.Trades, repos.Portfolios)
+			ctx := context.Background()
+			stored := validTrade()
+			if err := service.AddTrade(ctx, stored); err != nil {
+				t.Fatalf("AddTrade: %v", err)
+			}
+
+			updated, err := service.UpdateTrade(ctx, stored.Id, tt.update)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("UpdateTrade: %v", err)
+				}
+				if updated.Ticker != "TCS" || !updated.Price.Equal(tt.update.Price) {
+					t.Errorf("updated %+v, want the stored trade with the new price", updated)
+				}
+				return
+			}
+			if got := fieldErrors(t, err); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("field errors\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			// a rejected update leaves the trade as it was
+			unchanged, err := service.FetchTrade(ctx, stored.Id)
+			if err != nil {
+				t.Fatalf("FetchTrade: %v", err)
+			}
+			if !unchanged.Price.Equal(stored.Price) || unchanged.Ticker != stored.Ticker || unchanged.Type != stored.Type {
+				t.Errorf("trade changed to %+v", unchanged)
+			}
+		})
+	}
+}
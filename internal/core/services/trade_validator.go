@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/sarthak0714/backend-task-sc/internal/core/domain"
+)
+
+// Bounds a single trade must stay within
+var (
+	maxTradeQuantity = decimal.NewFromInt(1_000_000_000)
+	maxTradePrice    = decimal.NewFromInt(1_000_000_000_000)
+	minTimestamp     = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	tickerPattern    = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.&-]{0,19}$`)
+)
+
+const maxUserIDLength = 64
+
+// Declares the checks of one trade field, check returns the message for an invalid value or "" when it is fine.
+// Required fields are always checked, the others only when given.
+type tradeRule struct {
+	field    string
+	required bool
+	set      func(*domain.Trade) bool
+	check    func(trade *domain.Trade, now time.Time) string
+}
+
+// One rule per field, in the order errors are reported
+var tradeRules = []tradeRule{
+	{"userId", true, func(t *domain.Trade) bool { return t.UserID != "" }, func(t *domain.Trade, _ time.Time) string {
+		if strings.TrimSpace(t.UserID) == "" {
+			return "User ID is required"
+		}
+		if len(t.UserID) > maxUserIDLength {
+			return fmt.Sprintf("User ID cannot be longer than %d characters", maxUserIDLength)
+		}
+		return ""
+	}},
+	{"accountId", false, func(t *domain.Trade) bool { return t.AccountID != 0 }, func(t *domain.Trade, _ time.Time) string {
+		if t.AccountID < 0 {
+			return "Account ID must be positive"
+		}
+		return ""
+	}},
+	{"ticker", true, func(t *domain.Trade) bool { return t.Ticker != "" }, func(t *domain.Trade, _ time.Time) string {
+		if t.Ticker == "" {
+			return "Ticker is required"
+		}
+		if !tickerPattern.MatchString(t.Ticker) {
+			return fmt.Sprintf("Ticker %q must be 1 to 20 letters, digits, '.', '-' or '&'", t.Ticker)
+		}
+		return ""
+	}},
+	{"type", true, func(t *domain.Trade) bool { return t.Type != "" }, func(t *domain.Trade, _ time.Time) string {
+		if t.Type != domain.Buy && t.Type != domain.Sell {
+			return "Type must be BUY or SELL"
+		}
+		return ""
+	}},
+	{"quantity", true, func(t *domain.Trade) bool { return !t.Quantity.IsZero() }, func(t *domain.Trade, _ time.Time) string {
+		if !t.Quantity.IsPositive() {
+			return "Quantity must be positive"
+		}
+		if t.Quantity.GreaterThan(maxTradeQuantity) {
+			return fmt.Sprintf("Quantity cannot exceed %s", maxTradeQuantity)
+		}
+		if !domain.FitsScale(t.Quantity, domain.QuantityScale) {
+			return fmt.Sprintf("Quantity cannot have more than %d decimal places", domain.QuantityScale)
+		}
+		return ""
+	}},
+	{"currency", true, func(t *domain.Trade) bool { return t.Currency != "" }, func(t *domain.Trade, _ time.Time) string {
+		if !t.Currency.Valid() {
+			return fmt.Sprintf("Unsupported currency %q", t.Currency)
+		}
+		return ""
+	}},
+	{"price", true, func(t *domain.Trade) bool { return !t.Price.IsZero() }, func(t *domain.Trade, _ time.Time) string {
+		if !t.Price.IsPositive() {
+			return "Price must be positive"
+		}
+		if t.Price.GreaterThan(maxTradePrice) {
+			return fmt.Sprintf("Price cannot exceed %s", maxTradePrice)
+		}
+		// an unsupported currency is reported on its own field, the price is then held to the column scale
+		scale := int32(domain.QuantityScale)
+		if t.Currency.Valid() {
+			scale = t.Currency.PriceScale()
+		}
+		if !domain.FitsScale(t.Price, scale) {
+			return fmt.Sprintf("Price cannot have more than %d decimal places", scale)
+		}
+		return ""
+	}},
+	{"timestamp", true, func(t *domain.Trade) bool { return !t.Timestamp.IsZero() }, func(t *domain.Trade, now time.Time) string {
+		if t.Timestamp.After(now) {
+			return "Timestamp cannot be in the future"
+		}
+		if t.Timestamp.Before(minTimestamp) {
+			return "Timestamp cannot be before 1970"
+		}
+		return ""
+	}},
+	{"costBasis", false, func(t *domain.Trade) bool { return t.CostBasis != "" }, func(t *domain.Trade, _ time.Time) string {
+		if !t.CostBasis.Valid() {
+			return fmt.Sprintf("Unknown cost basis method %q", t.CostBasis)
+		}
+		return ""
+	}},
+	{"lotIds", false, func(t *domain.Trade) bool { return len(t.LotIDs) > 0 }, func(t *domain.Trade, _ time.Time) string {
+		if t.Type == domain.Buy {
+			return "Lot IDs are only allowed on sell trades"
+		}
+		return ""
+	}},
+}
+
+// Checks trade against every rule and returns all field errors at once. Updates are checked
+// once merged with the stored trade, so they meet the same rules as new trades.
+func validateTrade(trade *domain.Trade, now time.Time) error {
+	var fieldErrors []*domain.FieldError
+	for _, rule := range tradeRules {
+		if !rule.required && !rule.set(trade) {
+			continue
+		}
+		if message := rule.check(trade, now); message != "" {
+			fieldErrors = append(fieldErrors, &domain.FieldError{Field: rule.field, Message: message})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return &domain.ValidationError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+// Trims ids and upper cases the ticker, so "tcs " and "TCS" are the same position.
+// Deletion goes through RemoveTrade only, a deletedAt in the body is ignored.
+func normalizeTrade(trade *domain.Trade) {
+	trade.DeletedAt = nil
+	trade.UserID = strings.TrimSpace(trade.UserID)
+	trade.Ticker = strings.ToUpper(strings.TrimSpace(trade.Ticker))
+}
+
+// Timestamp defaults to now and currency to INR on new trades
+func setTradeDefaults(trade *domain.Trade, now time.Time) {
+	if trade.Timestamp.IsZero() {
+		trade.Timestamp = now
+	}
+	if trade.Currency == "" {
+		trade.Currency = domain.DefaultCurrency
+	}
+}